@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+type cacheStatsResponse struct {
+	Entries      int        `json:"entries"`
+	Hits         uint64     `json:"hits"`
+	Misses       uint64     `json:"misses"`
+	HitRatio     float64    `json:"hit_ratio"`
+	OldestExpiry *time.Time `json:"oldest_expiry"`
+	NewestExpiry *time.Time `json:"newest_expiry"`
+	SampleKeys   []string   `json:"sample_keys"`
+}
+
+// cacheStatsHandler shows what's inside the cache without restarting the pod
+// Hits and misses are per instance, entries depend on the backend (per pod or shared)
+func (app *application) cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	sample := 20
+	if s := r.URL.Query().Get("sample"); s != "" {
+		var err error
+		sample, err = strconv.Atoi(s)
+		if err != nil || sample < 0 || sample > 100 {
+			app.badRequestError(w, r, fmt.Errorf("invalid sample parameter"))
+			return
+		}
+	}
+
+	stats := app.cache.Stats()
+	keys := app.cache.Keys(r.URL.Query().Get("prefix"))
+	if len(keys) > sample {
+		keys = keys[:sample]
+	}
+
+	response := cacheStatsResponse{
+		Entries:    stats.Entries,
+		Hits:       stats.Hits,
+		Misses:     stats.Misses,
+		HitRatio:   stats.HitRatio(),
+		SampleKeys: keys,
+	}
+	if !stats.OldestExpiry.IsZero() {
+		response.OldestExpiry = &stats.OldestExpiry
+		response.NewestExpiry = &stats.NewestExpiry
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"cache": response}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// cacheFlushHandler empties the cache: everything, a prefix (?prefix=) or a single key (?key=)
+// The flush also goes out on the invalidation bus so the other replicas follow along,
+// saying which of the two it is: a key that ends in "*" is still just that key
+func (app *application) cacheFlushHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	prefix := r.URL.Query().Get("prefix")
+	if key != "" && prefix != "" {
+		app.badRequestError(w, r, fmt.Errorf("use either key or prefix, not both"))
+		return
+	}
+
+	var scope string
+	var flushed int
+	var invalidation models.Invalidation
+
+	switch {
+	case key != "":
+		scope = "key"
+		for _, k := range app.cache.Keys(key) {
+			if k == key {
+				flushed = 1
+				break
+			}
+		}
+		app.cache.Delete(key)
+		invalidation = models.KeyInvalidation(key)
+	case prefix != "":
+		scope = "prefix"
+		flushed = app.cache.Flush(prefix)
+		invalidation = models.PrefixInvalidation(prefix)
+	default:
+		scope = "all"
+		flushed = app.cache.Flush("")
+		invalidation = models.PrefixInvalidation("")
+	}
+
+	if app.invalidations != nil {
+		app.invalidations.Publish(invalidation)
+	}
+
+	app.logger.Info("Cache flushed",
+		"scope", scope,
+		"key", key,
+		"prefix", prefix,
+		"flushed", flushed,
+	)
+
+	err := app.writeJSON(w, http.StatusOK, envelope{
+		"scope":   scope,
+		"flushed": flushed,
+	}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
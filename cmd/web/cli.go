@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+const cliUsage = `usage: classifier [command] [flags]
+
+Without a command it runs the server. The commands manage the data and exit:
+
+  list             list classifiers, a page at a time
+  get ID           show one classifier
+  create           create a classifier
+  update ID        change the fields given, the rest stay
+  delete ID        delete a classifier
+  export           write the whole catalog to stdout or a file
+  import FILE      load a CSV or NDJSON file, - reads stdin
+  migrate ...      run the migrations, see classifier migrate
+  openapi [check]  print the OpenAPI document, or check the API against it
+
+They talk straight to the database in DB_DSN, same settings as the server, or to a
+running server with -server URL (or CLASSIFIER_SERVER). -o json prints JSON instead
+of a table. classifier COMMAND -h lists the flags of each one.
+`
+
+// cliCommand is one admin command: setup registers its flags and returns what runs it
+type cliCommand struct {
+	args  []string // the positional arguments it takes, for the usage
+	setup func(fs *flag.FlagSet) cliRunner
+}
+
+type cliRunner func(ctx context.Context, b cliBackend, args []string, out *cliOutput) error
+
+var cliCommands = map[string]cliCommand{
+	"list":   {setup: cliList},
+	"get":    {args: []string{"ID"}, setup: cliGet},
+	"create": {setup: cliCreate},
+	"update": {args: []string{"ID"}, setup: cliUpdate},
+	"delete": {args: []string{"ID"}, setup: cliDelete},
+	"export": {setup: cliExport},
+	"import": {args: []string{"FILE"}, setup: cliImport},
+}
+
+// runCommand runs the command in args[0], if there's one by that name
+// ok is false for anything else, and main goes on to start the server
+func runCommand(cfg config, args []string, stdout, stderr io.Writer) (code int, ok bool) {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(cfg, args[1:], stdout, stderr), true
+	case "openapi":
+		return runOpenAPI(cfg, args[1:], stdout, stderr), true
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return 0, true
+	}
+	if _, ok := cliCommands[args[0]]; !ok {
+		return 0, false
+	}
+	return runCLI(cfg, args[0], args[1:], stdout, stderr), true
+}
+
+func runCLI(cfg config, name string, args []string, stdout, stderr io.Writer) int {
+	cmd := cliCommands[name]
+
+	fs := flag.NewFlagSet("classifier "+name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", os.Getenv("CLASSIFIER_SERVER"), "base URL of a running server, instead of the database")
+	output := fs.String("o", "table", "output format, table or json")
+	run := cmd.setup(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: %s\n\n", strings.Join(append([]string{"classifier", name, "[flags]"}, cmd.args...), " "))
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	if err != nil {
+		return 2
+	}
+	if len(positional) != len(cmd.args) {
+		fs.Usage()
+		return 2
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(stderr, "-o has to be table or json, not %q\n", *output)
+		return 2
+	}
+
+	// Ctrl-C cancels whatever is in flight instead of leaving it half done in the dark
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Logs go to stderr and only when something's off, stdout is for the output
+	cfg.logger = slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	var backend cliBackend
+	if *server != "" {
+		backend, err = newHTTPBackend(*server)
+	} else {
+		backend, err = openDBBackend(ctx, cfg)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	defer backend.Close()
+
+	out := &cliOutput{w: stdout, json: *output == "json"}
+	if err := run(ctx, backend, positional, out); err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed lets the flags go before or after the positional arguments,
+// the flag package alone stops at the first argument that isn't one
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// optionalBool is a bool flag that can also be left out, for the nullable is_active
+type optionalBool struct {
+	value *bool
+}
+
+func (b *optionalBool) String() string {
+	if b == nil || b.value == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.value = &v
+	return nil
+}
+
+func (b *optionalBool) IsBoolFlag() bool { return true }
+
+func parseID(raw string) (int64, error) {
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid id %q", raw)
+	}
+	return id, nil
+}
+
+// parseOptionalAsOf is parseAsOf for a flag that may be empty
+func parseOptionalAsOf(raw string) (time.Time, error) {
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	return parseAsOf(raw)
+}
+
+func cliList(fs *flag.FlagSet) cliRunner {
+	page := fs.Int("page", 1, "page number")
+	pageSize := fs.Int("page-size", 20, "classifiers per page, up to 100")
+	search := fs.String("q", "", "search in names and descriptions")
+	asOf := fs.String("as-of", "", "the catalog as it was then, RFC 3339 or a date")
+
+	return func(ctx context.Context, b cliBackend, _ []string, out *cliOutput) error {
+		if *page < 1 || *pageSize < 1 || *pageSize > 100 {
+			return fmt.Errorf("-page starts at 1 and -page-size goes from 1 to 100")
+		}
+		at, err := parseOptionalAsOf(*asOf)
+		if err != nil {
+			return err
+		}
+		if !at.IsZero() && *search != "" {
+			return fmt.Errorf("-q can't be combined with -as-of")
+		}
+
+		classifiers, total, err := b.List(ctx, cliListOptions{Page: *page, PageSize: *pageSize, Search: *search, AsOf: at})
+		if err != nil {
+			return err
+		}
+		return out.classifiers(classifiers, listMetadata{
+			Total:    total,
+			Page:     *page,
+			PageSize: *pageSize,
+			Pages:    (total + *pageSize - 1) / *pageSize,
+		})
+	}
+}
+
+func cliGet(fs *flag.FlagSet) cliRunner {
+	asOf := fs.String("as-of", "", "the classifier as it was then, RFC 3339 or a date")
+
+	return func(ctx context.Context, b cliBackend, args []string, out *cliOutput) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		at, err := parseOptionalAsOf(*asOf)
+		if err != nil {
+			return err
+		}
+		c, err := b.Get(ctx, id, at)
+		if err != nil {
+			return err
+		}
+		return out.classifier(c)
+	}
+}
+
+func cliCreate(fs *flag.FlagSet) cliRunner {
+	name := fs.String("name", "", "the name, required and unique")
+	description := fs.String("description", "", "the description")
+	active := &optionalBool{}
+	fs.Var(active, "active", "is_active, -active or -active=false (left out it stays null)")
+
+	return func(ctx context.Context, b cliBackend, _ []string, out *cliOutput) error {
+		if *name == "" {
+			return fmt.Errorf("-name is required")
+		}
+		c, err := b.Create(ctx, *name, *description, active.value)
+		if err != nil {
+			return err
+		}
+		return out.classifier(c)
+	}
+}
+
+func cliUpdate(fs *flag.FlagSet) cliRunner {
+	name := fs.String("name", "", "the new name")
+	description := fs.String("description", "", "the new description, empty clears it")
+	active := &optionalBool{}
+	fs.Var(active, "active", "is_active, -active or -active=false")
+
+	return func(ctx context.Context, b cliBackend, args []string, out *cliOutput) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+
+		// Only what was on the command line changes, same as the PATCH
+		var patch models.ClassifierPatch
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "name":
+				patch.Name = name
+			case "description":
+				patch.Description = description
+			case "active":
+				patch.IsActive = active.value
+			}
+		})
+		if patch.Name == nil && patch.Description == nil && patch.IsActive == nil {
+			return fmt.Errorf("nothing to update, pass -name, -description or -active")
+		}
+		if patch.Name != nil && *patch.Name == "" {
+			return fmt.Errorf("-name can't be empty")
+		}
+
+		c, err := b.Update(ctx, id, patch)
+		if err != nil {
+			return err
+		}
+		return out.classifier(c)
+	}
+}
+
+func cliDelete(fs *flag.FlagSet) cliRunner {
+	return func(ctx context.Context, b cliBackend, args []string, out *cliOutput) error {
+		id, err := parseID(args[0])
+		if err != nil {
+			return err
+		}
+		if err := b.Delete(ctx, id); err != nil {
+			return err
+		}
+		return out.deleted(id)
+	}
+}
+
+func cliExport(fs *flag.FlagSet) cliRunner {
+	format := fs.String("format", "csv", "csv, json or ndjson")
+	search := fs.String("q", "", "only the classifiers matching this")
+	asOf := fs.String("as-of", "", "the catalog as it was then, RFC 3339 or a date")
+	file := fs.String("out", "-", "file to write, - for stdout")
+
+	return func(ctx context.Context, b cliBackend, _ []string, out *cliOutput) error {
+		if _, ok := exportContentTypes[*format]; !ok {
+			return fmt.Errorf("-format has to be csv, json or ndjson")
+		}
+		at, err := parseOptionalAsOf(*asOf)
+		if err != nil {
+			return err
+		}
+		if !at.IsZero() && *search != "" {
+			return fmt.Errorf("-q can't be combined with -as-of")
+		}
+
+		w := out.w
+		if *file != "-" {
+			f, err := os.Create(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		err = b.Export(ctx, w, *format, models.ExportOptions{Search: *search, AsOf: at})
+		if f, ok := w.(*os.File); ok && err == nil && f != os.Stdout {
+			err = f.Close()
+		}
+		return err
+	}
+}
+
+func cliImport(fs *flag.FlagSet) cliRunner {
+	format := fs.String("format", "", "csv or ndjson, by default from the file's extension")
+	onConflict := fs.String("on-conflict", "fail", "what to do with a name already taken: fail, skip or overwrite")
+	dryRun := fs.Bool("dry-run", false, "check everything and say what would happen, write nothing")
+	mapping := fs.String("map", "", "which column has each field, like name:Nombre,description:Detalle")
+
+	return func(ctx context.Context, b cliBackend, args []string, out *cliOutput) error {
+		settings := importSettings{
+			format:     *format,
+			onConflict: models.ConflictStrategy(*onConflict),
+			dryRun:     *dryRun,
+		}
+		if settings.format == "" {
+			switch strings.ToLower(filepath.Ext(args[0])) {
+			case ".csv":
+				settings.format = "csv"
+			case ".ndjson", ".jsonl":
+				settings.format = "ndjson"
+			default:
+				return fmt.Errorf("can't tell the format from %q, pass -format csv or -format ndjson", args[0])
+			}
+		}
+		if settings.format != "csv" && settings.format != "ndjson" {
+			return fmt.Errorf("-format has to be csv or ndjson")
+		}
+		switch settings.onConflict {
+		case models.ConflictFail, models.ConflictSkip, models.ConflictOverwrite:
+		default:
+			return fmt.Errorf("-on-conflict has to be fail, skip or overwrite")
+		}
+		var err error
+		if settings.columns, settings.explicit, err = parseImportMap(*mapping); err != nil {
+			return err
+		}
+
+		var in io.Reader = os.Stdin
+		if args[0] != "-" {
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		report, err := b.Import(ctx, in, settings)
+		if report != nil {
+			if err := out.importReport(report); err != nil {
+				return err
+			}
+		}
+		// A dry run with bad lines went fine, but a script still wants to know
+		if err == nil && report.ErrorCount > 0 {
+			err = fmt.Errorf("the report has %d errors", report.ErrorCount)
+		}
+		return err
+	}
+}
+
+// errImportStopped is an import that ran into a taken name with -on-conflict fail
+var errImportStopped = errors.New("the import stopped at a name that's already taken, see the report")
+
+// cliOutput prints the results as a table for people or as JSON for scripts
+type cliOutput struct {
+	w    io.Writer
+	json bool
+}
+
+func (o *cliOutput) writeJSON(v interface{}) error {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func (o *cliOutput) classifier(c *models.Classifier) error {
+	if o.json {
+		return o.writeJSON(c.Snapshot())
+	}
+	return o.table([]*models.Classifier{c})
+}
+
+func (o *cliOutput) classifiers(classifiers []*models.Classifier, meta listMetadata) error {
+	if o.json {
+		snapshots := make([]*models.ClassifierSnapshot, len(classifiers))
+		for i, c := range classifiers {
+			snapshots[i] = c.Snapshot()
+		}
+		return o.writeJSON(map[string]interface{}{"classifiers": snapshots, "metadata": meta})
+	}
+	if err := o.table(classifiers); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(o.w, "\npage %d of %d, %d classifiers\n", meta.Page, max(meta.Pages, 1), meta.Total)
+	return err
+}
+
+func (o *cliOutput) table(classifiers []*models.Classifier) error {
+	tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tDESCRIPTION\tACTIVE\tCREATED AT")
+	for _, c := range classifiers {
+		// One line per classifier, a long description gets cut
+		description := strings.Join(strings.Fields(c.Description.String), " ")
+		if runes := []rune(description); len(runes) > 50 {
+			description = string(runes[:49]) + "…"
+		}
+		active := ""
+		if c.IsActive.Valid {
+			active = strconv.FormatBool(c.IsActive.Bool)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", c.ID, c.Name, description, active, c.CreatedAt.UTC().Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func (o *cliOutput) deleted(id int64) error {
+	if o.json {
+		return o.writeJSON(map[string]int64{"deleted": id})
+	}
+	_, err := fmt.Fprintf(o.w, "deleted classifier %d\n", id)
+	return err
+}
+
+func (o *cliOutput) importReport(report *importReport) error {
+	if o.json {
+		return o.writeJSON(report)
+	}
+
+	what := "imported"
+	if report.DryRun {
+		what = "dry run, nothing written"
+	}
+	fmt.Fprintf(o.w, "%s: %d rows, %d created, %d updated, %d unchanged, %d skipped, %d errors\n",
+		what, report.Rows, report.Created, report.Updated, report.Unchanged, report.Skipped, report.ErrorCount)
+	if len(report.Errors) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(o.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nLINE\tNAME\tERROR")
+	for _, e := range report.Errors {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", e.Line, e.Name, e.Error)
+	}
+	if more := report.ErrorCount - len(report.Errors); more > 0 {
+		fmt.Fprintf(tw, "\t\tand %d more\n", more)
+	}
+	return tw.Flush()
+}
+
+// cliListOptions is a page of the list, or of the catalog as of a date
+type cliListOptions struct {
+	Page     int
+	PageSize int
+	Search   string
+	AsOf     time.Time
+}
+
+// cliBackend is where the commands send their work: the database, or a server
+type cliBackend interface {
+	List(ctx context.Context, opts cliListOptions) ([]*models.Classifier, int, error)
+	Get(ctx context.Context, id int64, asOf time.Time) (*models.Classifier, error)
+	Create(ctx context.Context, name, description string, isActive *bool) (*models.Classifier, error)
+	Update(ctx context.Context, id int64, patch models.ClassifierPatch) (*models.Classifier, error)
+	Delete(ctx context.Context, id int64) error
+	Export(ctx context.Context, w io.Writer, format string, opts models.ExportOptions) error
+	Import(ctx context.Context, r io.Reader, settings importSettings) (*importReport, error)
+	Close() error
+}
+
+// dbBackend goes through the models package like the server does, writes included:
+// they get their audit entries and change events, and the cache invalidations reach
+// the running servers over the same cache or bus
+type dbBackend struct {
+	store models.ClassifierStore
+	close func() error
+}
+
+func openDBBackend(ctx context.Context, cfg config) (*dbBackend, error) {
+	db, err := connectDB(ctx, cfg, cfg.db.dialect, cfg.db.dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to database: %w", err)
+	}
+
+	timeouts, err := cfg.queryTimeouts()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store, err := openCache(cfg)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening cache: %w", err)
+	}
+	bus, err := openInvalidationBus(cfg, db)
+	if err != nil {
+		store.Close()
+		db.Close()
+		return nil, fmt.Errorf("opening invalidation bus: %w", err)
+	}
+
+	model, err := models.NewClassifierModel(db, models.ClassifierModelOptions{
+		Cache:         store,
+		Invalidations: bus,
+		Dialect:       cfg.db.dialect,
+		Timeouts:      timeouts,
+	})
+	if err != nil {
+		if bus != nil {
+			bus.Close()
+		}
+		store.Close()
+		db.Close()
+		return nil, err
+	}
+
+	return &dbBackend{
+		store: model,
+		close: func() error {
+			errs := []error{model.Close(), model.CloseStatements()}
+			if bus != nil {
+				errs = append(errs, bus.Close())
+			}
+			return errors.Join(append(errs, db.Close())...)
+		},
+	}, nil
+}
+
+func (b *dbBackend) List(ctx context.Context, opts cliListOptions) ([]*models.Classifier, int, error) {
+	if !opts.AsOf.IsZero() {
+		return b.store.ListAsOf(ctx, models.AsOfOptions{At: opts.AsOf, Page: opts.Page, PageSize: opts.PageSize})
+	}
+	return b.store.List(ctx, models.ListClassifiersOptions{Page: opts.Page, PageSize: opts.PageSize, Search: opts.Search})
+}
+
+func (b *dbBackend) Get(ctx context.Context, id int64, asOf time.Time) (*models.Classifier, error) {
+	var c *models.Classifier
+	var err error
+	if asOf.IsZero() {
+		c, err = b.store.Get(ctx, id)
+	} else {
+		c, err = b.store.GetAsOf(ctx, id, asOf)
+	}
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, fmt.Errorf("classifier %d not found", id)
+	}
+	return c, err
+}
+
+func (b *dbBackend) Create(ctx context.Context, name, description string, isActive *bool) (*models.Classifier, error) {
+	id, err := b.store.Insert(ctx, name, description, isActive)
+	if errors.Is(err, models.ErrDuplicateName) {
+		return nil, fmt.Errorf("a classifier named %q already exists", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Get(ctx, id, time.Time{})
+}
+
+func (b *dbBackend) Update(ctx context.Context, id int64, patch models.ClassifierPatch) (*models.Classifier, error) {
+	c, err := b.store.Update(ctx, id, patch)
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		return nil, fmt.Errorf("classifier %d not found", id)
+	case errors.Is(err, models.ErrDuplicateName):
+		return nil, fmt.Errorf("a classifier named %q already exists", *patch.Name)
+	}
+	return c, err
+}
+
+func (b *dbBackend) Delete(ctx context.Context, id int64) error {
+	err := b.store.Delete(ctx, id)
+	if errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("classifier %d not found", id)
+	}
+	return err
+}
+
+// Export is the endpoint's encoder over the model's cursor, without the HTTP
+func (b *dbBackend) Export(ctx context.Context, w io.Writer, format string, opts models.ExportOptions) error {
+	buf := bufio.NewWriterSize(w, 32<<10)
+	row, end := newExportEncoder(format, buf)
+	if err := b.store.Export(ctx, opts, row); err != nil {
+		return err
+	}
+	if err := end(); err != nil {
+		return err
+	}
+	return buf.Flush()
+}
+
+func (b *dbBackend) Import(ctx context.Context, r io.Reader, settings importSettings) (*importReport, error) {
+	report, err := runImport(ctx, b.store, r, settings)
+	var conflict *models.ImportConflictError
+	if errors.As(err, &conflict) {
+		return report, errImportStopped
+	}
+	var fileErr importFileError
+	if errors.As(err, &fileErr) || errors.Is(err, errImportTooBig) {
+		return nil, err
+	}
+	return report, err
+}
+
+func (b *dbBackend) Close() error {
+	return b.close()
+}
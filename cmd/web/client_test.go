@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/pkg/client"
+)
+
+// The Go client against the real routes and handlers, on the memory store. It lives
+// here and not in pkg/client because app.routes() is in package main
+
+// newClientServer starts app.routes() on the memory store and hands back a client for it
+func newClientServer(t *testing.T) *client.Client {
+	t.Helper()
+	cfg := loadConfig()
+	cfg.logger = slog.New(slog.DiscardHandler)
+	cfg.adminToken = checkAdminToken
+
+	app := &application{config: cfg, model: models.NewMemoryClassifierStore()}
+	var err error
+	if app.cache, err = openCache(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if app.stream, err = cfg.streamSettings(); err != nil {
+		t.Fatal(err)
+	}
+	if app.legacy, err = cfg.legacySettings(); err != nil {
+		t.Fatal(err)
+	}
+	app.ready.Store(true)
+
+	srv := httptest.NewServer(app.routes())
+	t.Cleanup(srv.Close)
+
+	c, err := client.New(srv.URL, client.Options{HTTPClient: srv.Client(), MaxRetries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClientCRUD(t *testing.T) {
+	c := newClientServer(t)
+	ctx := context.Background()
+
+	created, err := c.CreateClassifier(ctx, client.CreateClassifierInput{Name: "ventas", Description: client.String("Ventas mayoristas")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == 0 || created.Name != "ventas" || created.Description == nil || *created.Description != "Ventas mayoristas" {
+		t.Fatalf("created %+v", created)
+	}
+
+	got, err := c.GetClassifier(ctx, created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != created.ID || got.Name != created.Name {
+		t.Errorf("got %+v, want %+v", got, created)
+	}
+
+	updated, err := c.UpdateClassifier(ctx, created.ID, client.ClassifierPatch{Name: client.String("ventas-ar"), IsActive: client.Bool(false)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Name != "ventas-ar" || updated.IsActive == nil || *updated.IsActive {
+		t.Errorf("updated %+v", updated)
+	}
+	if updated.Description == nil || *updated.Description != "Ventas mayoristas" {
+		t.Errorf("the patch touched the description: %+v", updated.Description)
+	}
+
+	page, err := c.ListClassifiers(ctx, client.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Classifiers) != 1 || page.Classifiers[0].Name != "ventas-ar" {
+		t.Errorf("list %+v", page.Classifiers)
+	}
+
+	if err := c.DeleteClassifier(ctx, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetClassifier(ctx, created.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetClassifier after the delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientPagination(t *testing.T) {
+	c := newClientServer(t)
+	ctx := context.Background()
+
+	const total = 23
+	for i := range total {
+		if _, err := c.CreateClassifier(ctx, client.CreateClassifierInput{Name: fmt.Sprintf("clasif-%02d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := c.ListClassifiers(ctx, client.ListOptions{Page: 2, PageSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Classifiers) != 10 || page.Metadata.Total != total || page.Metadata.Pages != 3 {
+		t.Errorf("page 2: %d rows, metadata %+v", len(page.Classifiers), page.Metadata)
+	}
+
+	seen := make(map[int64]bool)
+	for classifier, err := range c.AllClassifiers(ctx, client.ListOptions{PageSize: 5}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[classifier.ID] {
+			t.Errorf("classifier %d came twice", classifier.ID)
+		}
+		seen[classifier.ID] = true
+	}
+	if len(seen) != total {
+		t.Errorf("AllClassifiers went over %d classifiers, want %d", len(seen), total)
+	}
+
+	// Breaking out of the loop stops asking for pages
+	n := 0
+	for _, err := range c.AllClassifiers(ctx, client.ListOptions{PageSize: 5}) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n++; n == 7 {
+			break
+		}
+	}
+	if n != 7 {
+		t.Errorf("stopped after %d, want 7", n)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	c := newClientServer(t)
+	ctx := context.Background()
+
+	if _, err := c.CreateClassifier(ctx, client.CreateClassifierInput{Name: "ventas"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		call   func() error
+		status int
+		want   error
+	}{
+		{"missing classifier", func() error {
+			_, err := c.GetClassifier(ctx, 999)
+			return err
+		}, 404, client.ErrNotFound},
+		{"taken name", func() error {
+			_, err := c.CreateClassifier(ctx, client.CreateClassifierInput{Name: "ventas"})
+			return err
+		}, 409, client.ErrConflict},
+		{"empty name", func() error {
+			_, err := c.CreateClassifier(ctx, client.CreateClassifierInput{Name: ""})
+			return err
+		}, 400, client.ErrBadRequest},
+		{"page size over the cap", func() error {
+			_, err := c.ListClassifiers(ctx, client.ListOptions{PageSize: 1000})
+			return err
+		}, 400, client.ErrBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			var apiErr *client.Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("%T isn't a *client.Error", err)
+			}
+			if apiErr.StatusCode != tt.status {
+				t.Errorf("status %d, want %d", apiErr.StatusCode, tt.status)
+			}
+			// The status text is the fallback, the server's own message has to get through
+			if apiErr.Message == http.StatusText(tt.status) || apiErr.RequestID == "" {
+				t.Errorf("message %q, request id %q: want the server's", apiErr.Message, apiErr.RequestID)
+			}
+		})
+	}
+}
+
+func TestClientRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		retrySafe bool
+		write     bool
+		want      int
+	}{
+		{"GET on a plain 503", false, false, 3},
+		{"POST on a plain 503", false, true, 1},
+		{"POST on an X-Retry-Safe 503", true, true, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hits.Add(1)
+				if tt.retrySafe {
+					w.Header().Set(retrySafeHeader, "true")
+				}
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer srv.Close()
+
+			c, err := client.New(srv.URL, client.Options{HTTPClient: srv.Client(), MaxRetries: 2,
+				MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.write {
+				_, err = c.CreateClassifier(context.Background(), client.CreateClassifierInput{Name: "ventas"})
+			} else {
+				_, err = c.GetClassifier(context.Background(), 1)
+			}
+			if !errors.Is(err, client.ErrUnavailable) {
+				t.Errorf("got %v, want ErrUnavailable", err)
+			}
+			if got := int(hits.Load()); got != tt.want {
+				t.Errorf("server got %d requests, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/pkg/client"
+)
+
+// httpBackend runs the commands against a running server through its API, for when
+// the database isn't reachable from where you are (or you'd rather not touch it)
+type httpBackend struct {
+	client *client.Client
+	http   *http.Client
+}
+
+func newHTTPBackend(server string) (*httpBackend, error) {
+	// No client timeout, export and import take as long as they take; Ctrl-C cancels them
+	httpClient := &http.Client{}
+	c, err := client.New(server, client.Options{HTTPClient: httpClient, UserAgent: "classifier-cli"})
+	if err != nil {
+		return nil, fmt.Errorf("invalid server %q, use something like http://localhost:4000", server)
+	}
+	return &httpBackend{client: c, http: httpClient}, nil
+}
+
+// fromClient is the SDK's classifier as the one the table and the JSON output take
+func fromClient(c *client.Classifier) *models.Classifier {
+	classifier := &models.Classifier{ID: c.ID, Name: c.Name, CreatedAt: c.CreatedAt}
+	if c.Description != nil {
+		classifier.Description = sql.NullString{String: *c.Description, Valid: true}
+	}
+	if c.IsActive != nil {
+		classifier.IsActive = sql.NullBool{Bool: *c.IsActive, Valid: true}
+	}
+	return classifier
+}
+
+func (b *httpBackend) List(ctx context.Context, opts cliListOptions) ([]*models.Classifier, int, error) {
+	page, err := b.client.ListClassifiers(ctx, client.ListOptions{
+		Page:     opts.Page,
+		PageSize: opts.PageSize,
+		Search:   opts.Search,
+		AsOf:     opts.AsOf,
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	classifiers := make([]*models.Classifier, len(page.Classifiers))
+	for i, c := range page.Classifiers {
+		classifiers[i] = fromClient(c)
+	}
+	return classifiers, page.Metadata.Total, nil
+}
+
+func (b *httpBackend) Get(ctx context.Context, id int64, asOf time.Time) (*models.Classifier, error) {
+	c, err := b.client.GetClassifierAsOf(ctx, id, asOf)
+	if err != nil {
+		return nil, err
+	}
+	return fromClient(c), nil
+}
+
+func (b *httpBackend) Create(ctx context.Context, name, description string, isActive *bool) (*models.Classifier, error) {
+	in := client.CreateClassifierInput{Name: name, IsActive: isActive}
+	if description != "" {
+		in.Description = &description
+	}
+	c, err := b.client.CreateClassifier(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	return fromClient(c), nil
+}
+
+func (b *httpBackend) Update(ctx context.Context, id int64, patch models.ClassifierPatch) (*models.Classifier, error) {
+	c, err := b.client.UpdateClassifier(ctx, id, client.ClassifierPatch{
+		Name:        patch.Name,
+		Description: patch.Description,
+		IsActive:    patch.IsActive,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromClient(c), nil
+}
+
+func (b *httpBackend) Delete(ctx context.Context, id int64) error {
+	return b.client.DeleteClassifier(ctx, id)
+}
+
+func (b *httpBackend) Export(ctx context.Context, w io.Writer, format string, opts models.ExportOptions) error {
+	return b.client.ExportClassifiers(ctx, w, client.ExportOptions{
+		Format: client.ExportFormat(format),
+		Search: opts.Search,
+		AsOf:   opts.AsOf,
+	})
+}
+
+func (b *httpBackend) Import(ctx context.Context, r io.Reader, settings importSettings) (*importReport, error) {
+	// Back to ?map=, only the fields that were asked for
+	columns := make(map[string]string, len(settings.explicit))
+	for field := range settings.explicit {
+		columns[field] = settings.columns[field]
+	}
+
+	result, err := b.client.ImportClassifiers(ctx, r, client.ImportOptions{
+		Format:     client.ExportFormat(settings.format),
+		OnConflict: client.ConflictStrategy(settings.onConflict),
+		DryRun:     settings.dryRun,
+		Columns:    columns,
+	})
+	if result == nil {
+		return nil, err
+	}
+
+	report := &importReport{
+		DryRun:     result.DryRun,
+		Rows:       result.Rows,
+		Created:    result.Created,
+		Updated:    result.Updated,
+		Unchanged:  result.Unchanged,
+		Skipped:    result.Skipped,
+		ErrorCount: result.ErrorCount,
+		Errors:     make([]importLineError, len(result.Errors)),
+	}
+	for i, e := range result.Errors {
+		report.Errors[i] = importLineError{Line: e.Line, Name: e.Name, Error: e.Error}
+	}
+
+	switch {
+	case errors.Is(err, client.ErrConflict):
+		return report, errImportStopped
+	case errors.Is(err, client.ErrUnprocessable):
+		return report, errImportRejected
+	}
+	return report, err
+}
+
+func (b *httpBackend) Close() error {
+	b.http.CloseIdleConnections()
+	return nil
+}
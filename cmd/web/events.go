@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+// eventPublisher is where the outbox dispatcher sends every change event:
+// the logs, so you can follow the changes from there, and the webhook deliveries
+func (app *application) eventPublisher() models.EventPublisher {
+	return models.Publishers{app.logPublisher(), app.webhooks}
+}
+
+func (app *application) logPublisher() models.EventPublisher {
+	return models.EventPublisherFunc(func(ctx context.Context, event models.ChangeEvent) error {
+		app.logger.InfoContext(ctx, "Classifier changed",
+			"event_id", event.ID,
+			"type", event.Type,
+			"classifier_id", event.ClassifierID,
+			"version", event.Version,
+			"actor", event.Actor,
+		)
+		return nil
+	})
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+// exportWriteTimeout is how long a client gets to take each chunk of an export
+// The server's WriteTimeout would cut a big download at 30s, so we move it along
+const exportWriteTimeout = 10 * time.Second
+
+// exportContentTypes are the formats ?format= takes
+var exportContentTypes = map[string]string{
+	"csv":    "text/csv; charset=utf-8",
+	"json":   "application/json",
+	"ndjson": "application/x-ndjson",
+}
+
+// exportColumns is the CSV header, and the order of the fields in every row
+var exportColumns = []string{"id", "name", "description", "is_active", "created_at"}
+
+// ExportClassifiers downloads the whole catalog, with the list's q and as_of filters
+// Rows go out as they come from the database, nothing is buffered past a few KB, so a
+// big catalog costs the same memory as a small one
+func (app *application) ExportClassifiers(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		app.badRequestError(w, r, fmt.Errorf("invalid format parameter, use csv, json or ndjson"))
+		return
+	}
+
+	// Same filters as the list, same rules
+	opts := models.ExportOptions{Search: r.URL.Query().Get("q")}
+	if len(opts.Search) > 100 {
+		app.badRequestError(w, r, fmt.Errorf("invalid q parameter"))
+		return
+	}
+	filename := "classifiers-" + time.Now().UTC().Format("20060102T150405Z")
+	if raw := r.URL.Query().Get("as_of"); raw != "" {
+		asOf, err := parseAsOf(raw)
+		if err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+		if opts.Search != "" {
+			app.badRequestError(w, r, fmt.Errorf("q can't be combined with as_of"))
+			return
+		}
+		opts.AsOf = asOf
+		filename = "classifiers-" + asOf.UTC().Format("20060102T150405Z")
+	}
+
+	out := &exportWriter{
+		w:           w,
+		rc:          http.NewResponseController(w),
+		contentType: contentType,
+		filename:    filename + "." + format,
+	}
+	buf := bufio.NewWriterSize(out, 32<<10)
+	row, end := newExportEncoder(format, buf)
+
+	err := app.model.Export(r.Context(), opts, row)
+	if err == nil {
+		err = end()
+	}
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil && !out.started {
+		// An empty ndjson export is no bytes at all, the headers still have to go
+		out.start()
+	}
+	if err == nil {
+		return
+	}
+
+	if !out.started {
+		app.serverError(w, r, err)
+		return
+	}
+	// Half the file is already out with a 200: all we can do is cut the connection,
+	// so the client sees a broken download instead of a short one that looks fine
+	if !errors.Is(err, context.Canceled) {
+		app.logger.Error("Export failed halfway", "error", err, "method", r.Method, "uri", r.URL.RequestURI())
+	}
+	panic(http.ErrAbortHandler)
+}
+
+// exportWriter sends the headers with the first bytes, so an error before any row
+// can still be a proper 500. Every write pushes the deadline along
+type exportWriter struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (e *exportWriter) start() {
+	e.started = true
+	e.w.Header().Set("Content-Type", e.contentType)
+	e.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", e.filename))
+	e.w.Header().Set("Cache-Control", "no-store")
+	e.w.WriteHeader(http.StatusOK)
+}
+
+func (e *exportWriter) Write(p []byte) (int, error) {
+	if !e.started {
+		e.start()
+	}
+	e.rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	return e.w.Write(p)
+}
+
+// newExportEncoder writes the opening of the format to w (if it has one) and returns
+// what writes each row and what closes it up. Errors from writing the opening come
+// back from the first row, or from end if there are none
+func newExportEncoder(format string, w *bufio.Writer) (row func(*models.Classifier) error, end func() error) {
+	switch format {
+	case "csv":
+		// encoding/csv does the quoting, descriptions have commas, quotes and newlines
+		cw := csv.NewWriter(w)
+		cw.Write(exportColumns)
+		row = func(c *models.Classifier) error {
+			isActive := ""
+			if c.IsActive.Valid {
+				isActive = strconv.FormatBool(c.IsActive.Bool)
+			}
+			return cw.Write([]string{
+				strconv.FormatInt(c.ID, 10),
+				c.Name,
+				c.Description.String,
+				isActive,
+				c.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		end = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+	case "json":
+		// One array, written an element at a time
+		w.WriteString("[")
+		first := true
+		row = func(c *models.Classifier) error {
+			data, err := json.Marshal(c.Snapshot())
+			if err != nil {
+				return err
+			}
+			if !first {
+				w.WriteString(",")
+			}
+			first = false
+			w.WriteString("\n")
+			_, err = w.Write(data)
+			return err
+		}
+		end = func() error {
+			if !first {
+				w.WriteString("\n")
+			}
+			_, err := w.WriteString("]\n")
+			return err
+		}
+
+	default: // ndjson
+		enc := json.NewEncoder(w)
+		row = func(c *models.Classifier) error {
+			return enc.Encode(c.Snapshot())
+		}
+		end = func() error { return nil }
+	}
+	return row, end
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"classifier.buhtigexa.net/internal/models"
 )
@@ -21,7 +22,7 @@ func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 }
 
 type createClassifierRequest struct {
-	Name        string  `json:"name"`
+	Name        string  `json:"name" jsonschema:"minLength=1"`
 	Description *string `json:"description,omitempty"`
 	IsActive    *bool   `json:"is_active,omitempty"`
 }
@@ -46,13 +47,18 @@ func (app *application) CreateClassifier(w http.ResponseWriter, r *http.Request)
 		description = *req.Description
 	}
 
-	id, err := app.model.Insert(req.Name, description, req.IsActive)
+	id, err := app.model.Insert(r.Context(), req.Name, description, req.IsActive)
 	if err != nil {
-		app.serverError(w, r, err)
+		if errors.Is(err, models.ErrDuplicateName) {
+			app.conflictError(w, r, fmt.Errorf("a classifier named %q already exists", req.Name))
+		} else {
+			app.serverError(w, r, err)
+		}
 		return
 	}
 
 	// Create response with all fields
+	headers := http.Header{"Location": []string{fmt.Sprintf("/v1/classifiers/%d", id)}}
 	err = app.writeJSON(w, http.StatusCreated, envelope{
 		"classifier": map[string]interface{}{
 			"id":          id,
@@ -60,7 +66,7 @@ func (app *application) CreateClassifier(w http.ResponseWriter, r *http.Request)
 			"description": req.Description,
 			"is_active":   req.IsActive,
 		},
-	}, nil)
+	}, headers)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
@@ -73,7 +79,18 @@ func (app *application) GetClassifier(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	classifier, err := app.model.Get(id)
+	// ?as_of= reads the classifier as it was back then, straight from its history
+	var classifier *models.Classifier
+	if raw := r.URL.Query().Get("as_of"); raw != "" {
+		var asOf time.Time
+		if asOf, err = parseAsOf(raw); err != nil {
+			app.badRequestError(w, r, err)
+			return
+		}
+		classifier, err = app.model.GetAsOf(r.Context(), id, asOf)
+	} else {
+		classifier, err = app.model.Get(r.Context(), id)
+	}
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFoundError(w, r, fmt.Sprintf("%d", id))
@@ -90,33 +107,44 @@ func (app *application) GetClassifier(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) ListClassifiers(w http.ResponseWriter, r *http.Request) {
-	// Bueno, aca parseamos los params de paginacion
-	// Es importante porque si no limitamos esto, se va todo al carajo
-	page := 1
-	if p := r.URL.Query().Get("page"); p != "" {
-		var err error
-		page, err = strconv.Atoi(p)
-		if err != nil || page < 1 {
-			// Mandaron cualquier fruta en el page parameter
-			app.badRequestError(w, r, fmt.Errorf("invalid page parameter"))
-			return
-		}
+	page, pageSize, err := readPagination(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
 	}
 
-	pageSize := 20
-	if ps := r.URL.Query().Get("page_size"); ps != "" {
-		var err error
-		pageSize, err = strconv.Atoi(ps)
-		if err != nil || pageSize < 1 || pageSize > 100 {
-			app.badRequestError(w, r, fmt.Errorf("invalid page_size parameter"))
+	// Busqueda opcional por nombre o descripcion
+	search := r.URL.Query().Get("q")
+	if len(search) > 100 {
+		app.badRequestError(w, r, fmt.Errorf("invalid q parameter"))
+		return
+	}
+
+	// The catalog as of a past date comes from the history, which has no search index
+	var classifiers []*models.Classifier
+	var total int
+	if raw := r.URL.Query().Get("as_of"); raw != "" {
+		var asOf time.Time
+		if asOf, err = parseAsOf(raw); err != nil {
+			app.badRequestError(w, r, err)
 			return
 		}
+		if search != "" {
+			app.badRequestError(w, r, fmt.Errorf("q can't be combined with as_of"))
+			return
+		}
+		classifiers, total, err = app.model.ListAsOf(r.Context(), models.AsOfOptions{
+			At:       asOf,
+			Page:     page,
+			PageSize: pageSize,
+		})
+	} else {
+		classifiers, total, err = app.model.List(r.Context(), models.ListClassifiersOptions{
+			Page:     page,
+			PageSize: pageSize,
+			Search:   search,
+		})
 	}
-
-	classifiers, total, err := app.model.List(models.ListClassifiersOptions{
-		Page:     page,
-		PageSize: pageSize,
-	})
 	if err != nil {
 		app.serverError(w, r, err)
 		return
@@ -137,3 +165,109 @@ func (app *application) ListClassifiers(w http.ResponseWriter, r *http.Request)
 		app.serverError(w, r, err)
 	}
 }
+
+type updateClassifierRequest struct {
+	Name        *string `json:"name,omitempty" jsonschema:"minLength=1"`
+	Description *string `json:"description,omitempty"`
+	IsActive    *bool   `json:"is_active,omitempty"`
+}
+
+// UpdateClassifier changes only the fields present in the body
+// An empty description clears it, an empty name is a no-no
+func (app *application) UpdateClassifier(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+
+	var req updateClassifierRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+	if req.Name == nil && req.Description == nil && req.IsActive == nil {
+		app.badRequestError(w, r, fmt.Errorf("nothing to update, send name, description or is_active"))
+		return
+	}
+	if req.Name != nil && *req.Name == "" {
+		app.badRequestError(w, r, fmt.Errorf("name can't be empty"))
+		return
+	}
+
+	classifier, err := app.model.Update(r.Context(), id, models.ClassifierPatch{
+		Name:        req.Name,
+		Description: req.Description,
+		IsActive:    req.IsActive,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFoundError(w, r, fmt.Sprintf("%d", id))
+		case errors.Is(err, models.ErrDuplicateName):
+			app.conflictError(w, r, fmt.Errorf("a classifier named %q already exists", *req.Name))
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"classifier": classifier}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// DeleteClassifier answers 204, the history stays available afterwards
+func (app *application) DeleteClassifier(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+
+	if err := app.model.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFoundError(w, r, fmt.Sprintf("%d", id))
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// readPagination parses page and page_size, same rules for every paged endpoint
+func readPagination(r *http.Request) (page, pageSize int, err error) {
+	// Bueno, aca parseamos los params de paginacion
+	// Es importante porque si no limitamos esto, se va todo al carajo
+	page = 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			// Mandaron cualquier fruta en el page parameter
+			return 0, 0, fmt.Errorf("invalid page parameter")
+		}
+	}
+
+	pageSize = 20
+	if ps := r.URL.Query().Get("page_size"); ps != "" {
+		pageSize, err = strconv.Atoi(ps)
+		if err != nil || pageSize < 1 || pageSize > 100 {
+			return 0, 0, fmt.Errorf("invalid page_size parameter")
+		}
+	}
+	return page, pageSize, nil
+}
+
+// parseAsOf takes a timestamp (RFC 3339) or a plain date, which means the end of that
+// day in UTC: "as of 2025-06-30" is how things stood when June closed
+func parseAsOf(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	if day, err := time.Parse(time.DateOnly, raw); err == nil {
+		return day.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid as_of parameter, use a date (2025-06-30) or an RFC 3339 timestamp")
+}
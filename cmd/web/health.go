@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+)
+
+// healthzHandler is the liveness probe: if we can answer, we're alive
+func (app *application) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// readyzHandler is the readiness probe, red while the cache warms up or we shut down
+// That way the load balancer doesn't send us traffic when we can't serve it bien
+func (app *application) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.ready.Load() {
+		err := app.writeJSON(w, http.StatusServiceUnavailable, envelope{"status": "not ready"}, nil)
+		if err != nil {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"status": "ready"}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// startingUpHandler answers everything but the probes while we're still connecting
+func (app *application) startingUpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "5")
+	w.Header().Set(retrySafeHeader, "true") // nothing ran, so any method can come back
+	app.errorResponse(w, r, http.StatusServiceUnavailable, "che, we're still starting up, try again in a bit")
+}
@@ -1,17 +1,37 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
 )
 
 // serverError handles any internal server errors
 // Che, if something explodes internally, this is where we handle that quilombo
 func (a *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	// A query that ran out of time isn't a bug, it's the database being slow
+	switch {
+	case errors.Is(err, models.ErrUnavailable):
+		a.unavailableError(w, r, err)
+		return
+	case errors.Is(err, context.DeadlineExceeded):
+		a.timeoutError(w, r, err)
+		return
+	case errors.Is(err, context.Canceled):
+		a.canceledError(w, r, err)
+		return
+	}
+
 	// First log the error with full stack trace, re importante for debugging viste
 	a.logger.Error(err.Error(), 
 		"method", r.Method, 
 		"url", r.URL.Path,
+		"request_id", models.RequestID(r.Context()),
 		"trace", string(debug.Stack()),
 	)
 	
@@ -29,3 +49,68 @@ func (a *application) notFoundError(w http.ResponseWriter, r *http.Request, id s
 	)
 	a.errorResponse(w, r, http.StatusNotFound, "che, we couldn't find what you're looking for")
 }
+
+// unauthorizedError handles 401 responses for the protected endpoints
+// No token, wrong token, da igual: no pasas
+func (a *application) unauthorizedError(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+	a.errorResponse(w, r, http.StatusUnauthorized, "che, you need a valid admin token for this")
+}
+
+// timeoutError handles a query that hit its deadline with a 504
+// The database is our upstream and it didn't answer in time, no es culpa del cliente
+func (a *application) timeoutError(w http.ResponseWriter, r *http.Request, err error) {
+	a.logger.Warn("Database timeout",
+		"method", r.Method,
+		"url", r.URL.Path,
+		"error", err,
+	)
+	a.errorResponse(w, r, http.StatusGatewayTimeout, "che, the database took too long, try again in a bit")
+}
+
+// canceledError handles a query stopped by a cancelled context
+// If the client hung up there's nobody to answer, otherwise we're going away: 503
+func (a *application) canceledError(w http.ResponseWriter, r *http.Request, err error) {
+	if r.Context().Err() != nil {
+		a.logger.Info("Client went away before the query finished",
+			"method", r.Method,
+			"url", r.URL.Path,
+		)
+		return
+	}
+	a.logger.Warn("Query cancelled",
+		"method", r.Method,
+		"url", r.URL.Path,
+		"error", err,
+	)
+	w.Header().Set("Retry-After", "1")
+	a.errorResponse(w, r, http.StatusServiceUnavailable, "che, we can't serve this right now, try again")
+}
+
+// retrySafeHeader marks a 503 that was turned away before any work, so pkg/client
+// knows even a POST can be sent again
+const retrySafeHeader = "X-Retry-Safe"
+
+// unavailableError handles a database that's down or flapping with a 503
+// Retry-After says when the breaker will let a probe through, so clients back off solos
+// Only the breaker's rejections are X-Retry-Safe: any other 503 may come after a write
+// that went through, and sending that POST again would create or audit it twice
+func (a *application) unavailableError(w http.ResponseWriter, r *http.Request, err error) {
+	retryAfter := time.Second
+	var open *models.CircuitOpenError
+	if errors.As(err, &open) {
+		retryAfter = open.RetryAfter
+		w.Header().Set(retrySafeHeader, "true")
+	} else {
+		// Only log the real failures, the breaker rejections would flood the logs
+		a.logger.Warn("Database unavailable",
+			"method", r.Method,
+			"url", r.URL.Path,
+			"error", err,
+		)
+	}
+
+	seconds := int((retryAfter + time.Second - 1) / time.Second) // round up, 0 would mean "now"
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	a.errorResponse(w, r, http.StatusServiceUnavailable, "che, the database is having a moment, try again in a bit")
+}
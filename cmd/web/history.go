@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+// ClassifierHistory pages through every version of a classifier, newest first
+// It keeps working after the classifier is deleted, that's when you need it most
+func (app *application) ClassifierHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+
+	page, pageSize, err := readPagination(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	entries, total, err := app.model.History(r.Context(), id, models.HistoryOptions{
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	// No versions at all means it never existed, a page past the end is just empty
+	if total == 0 {
+		app.notFoundError(w, r, fmt.Sprintf("%d", id))
+		return
+	}
+
+	response := historyResponse{
+		History: entries,
+		Metadata: listMetadata{
+			Total:    total,
+			Page:     page,
+			PageSize: pageSize,
+			Pages:    (total + pageSize - 1) / pageSize,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": response}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// ClassifierDiff compares two versions field by field: ?from=2&to=5
+// from can be newer than to, then you get the changes to go back
+func (app *application) ClassifierDiff(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+
+	versions := make([]*models.AuditEntry, 0, 2)
+	for _, param := range []string{"from", "to"} {
+		version, err := strconv.Atoi(r.URL.Query().Get(param))
+		if err != nil || version < 1 {
+			app.badRequestError(w, r, fmt.Errorf("invalid %s parameter, it has to be a version number", param))
+			return
+		}
+
+		entry, err := app.model.GetVersion(r.Context(), id, version)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFoundError(w, r, fmt.Sprintf("%d@%d", id, version))
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		versions = append(versions, entry)
+	}
+
+	from, to := versions[0], versions[1]
+	response := diffResponse{
+		ClassifierID: id,
+		From:         diffVersion{Version: from.Version, Action: from.Action, Classifier: from.After},
+		To:           diffVersion{Version: to.Version, Action: to.Action, Classifier: to.After},
+		Changes:      models.DiffSnapshots(from.After, to.After),
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"diff": response}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// RevertClassifier restores ?version=N as a new version, deleted classifiers included
+func (app *application) RevertClassifier(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid version parameter"))
+		return
+	}
+
+	classifier, err := app.model.Revert(r.Context(), id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFoundError(w, r, fmt.Sprintf("%d@%d", id, version))
+		case errors.Is(err, models.ErrDeletedVersion):
+			app.conflictError(w, r, fmt.Errorf("version %d is the deletion, there's nothing to restore", version))
+		case errors.Is(err, models.ErrDuplicateName):
+			app.conflictError(w, r, fmt.Errorf("another classifier took the name this version had"))
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"classifier": classifier}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
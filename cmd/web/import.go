@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+// Limits for an upload, so one file can't keep a pod busy for the afternoon
+const (
+	importMaxBytes  = 16 << 20
+	importMaxRows   = 50000
+	importMaxErrors = 100 // reported, the count has them all
+	importBatchSize = 500 // rows per transaction
+)
+
+// importFields are the fields a row can set, what ?map= maps columns onto
+// id and created_at aren't there: an import always gets fresh ones, so an export of
+// another environment loads as is
+var importFields = []string{"name", "description", "is_active"}
+
+// importLine is a parsed row and the line of the file it came from
+type importLine struct {
+	line int
+	row  models.ImportRow
+}
+
+type importLineError struct {
+	Line  int    `json:"line"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+type importReport struct {
+	DryRun     bool              `json:"dry_run"`
+	Rows       int               `json:"rows"`
+	Created    int               `json:"created"`
+	Updated    int               `json:"updated"`
+	Unchanged  int               `json:"unchanged"`
+	Skipped    int               `json:"skipped"`
+	ErrorCount int               `json:"error_count"`
+	Errors     []importLineError `json:"errors"`
+}
+
+func (report *importReport) fail(line int, name string, err error) {
+	report.ErrorCount++
+	if len(report.Errors) < importMaxErrors {
+		report.Errors = append(report.Errors, importLineError{Line: line, Name: name, Error: err.Error()})
+	}
+}
+
+// errImportTooBig ends the parsing with a 413
+var errImportTooBig = fmt.Errorf("the file is too big, the limit is %d MB and %d rows", importMaxBytes>>20, importMaxRows)
+
+// ImportClassifiers loads a CSV or NDJSON file (the body as is, not a form)
+//
+//	?format=csv|ndjson          or the Content-Type, text/csv or application/x-ndjson
+//	?on_conflict=fail|skip|overwrite  what to do with names already taken, fail by default
+//	?dry_run=true               check everything and say what would happen, write nothing
+//	?map=name:Nombre,is_active:Activo  which column (or key) has each field
+//
+// The whole file is checked before anything is written: one bad line and nothing goes
+// in, with the report saying which lines and why. Then it's written in batches, each
+// one its own transaction; with on_conflict=fail a taken name stops the import there,
+// and the batches before it stay
+func (app *application) ImportClassifiers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	format := query.Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/x-ndjson", "application/ndjson":
+			format = "ndjson"
+		}
+	}
+	if format != "csv" && format != "ndjson" {
+		app.errorResponse(w, r, http.StatusUnsupportedMediaType, "send text/csv or application/x-ndjson, or say which with format=csv or format=ndjson")
+		return
+	}
+
+	onConflict := models.ConflictStrategy(query.Get("on_conflict"))
+	switch onConflict {
+	case "":
+		onConflict = models.ConflictFail
+	case models.ConflictFail, models.ConflictSkip, models.ConflictOverwrite:
+	default:
+		app.badRequestError(w, r, fmt.Errorf("invalid on_conflict parameter, use fail, skip or overwrite"))
+		return
+	}
+
+	var dryRun bool
+	if raw := query.Get("dry_run"); raw != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(raw); err != nil {
+			app.badRequestError(w, r, fmt.Errorf("invalid dry_run parameter"))
+			return
+		}
+	}
+
+	columns, explicit, err := parseImportMap(query.Get("map"))
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	report, err := runImport(r.Context(), app.model, http.MaxBytesReader(w, r.Body, importMaxBytes), importSettings{
+		format:     format,
+		columns:    columns,
+		explicit:   explicit,
+		onConflict: onConflict,
+		dryRun:     dryRun,
+	})
+	var tooBig *http.MaxBytesError
+	var fileErr importFileError
+	var conflict *models.ImportConflictError
+	switch {
+	case err == nil:
+		app.writeImportReport(w, r, http.StatusOK, report)
+	case errors.As(err, &tooBig) || errors.Is(err, errImportTooBig):
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, errImportTooBig.Error())
+	case errors.As(err, &fileErr):
+		app.badRequestError(w, r, err)
+	case errors.Is(err, errImportRejected):
+		app.writeImportReport(w, r, http.StatusUnprocessableEntity, report)
+	case errors.As(err, &conflict):
+		app.writeImportReport(w, r, http.StatusConflict, report)
+	default:
+		app.logger.Error("Import failed halfway", "error", err, "created", report.Created, "updated", report.Updated)
+		app.serverError(w, r, err)
+	}
+}
+
+// importSettings is what an import was asked to do, from the query or the command line
+type importSettings struct {
+	format     string // csv or ndjson
+	columns    map[string]string
+	explicit   map[string]bool
+	onConflict models.ConflictStrategy
+	dryRun     bool
+}
+
+// importFileError is a file we can't read as a whole, a 400 rather than a report
+type importFileError struct {
+	error
+}
+
+// errImportRejected means the report has errors and nothing was written
+var errImportRejected = errors.New("the file has errors, nothing was imported")
+
+// runImport reads the whole body, checks it and writes it through store in batches
+// The report is there whatever happens after the parsing: with errImportRejected it
+// has the bad lines, with an *models.ImportConflictError the line that stopped it and
+// with any other error what went in before it. A dry run with errors is no error,
+// reporting them is its whole job
+func runImport(ctx context.Context, store models.ClassifierStore, body io.Reader, settings importSettings) (*importReport, error) {
+	report := &importReport{DryRun: settings.dryRun, Errors: []importLineError{}}
+	var lines []importLine
+	var err error
+	if settings.format == "csv" {
+		lines, err = readImportCSV(body, settings.columns, settings.explicit, report)
+	} else {
+		lines, err = readImportNDJSON(body, settings.columns, report)
+	}
+	var tooBig *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooBig) || errors.Is(err, errImportTooBig):
+		return report, err
+	case err != nil:
+		return report, importFileError{err}
+	case len(lines) == 0 && report.ErrorCount == 0:
+		return report, importFileError{fmt.Errorf("the file has no rows to import")}
+	}
+	report.Rows = len(lines) + report.ErrorCount
+
+	// A name twice in the same file is a mistake whatever the strategy
+	seen := make(map[string]int, len(lines))
+	for _, l := range lines {
+		if first, ok := seen[l.row.Name]; ok {
+			report.fail(l.line, l.row.Name, fmt.Errorf("the name is repeated, it's on line %d already", first))
+			continue
+		}
+		seen[l.row.Name] = l.line
+	}
+
+	if report.ErrorCount > 0 {
+		if settings.dryRun {
+			return report, nil
+		}
+		return report, errImportRejected
+	}
+
+	for start := 0; start < len(lines); start += importBatchSize {
+		batch := lines[start:min(start+importBatchSize, len(lines))]
+		rows := make([]models.ImportRow, len(batch))
+		for i, l := range batch {
+			rows[i] = l.row
+		}
+
+		outcomes, err := store.Import(ctx, rows, models.ImportOptions{OnConflict: settings.onConflict, DryRun: settings.dryRun})
+		var conflict *models.ImportConflictError
+		if errors.As(err, &conflict) {
+			l := batch[conflict.Row]
+			stopped := "nothing was imported"
+			if start > 0 {
+				stopped = fmt.Sprintf("the lines before line %d were imported", batch[0].line)
+			}
+			report.fail(l.line, l.row.Name, fmt.Errorf("a classifier with that name already exists, the import stopped there: %s", stopped))
+			return report, err
+		}
+		if err != nil {
+			return report, err
+		}
+
+		for i, outcome := range outcomes {
+			switch outcome.Action {
+			case models.ImportCreated:
+				report.Created++
+			case models.ImportUpdated:
+				report.Updated++
+			case models.ImportUnchanged:
+				report.Unchanged++
+			case models.ImportSkipped:
+				report.Skipped++
+			case models.ImportConflict:
+				report.fail(batch[i].line, batch[i].row.Name, fmt.Errorf("a classifier with that name already exists"))
+			}
+		}
+	}
+	return report, nil
+}
+
+func (app *application) writeImportReport(w http.ResponseWriter, r *http.Request, status int, report *importReport) {
+	err := app.writeJSON(w, status, envelope{"import": report}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// parseImportMap reads ?map=field:column,... on top of the default, every field in its
+// own column. explicit has the fields the client mapped, those columns have to be there
+func parseImportMap(raw string) (columns map[string]string, explicit map[string]bool, err error) {
+	columns = make(map[string]string, len(importFields))
+	for _, field := range importFields {
+		columns[field] = field
+	}
+	explicit = make(map[string]bool)
+	if raw == "" {
+		return columns, explicit, nil
+	}
+
+	for _, pair := range strings.Split(raw, ",") {
+		field, column, ok := strings.Cut(pair, ":")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, nil, fmt.Errorf("invalid map parameter, use field:column pairs separated by commas")
+		}
+		if _, known := columns[field]; !known {
+			return nil, nil, fmt.Errorf("invalid map parameter, %q isn't a field, use %s", field, strings.Join(importFields, ", "))
+		}
+		if explicit[field] {
+			return nil, nil, fmt.Errorf("invalid map parameter, %s is mapped twice", field)
+		}
+		columns[field] = column
+		explicit[field] = true
+	}
+	return columns, explicit, nil
+}
+
+// readImportCSV needs a header row, the columns are found by name (case doesn't
+// matter) and the ones nobody asked for are ignored. Bad lines go to the report; an
+// error back means the file can't be read past some point
+func readImportCSV(body io.Reader, columns map[string]string, explicit map[string]bool, report *importReport) ([]importLine, error) {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1 // a short line is that line's problem, not the file's
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading the CSV header: %w", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff") // the BOM spreadsheets like to add
+	}
+
+	index := make(map[string]int, len(importFields))
+	for _, field := range importFields {
+		for i, name := range header {
+			if strings.EqualFold(strings.TrimSpace(name), columns[field]) {
+				index[field] = i
+				break
+			}
+		}
+		if _, ok := index[field]; !ok && (field == "name" || explicit[field]) {
+			return nil, fmt.Errorf("the CSV header has no %q column for %s", columns[field], field)
+		}
+	}
+
+	var lines []importLine
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				// Past a broken quote we can't tell where the next line starts
+				report.fail(parseErr.StartLine, "", fmt.Errorf("invalid CSV, nothing after this line was read: %w", parseErr.Err))
+				return lines, nil
+			}
+			return nil, err
+		}
+		if len(lines)+report.ErrorCount >= importMaxRows {
+			return nil, errImportTooBig
+		}
+		line, _ := cr.FieldPos(0)
+
+		value := func(field string) string {
+			if i, ok := index[field]; ok && i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		row := models.ImportRow{Name: value("name"), Description: value("description")}
+		if raw := strings.TrimSpace(value("is_active")); raw != "" {
+			active, err := strconv.ParseBool(raw)
+			if err != nil {
+				report.fail(line, row.Name, fmt.Errorf("is_active has to be true or false, not %q", raw))
+				continue
+			}
+			row.IsActive = &active
+		}
+		if err := validateImportRow(row); err != nil {
+			report.fail(line, row.Name, err)
+			continue
+		}
+		lines = append(lines, importLine{line: line, row: row})
+	}
+}
+
+// readImportNDJSON takes one JSON object per line, blank lines are fine
+func readImportNDJSON(body io.Reader, keys map[string]string, report *importReport) ([]importLine, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64<<10), 1<<20)
+
+	var lines []importLine
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if len(strings.TrimSpace(string(data))) == 0 {
+			continue
+		}
+		if len(lines)+report.ErrorCount >= importMaxRows {
+			return nil, errImportTooBig
+		}
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(data, &object); err != nil {
+			report.fail(line, "", fmt.Errorf("invalid JSON: %w", err))
+			continue
+		}
+
+		var row models.ImportRow
+		var description *string
+		var fieldErr error
+		for _, field := range importFields {
+			raw, ok := object[keys[field]]
+			if !ok {
+				continue
+			}
+			var err error
+			switch field {
+			case "name":
+				err = json.Unmarshal(raw, &row.Name)
+			case "description":
+				err = json.Unmarshal(raw, &description)
+			case "is_active":
+				err = json.Unmarshal(raw, &row.IsActive)
+			}
+			if err != nil {
+				fieldErr = fmt.Errorf("invalid %s, %s", keys[field], importTypeHint[field])
+				break
+			}
+		}
+		if description != nil {
+			row.Description = *description
+		}
+		if fieldErr == nil {
+			fieldErr = validateImportRow(row)
+		}
+		if fieldErr != nil {
+			report.fail(line, row.Name, fieldErr)
+			continue
+		}
+		lines = append(lines, importLine{line: line, row: row})
+	}
+
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("a line is longer than 1 MB, is that really NDJSON?")
+		}
+		return nil, err
+	}
+	return lines, nil
+}
+
+var importTypeHint = map[string]string{
+	"name":        "it has to be a string",
+	"description": "it has to be a string or null",
+	"is_active":   "it has to be true, false or null",
+}
+
+// validateImportRow has the same rules a create has, plus what the column can hold
+func validateImportRow(row models.ImportRow) error {
+	switch {
+	case strings.TrimSpace(row.Name) == "":
+		return fmt.Errorf("name is required")
+	case !utf8.ValidString(row.Name) || !utf8.ValidString(row.Description):
+		return fmt.Errorf("the text isn't valid UTF-8")
+	case utf8.RuneCountInString(row.Name) > 100:
+		return fmt.Errorf("name is longer than 100 characters")
+	}
+	return nil
+}
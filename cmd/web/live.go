@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/internal/websocket"
+)
+
+// The WebSocket protocol, JSON text messages both ways. The client sends
+//
+//	{"op": "subscribe", "ref": "a", "ids": [1, 2]}        those classifiers
+//	{"op": "subscribe", "ref": "b", "subtree": "ventas/"} names starting with that
+//	{"op": "unsubscribe", "ref": "c", "subscription": 1}
+//	{"op": "ping", "ref": "d"}
+//
+// and gets back subscribed/unsubscribed/pong with the same ref, errors with it too,
+// and {"type": "event", "subscriptions": [1], "event": {...}} for every change that
+// matches. "reset" means events were lost and whatever the client keeps needs a reload
+type liveRequest struct {
+	Op           string  `json:"op"`
+	Ref          string  `json:"ref,omitempty"` // echoed back, so the client can match the answer
+	IDs          []int64 `json:"ids,omitempty"`
+	Subtree      string  `json:"subtree,omitempty"`
+	Subscription int     `json:"subscription,omitempty"`
+}
+
+type liveMessage struct {
+	Type          string              `json:"type"`
+	Ref           string              `json:"ref,omitempty"`
+	Subscription  int                 `json:"subscription,omitempty"`
+	Subscriptions []int               `json:"subscriptions,omitempty"`
+	Event         *models.ChangeEvent `json:"event,omitempty"`
+	Error         string              `json:"error,omitempty"`
+}
+
+// Limits per connection, so one client can't make us match events forever
+const (
+	liveMaxSubscriptions = 100
+	liveMaxIDs           = 1000
+	liveMaxMessage       = 64 << 10
+)
+
+// liveFilter is one subscription: a set of ids or a subtree, a name prefix
+type liveFilter struct {
+	ids     map[int64]bool
+	subtree string
+}
+
+// matches counts a rename in or out of the subtree too, the client wants to know both
+func (f liveFilter) matches(event models.ChangeEvent) bool {
+	if f.ids != nil {
+		return f.ids[event.ClassifierID]
+	}
+	return (event.Before != nil && strings.HasPrefix(event.Before.Name, f.subtree)) ||
+		(event.After != nil && strings.HasPrefix(event.After.Name, f.subtree))
+}
+
+// liveSession is one connection: the reader handles requests, the writer drains the
+// send queue and pings, and the events loop matches the feed against the filters
+type liveSession struct {
+	app  *application
+	conn *websocket.Conn
+	send chan []byte
+
+	mu      sync.Mutex
+	filters map[int]liveFilter
+	nextSub int
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// LiveClassifiers is the WebSocket endpoint, for clients that want to pick what they
+// hear about instead of the whole stream
+func (app *application) LiveClassifiers(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Upgrade(w, r, websocket.UpgradeOptions{AllowedOrigins: app.stream.origins})
+	if err != nil {
+		if errors.Is(err, websocket.ErrBadOrigin) {
+			app.errorResponse(w, r, http.StatusForbidden, "origin not allowed, see WS_ALLOWED_ORIGINS")
+		} else if errors.Is(err, websocket.ErrBadHandshake) {
+			w.Header().Set("Sec-WebSocket-Version", "13")
+			app.badRequestError(w, r, err)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	conn.SetReadLimit(liveMaxMessage)
+
+	s := &liveSession{
+		app:     app,
+		conn:    conn,
+		send:    make(chan []byte, app.stream.queue),
+		filters: make(map[int]liveFilter),
+		done:    make(chan struct{}),
+	}
+	app.websockets.Add(1)
+	defer app.websockets.Add(-1)
+
+	go s.writeLoop()
+	go s.eventLoop(app.feed.Subscribe(0, app.stream.queue))
+	s.readLoop()
+	s.close(websocket.CloseNormal, "")
+}
+
+// close ends the session once: the close frame if we still can, then the connection
+func (s *liveSession) close(code int, reason string) {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		s.conn.WriteClose(code, reason)
+		s.conn.Close()
+	})
+}
+
+// enqueue hands a message to the writer. A full queue means the client isn't reading:
+// we hang up rather than pile up memory, it can come back
+func (s *liveSession) enqueue(msg liveMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		s.app.logger.Error("Error encoding websocket message", "error", err)
+		return
+	}
+	select {
+	case s.send <- data:
+	case <-s.done:
+	default:
+		s.app.logger.Warn("Websocket dropped, the client wasn't keeping up", "remote", s.conn.RemoteAddr().String())
+		s.close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// readLoop handles the requests. Without a pong (or anything) in two heartbeats the
+// client is gone and the read times out
+func (s *liveSession) readLoop() {
+	deadline := func() { s.conn.SetReadDeadline(time.Now().Add(2 * s.app.stream.heartbeat)) }
+	deadline()
+	s.conn.SetPongHandler(deadline)
+
+	for {
+		messageType, data, err := s.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		deadline()
+		if messageType != websocket.TextMessage {
+			s.enqueue(liveMessage{Type: "error", Error: "messages have to be JSON text"})
+			continue
+		}
+
+		var req liveRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			s.enqueue(liveMessage{Type: "error", Error: "invalid JSON: " + err.Error()})
+			continue
+		}
+		s.handle(req)
+	}
+}
+
+func (s *liveSession) handle(req liveRequest) {
+	fail := func(err error) {
+		s.enqueue(liveMessage{Type: "error", Ref: req.Ref, Error: err.Error()})
+	}
+
+	switch req.Op {
+	case "subscribe":
+		filter, err := newLiveFilter(req)
+		if err != nil {
+			fail(err)
+			return
+		}
+		s.mu.Lock()
+		if len(s.filters) >= liveMaxSubscriptions {
+			s.mu.Unlock()
+			fail(fmt.Errorf("too many subscriptions, the limit is %d", liveMaxSubscriptions))
+			return
+		}
+		s.nextSub++
+		id := s.nextSub
+		s.filters[id] = filter
+		s.mu.Unlock()
+		s.enqueue(liveMessage{Type: "subscribed", Ref: req.Ref, Subscription: id})
+
+	case "unsubscribe":
+		s.mu.Lock()
+		_, ok := s.filters[req.Subscription]
+		delete(s.filters, req.Subscription)
+		s.mu.Unlock()
+		if !ok {
+			fail(fmt.Errorf("no subscription %d", req.Subscription))
+			return
+		}
+		s.enqueue(liveMessage{Type: "unsubscribed", Ref: req.Ref, Subscription: req.Subscription})
+
+	case "ping":
+		s.enqueue(liveMessage{Type: "pong", Ref: req.Ref})
+
+	default:
+		fail(fmt.Errorf("unknown op %q, use subscribe, unsubscribe or ping", req.Op))
+	}
+}
+
+func newLiveFilter(req liveRequest) (liveFilter, error) {
+	switch {
+	case len(req.IDs) > 0 && req.Subtree != "":
+		return liveFilter{}, fmt.Errorf("send ids or subtree, not both")
+	case len(req.IDs) > liveMaxIDs:
+		return liveFilter{}, fmt.Errorf("too many ids, the limit is %d", liveMaxIDs)
+	case len(req.IDs) > 0:
+		ids := make(map[int64]bool, len(req.IDs))
+		for _, id := range req.IDs {
+			if id < 1 {
+				return liveFilter{}, fmt.Errorf("invalid id %d", id)
+			}
+			ids[id] = true
+		}
+		return liveFilter{ids: ids}, nil
+	case req.Subtree != "" && len(req.Subtree) <= 255:
+		return liveFilter{subtree: req.Subtree}, nil
+	default:
+		return liveFilter{}, fmt.Errorf("send ids or a subtree (up to 255 characters)")
+	}
+}
+
+// writeLoop is the only one writing messages, and pings every heartbeat
+func (s *liveSession) writeLoop() {
+	ping := time.NewTicker(s.app.stream.heartbeat)
+	defer ping.Stop()
+
+	for {
+		var err error
+		select {
+		case <-s.done:
+			return
+		case data := <-s.send:
+			s.conn.SetWriteDeadline(time.Now().Add(s.app.stream.writeTimeout))
+			err = s.conn.WriteMessage(websocket.TextMessage, data)
+		case <-ping.C:
+			s.conn.SetWriteDeadline(time.Now().Add(s.app.stream.writeTimeout))
+			err = s.conn.WriteMessage(websocket.PingMessage, nil)
+		}
+		if err != nil {
+			s.close(websocket.CloseGoingAway, "")
+			return
+		}
+	}
+}
+
+// eventLoop matches the change feed against the subscriptions
+// If the feed drops us for lagging we pick up again from the last event seen, or send
+// a reset if we hadn't seen any: there's nowhere to resume from. It only ends for good
+// when the feed stops, which is the server shutting down
+func (s *liveSession) eventLoop(sub *models.FeedSubscription) {
+	var lastEventID int64
+	for {
+		select {
+		case <-s.done:
+			sub.Close()
+			return
+		case event, ok := <-sub.Events():
+			if ok {
+				lastEventID = event.ID
+				s.dispatch(event)
+				continue
+			}
+			if !sub.Lagged() {
+				s.close(websocket.CloseGoingAway, "server shutting down")
+				return
+			}
+			sub = s.app.feed.Subscribe(lastEventID, s.app.stream.queue)
+			if sub.Reset || lastEventID == 0 {
+				s.enqueue(liveMessage{Type: "reset"})
+			}
+			for _, event := range sub.Replay {
+				lastEventID = event.ID
+				s.dispatch(event)
+			}
+		}
+	}
+}
+
+func (s *liveSession) dispatch(event models.ChangeEvent) {
+	s.mu.Lock()
+	var matched []int
+	for id, filter := range s.filters {
+		if filter.matches(event) {
+			matched = append(matched, id)
+		}
+	}
+	s.mu.Unlock()
+
+	if len(matched) > 0 {
+		slices.Sort(matched)
+		s.enqueue(liveMessage{Type: "event", Subscriptions: matched, Event: &event})
+	}
+}
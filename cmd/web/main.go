@@ -6,21 +6,45 @@ package main
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"classifier.buhtigexa.net/internal/cache"
 	models "classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/internal/openapi"
 	_ "github.com/go-sql-driver/mysql" // necesitamos este driver si o si, viste
+	_ "github.com/jackc/pgx/v5/stdlib"  // Postgres, para los equipos que estandarizan en eso
+	_ "github.com/mattn/go-sqlite3"    // y este para correr todo local con un archivo
 )
 
 type application struct {
 	config
-	model    *models.ClassifierModel
-	metrics  *models.MetricsCollector
+	model         models.ClassifierStore
+	replicas      *models.ReplicaSet
+	resilience    *models.ResilientStore // the retries and breaker around the model
+	metrics       *models.MetricsCollector
+	cache         cache.Store
+	invalidations models.InvalidationBus    // nil when there's only local eviction
+	outbox        *models.OutboxDispatcher  // nil with OUTBOX_ENABLED=false
+	webhooks      *models.WebhookModel      // the subscriptions, also an event publisher
+	deliveries    *models.WebhookDispatcher // nil with OUTBOX_ENABLED=false
+	feed          *models.ChangeFeed        // the change events for the streams
+	stream        streamSettings
+	websockets    atomic.Int64              // open /classifiers/live connections
+	ready         atomic.Bool               // readiness, green after the warm-up
+	openapi       *openapi.Document         // what /openapi.json serves, built with the routes
+	openapiJSON   []byte
+	docProblems   []string                  // routes and docs that don't match up, see buildOpenAPI
+	legacy        legacySettings
+	trustedProxies []netip.Prefix            // see TRUSTED_PROXIES
+	legacyCalls   map[string]*atomic.Int64  // uses of each legacy route, for /debug/metrics
 }
 
 func main() {
@@ -39,16 +63,70 @@ func main() {
 
 	cfg.logger = logger
 
+	// `classifier migrate ...`, `classifier list` and company do their thing and exit, no server
+	if len(os.Args) > 1 {
+		if code, ok := runCommand(cfg, os.Args[1:], os.Stdout, os.Stderr); ok {
+			os.Exit(code)
+		}
+	}
+
 	// Canal para señales del sistema
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
-	db, err := openDB(cfg.db.dsn)
+	app := &application{config: cfg}
+
+	// Creamos el servidor HTTP, con las rutas de arranque hasta que este todo listo
+	handler := &swapHandler{}
+	handler.set(app.bootRoutes())
+	srv := &http.Server{
+		Addr:         cfg.addr,
+		Handler:      handler,
+		IdleTimeout:  time.Minute,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	// Iniciamos el servidor en una goroutine
+	startServer := func() {
+		go func() {
+			logger.Info("Starting server",
+				"addr", cfg.addr,
+				"env", os.Getenv("GO_ENV"),
+			)
+
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Error("Server error", "error", err)
+				quit <- syscall.SIGTERM // Trigger shutdown
+			}
+		}()
+	}
+
+	// With early probes the orchestrator sees us alive (and not ready) while the DB comes up
+	if cfg.earlyProbes {
+		startServer()
+	}
+
+	// A Ctrl-C while we wait for the database should stop the wait, not hang there
+	connectCtx, stopConnect := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	db, err := connectDB(connectCtx, cfg, cfg.db.dialect, cfg.db.dsn)
+	stopConnect()
 	if err != nil {
-		logger.Error("Error connecting to database", "error", err)
+		logger.Error("Error connecting to database", "dialect", cfg.db.dialect, "error", err)
 		os.Exit(1)
 	}
 
+	if cfg.db.autoMigrate {
+		migrator, err := models.NewMigrator(db, cfg.db.dialect, models.MigratorOptions{})
+		if err == nil {
+			err = autoMigrate(context.Background(), cfg, migrator)
+		}
+		if err != nil {
+			logger.Error("Error migrating database schema", "dialect", cfg.db.dialect, "error", err)
+			os.Exit(1)
+		}
+	}
+
 	db.SetMaxOpenConns(cfg.db.maxOpenConns)
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
 
@@ -61,53 +139,198 @@ func main() {
 
 	metricsCollector := models.NewMetricsCollector(db)
 
-	model, err := models.NewClassifierModel(db)
+	store, err := openCache(cfg)
+	if err != nil {
+		logger.Error("Error opening cache", "backend", cfg.cache.backend, "error", err)
+		os.Exit(1)
+	}
+
+	bus, err := openInvalidationBus(cfg, db)
+	if err != nil {
+		logger.Error("Error opening invalidation bus", "bus", cfg.cache.invalidation, "error", err)
+		os.Exit(1)
+	}
+
+	timeouts, err := cfg.queryTimeouts()
+	if err != nil {
+		logger.Error("Error parsing query timeouts", "error", err)
+		os.Exit(1)
+	}
+
+	replicas, err := openReplicas(cfg, db)
+	if err != nil {
+		logger.Error("Error opening read replicas", "error", err)
+		os.Exit(1)
+	}
+
+	model, err := models.NewClassifierModel(db, models.ClassifierModelOptions{
+		Cache:         store,
+		Invalidations: bus,
+		Dialect:       cfg.db.dialect,
+		Timeouts:      timeouts,
+		Replicas:      replicas,
+	})
 	if err != nil {
 		logger.Error("Error initializing classifier model", "error", err)
 		os.Exit(1)
 	}
 
-	app := &application{
-		config:  cfg,
-		model:   model,
-		metrics: metricsCollector,
+	resilienceOpts, err := cfg.resilienceOptions()
+	if err != nil {
+		logger.Error("Error parsing retry and breaker settings", "error", err)
+		os.Exit(1)
+	}
+	resilienceOpts.OnStateChange = func(from, to models.CircuitState) {
+		logger.Warn("Database circuit breaker changed state", "from", from.String(), "to", to.String())
 	}
+	resilient := models.NewResilientStore(model, resilienceOpts)
 
-	// Creamos el servidor HTTP
-	srv := &http.Server{
-		Addr:         cfg.addr,
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+	webhooks, err := models.NewWebhookModel(db, cfg.db.dialect)
+	if err != nil {
+		logger.Error("Error initializing webhook model", "error", err)
+		os.Exit(1)
 	}
+	app.webhooks = webhooks
 
-	// Iniciamos el servidor en una goroutine
-	go func() {
-		logger.Info("Starting server",
-			"addr", cfg.addr,
-			"env", os.Getenv("GO_ENV"),
-		)
+	// Every pod tails the outbox for its own event streams
+	app.stream, err = cfg.streamSettings()
+	if err != nil {
+		logger.Error("Error parsing event stream settings", "error", err)
+		os.Exit(1)
+	}
+	app.legacy, err = cfg.legacySettings()
+	if err != nil {
+		logger.Error("Error parsing legacy route dates", "error", err)
+		os.Exit(1)
+	}
+	app.trustedProxies, err = cfg.trustedProxyPrefixes()
+	if err != nil {
+		logger.Error("Error parsing trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	feed, err := models.NewChangeFeed(db, cfg.db.dialect, models.ChangeFeedOptions{ReplaySize: cfg.events.replaySize})
+	if err != nil {
+		logger.Error("Error initializing change feed", "error", err)
+		os.Exit(1)
+	}
+	feed.Start()
+	app.feed = feed
 
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Error("Server error", "error", err)
-			quit <- syscall.SIGTERM // Trigger shutdown
+	// The outbox gets a row for every change with or without the dispatcher, the
+	// streams above read it, so the pruning runs on its own
+	prunerOpts, err := cfg.outboxPrunerOptions()
+	if err != nil {
+		logger.Error("Error parsing outbox settings", "error", err)
+		os.Exit(1)
+	}
+	prunerOpts.OnError = func(err error) {
+		logger.Warn("Error pruning the outbox", "error", err)
+	}
+	pruner, err := models.NewOutboxPruner(db, cfg.db.dialect, prunerOpts)
+	if err != nil {
+		logger.Error("Error initializing outbox pruner", "error", err)
+		os.Exit(1)
+	}
+	pruner.Start()
+
+	// The dispatcher publishes what every mutation left in the outbox, and the
+	// webhook one sends what that queued for each subscription
+	var outbox *models.OutboxDispatcher
+	var deliveries *models.WebhookDispatcher
+	if cfg.outbox.enabled {
+		outboxOpts, err := cfg.outboxOptions()
+		if err != nil {
+			logger.Error("Error parsing outbox settings", "error", err)
+			os.Exit(1)
+		}
+		outboxOpts.OnError = func(event models.ChangeEvent, err error) {
+			logger.Warn("Error publishing change event, will retry",
+				"event_id", event.ID, "classifier_id", event.ClassifierID, "error", err)
+		}
+		outbox, err = models.NewOutboxDispatcher(db, cfg.db.dialect, app.eventPublisher(), outboxOpts)
+		if err != nil {
+			logger.Error("Error initializing outbox dispatcher", "error", err)
+			os.Exit(1)
+		}
+		outbox.Start()
+
+		webhookOpts, err := cfg.webhookOptions()
+		if err != nil {
+			logger.Error("Error parsing webhook settings", "error", err)
+			os.Exit(1)
+		}
+		webhookOpts.OnFailure = func(delivery models.WebhookDelivery, err error) {
+			logger.Warn("Webhook delivery failed", "webhook_id", delivery.WebhookID,
+				"delivery_id", delivery.ID, "attempts", delivery.Attempts, "status", delivery.Status, "error", err)
 		}
+		deliveries, err = models.NewWebhookDispatcher(db, cfg.db.dialect, webhookOpts)
+		if err != nil {
+			logger.Error("Error initializing webhook dispatcher", "error", err)
+			os.Exit(1)
+		}
+		deliveries.Start()
+	}
+
+	app.model = resilient
+	app.resilience = resilient
+	app.outbox = outbox
+	app.deliveries = deliveries
+	app.replicas = replicas
+	app.metrics = metricsCollector
+	app.cache = store
+	app.invalidations = bus
+
+	// Todo listo, ahora si las rutas de verdad
+	handler.set(app.routes())
+	if !cfg.earlyProbes {
+		startServer()
+	}
+
+	// Calentamos la caché y recién ahí decimos que estamos listos
+	warmCtx, cancelWarm := context.WithCancel(context.Background())
+	go func() {
+		app.warmUp(warmCtx)
+		app.ready.Store(true)
+		logger.Info("Server ready")
 	}()
 
 	// Esperamos señal de shutdown
 	<-quit
+	cancelWarm()
+	app.ready.Store(false) // Que el load balancer deje de mandarnos gente
 	logger.Info("Shutting down server...")
 
 	// Creamos un contexto con timeout para el shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// Cortamos los streams de eventos primero, si no Shutdown se queda esperando
+	// conexiones que no terminan nunca. Los clientes se reconectan a otro pod
+	if err := feed.Stop(ctx); err != nil {
+		logger.Error("Change feed forced to stop:", "error", err)
+	}
+
 	// Cerramos el servidor HTTP gracefully
 	if err := srv.Shutdown(ctx); err != nil {
 		logger.Error("Server forced to shutdown:", "error", err)
 	}
 
+	// El dispatcher termina el evento que está mandando y para; lo que quede
+	// pendiente sale en el próximo arranque (o desde otro pod)
+	if outbox != nil {
+		if err := outbox.Stop(ctx); err != nil {
+			logger.Error("Outbox dispatcher forced to stop:", "error", err)
+		}
+	}
+	if deliveries != nil {
+		if err := deliveries.Stop(ctx); err != nil {
+			logger.Error("Webhook dispatcher forced to stop:", "error", err)
+		}
+	}
+	if err := pruner.Stop(ctx); err != nil {
+		logger.Error("Outbox pruner forced to stop:", "error", err)
+	}
+
 	// Cerramos la caché y sus goroutines
 	if err := model.Close(); err != nil {
 		logger.Error("Error closing cache:", "error", err)
@@ -118,6 +341,18 @@ func main() {
 		logger.Error("Error closing prepared statements:", "error", err)
 	}
 
+	// El bus de invalidaciones usa la DB, asi que va antes de cerrarla
+	if bus != nil {
+		if err := bus.Close(); err != nil {
+			logger.Error("Error closing invalidation bus:", "error", err)
+		}
+	}
+
+	// Las replicas se cierran antes que el primario, igual que el resto
+	if err := replicas.Close(); err != nil {
+		logger.Error("Error closing read replicas:", "error", err)
+	}
+
 	// Cerramos la conexión a la base de datos
 	if err := db.Close(); err != nil {
 		logger.Error("Error closing database connection:", "error", err)
@@ -126,13 +361,146 @@ func main() {
 	logger.Info("Server exited properly")
 }
 
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+// connectDB opens the pool and pings until the database answers, backing off
+// exponentially in between, for up to DB_CONNECT_TIMEOUT. That's what the
+// wait-for-mysql.sh script used to do from outside, now every deploy gets it
+func connectDB(ctx context.Context, cfg config, dialect models.Dialect, dsn string) (*sql.DB, error) {
+	wait, err := time.ParseDuration(cfg.db.connect.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("DB_CONNECT_TIMEOUT: %w", err)
+	}
+	delay, err := time.ParseDuration(cfg.db.connect.backoff)
+	if err != nil {
+		return nil, fmt.Errorf("DB_CONNECT_BACKOFF: %w", err)
+	}
+	maxDelay, err := time.ParseDuration(cfg.db.connect.maxBackoff)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("DB_CONNECT_MAX_BACKOFF: %w", err)
 	}
-	if err = db.Ping(); err != nil {
-		return nil, err
+
+	db, err := sql.Open(dialect.DriverName(), dsn)
+	if err != nil {
+		return nil, err // a malformed DSN won't fix itself, no point retrying
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		pingCtx, cancelPing := context.WithTimeout(ctx, 5*time.Second)
+		err = db.PingContext(pingCtx)
+		cancelPing()
+		if err == nil {
+			cfg.logger.Info("Connected to database",
+				"dialect", dialect,
+				"attempt", attempt,
+				"elapsed", time.Since(start),
+			)
+			return db, nil
+		}
+
+		cfg.logger.Warn("Database not reachable yet",
+			"dialect", dialect,
+			"attempt", attempt,
+			"retry_in", delay,
+			"error", err,
+		)
+
+		select {
+		case <-ctx.Done():
+			db.Close()
+			return nil, fmt.Errorf("gave up after %d attempts in %s: %w", attempt, time.Since(start).Round(time.Millisecond), err)
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxDelay)
+	}
+}
+
+// openReplicas opens the DB_REPLICA_DSNS pools and the set that routes reads to them
+// A replica that's down at startup doesn't stop us, it joins when it answers a ping
+func openReplicas(cfg config, primary *sql.DB) (*models.ReplicaSet, error) {
+	healthInterval, err := time.ParseDuration(cfg.db.replicas.healthInterval)
+	if err != nil {
+		return nil, fmt.Errorf("DB_REPLICA_HEALTH_INTERVAL: %w", err)
+	}
+	stickyWindow, err := time.ParseDuration(cfg.db.replicas.stickyWindow)
+	if err != nil {
+		return nil, fmt.Errorf("DB_STICKY_WINDOW: %w", err)
+	}
+	idleTime, err := time.ParseDuration(cfg.db.maxIdleTime)
+	if err != nil {
+		return nil, fmt.Errorf("DB_MAX_IDLE_TIME: %w", err)
+	}
+
+	replicas := make([]models.Replica, 0, len(cfg.db.replicas.dsns))
+	for i, raw := range cfg.db.replicas.dsns {
+		dialect, dsn := parseDSN(raw)
+		if dialect != cfg.db.dialect {
+			return nil, fmt.Errorf("replica %d is %s but the primary is %s", i+1, dialect, cfg.db.dialect)
+		}
+		// sql.Open doesn't connect, so a dead replica isn't an error here
+		db, err := sql.Open(dialect.DriverName(), dsn)
+		if err != nil {
+			return nil, fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		db.SetMaxOpenConns(cfg.db.maxOpenConns)
+		db.SetMaxIdleConns(cfg.db.maxIdleConns)
+		db.SetConnMaxIdleTime(idleTime)
+		replicas = append(replicas, models.Replica{Name: fmt.Sprintf("replica-%d", i+1), DB: db})
+	}
+
+	return models.NewReplicaSet(primary, replicas, models.ReplicaOptions{
+		HealthInterval: healthInterval,
+		StickyWindow:   stickyWindow,
+		OnError: func(name string, err error) {
+			cfg.logger.Warn("Read replica out of rotation", "replica", name, "error", err)
+		},
+	}), nil
+}
+
+// openCache picks the cache backend from the config
+// Memory by default, redis when there's more than one replica dando vueltas
+func openCache(cfg config) (cache.Store, error) {
+	switch cfg.cache.backend {
+	case "", "memory":
+		return cache.New(), nil
+	case "redis":
+		return cache.NewRedis(cache.RedisOptions{
+			Addr:     cfg.cache.redis.addr,
+			Password: cfg.cache.redis.password,
+			DB:       cfg.cache.redis.db,
+			Codec:    models.ClassifierCodec{},
+			OnError: func(err error) {
+				cfg.logger.Warn("Cache backend error", "error", err)
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unknown cache backend %q", cfg.cache.backend)
+	}
+}
+
+// openInvalidationBus picks how writes get announced to the other replicas
+// A nil bus means we only evict locally, fine for a single pod or a shared redis
+func openInvalidationBus(cfg config, db *sql.DB) (models.InvalidationBus, error) {
+	switch cfg.cache.invalidation {
+	case "", "none":
+		return nil, nil
+	case "mysql":
+		if cfg.db.dialect != models.MySQL {
+			return nil, fmt.Errorf("the mysql invalidation bus needs a MySQL database, not %s", cfg.db.dialect)
+		}
+		interval, err := time.ParseDuration(cfg.cache.invalidationPoll)
+		if err != nil {
+			return nil, fmt.Errorf("parsing poll interval: %w", err)
+		}
+		return models.NewMySQLInvalidationBus(db, models.MySQLInvalidationOptions{
+			PollInterval: interval,
+			OnError: func(err error) {
+				cfg.logger.Warn("Invalidation bus error", "error", err)
+			},
+		})
+	default:
+		return nil, fmt.Errorf("unknown invalidation bus %q", cfg.cache.invalidation)
 	}
-	return db, nil
 }
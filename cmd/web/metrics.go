@@ -13,8 +13,98 @@ func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
 			"wait_count":          metrics.WaitCount,
 			"max_idle_closed":     metrics.MaxIdleTimeClosed,
 		},
+		"circuit_breaker": app.circuitBreakerMetrics(),
+		"replicas":        app.replicaMetrics(),
+		"outbox":          app.outboxMetrics(),
+		"webhooks":        app.webhookMetrics(),
+		"event_streams":   app.feedMetrics(),
+		"legacy_routes":   app.legacyMetrics(),
 	}, nil)
 	if err != nil {
 		app.serverError(w, r, err)
 	}
-}
\ No newline at end of file
+}
+
+// circuitBreakerMetrics is the breaker and retry state, so you can tell from a
+// dashboard whether the 503s are us failing fast or the database itself
+func (app *application) circuitBreakerMetrics() map[string]interface{} {
+	stats := app.resilience.Stats()
+	metrics := map[string]interface{}{
+		"state":                stats.State.String(),
+		"consecutive_failures": stats.ConsecutiveFailures,
+		"opens":                stats.Opens,
+		"rejected":             stats.Rejected,
+		"retries":              stats.Retries,
+	}
+	if !stats.OpenUntil.IsZero() {
+		metrics["open_until"] = stats.OpenUntil.UTC()
+	}
+	return metrics
+}
+
+// replicaMetrics is the health of every read replica, empty without replicas
+func (app *application) replicaMetrics() []map[string]interface{} {
+	stats := app.replicas.Stats()
+	metrics := make([]map[string]interface{}, 0, len(stats))
+	for _, r := range stats {
+		metrics = append(metrics, map[string]interface{}{
+			"name":       r.Name,
+			"healthy":    r.Healthy,
+			"failures":   r.Failures,
+			"last_error": r.LastError,
+		})
+	}
+	return metrics
+}
+
+// outboxMetrics is how the change events are flowing, nil with the outbox off
+func (app *application) outboxMetrics() map[string]interface{} {
+	if app.outbox == nil {
+		return nil
+	}
+	stats := app.outbox.Stats()
+	return map[string]interface{}{
+		"running":    stats.Running,
+		"delivered":  stats.Delivered,
+		"failed":     stats.Failed,
+		"last_error": stats.LastError,
+	}
+}
+
+// webhookMetrics is how the deliveries are going, nil with the outbox off
+func (app *application) webhookMetrics() map[string]interface{} {
+	if app.deliveries == nil {
+		return nil
+	}
+	stats := app.deliveries.Stats()
+	return map[string]interface{}{
+		"running":    stats.Running,
+		"delivered":  stats.Delivered,
+		"failed":     stats.Failed,
+		"dead":       stats.Dead,
+		"last_error": stats.LastError,
+	}
+}
+
+// feedMetrics is the change feed behind the event streams
+func (app *application) feedMetrics() map[string]interface{} {
+	stats := app.feed.Stats()
+	return map[string]interface{}{
+		"running":       stats.Running,
+		"last_event_id": stats.LastEventID,
+		"subscribers":   stats.Subscribers,
+		"dropped":       stats.Dropped,
+		"last_error":    stats.LastError,
+		"websockets":    app.websockets.Load(),
+	}
+}
+
+// legacyMetrics is how many times each route from before /v1 was called since we
+// started: what has to reach zero before the sunset
+func (app *application) legacyMetrics() map[string]int64 {
+	metrics := make(map[string]int64, len(app.legacyCalls))
+	for pattern, calls := range app.legacyCalls {
+		metrics[pattern] = calls.Load()
+	}
+	return metrics
+}
@@ -2,20 +2,65 @@ package main
 
 import (
 	"compress/gzip"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 	"sync"
+
+	"classifier.buhtigexa.net/internal/models"
 )
 
 type gzipWriter struct {
 	http.ResponseWriter
-	gzipWriter *gzip.Writer
+	gzipWriter  *gzip.Writer
+	wroteHeader bool
+	compress    bool // decided with the status, see WriteHeader
+}
+
+// WriteHeader compresses everything but 204 and 304, those have no body and a gzip
+// stream would put one there
+func (gw *gzipWriter) WriteHeader(status int) {
+	if !gw.wroteHeader {
+		gw.wroteHeader = true
+		gw.compress = status != http.StatusNoContent && status != http.StatusNotModified
+		if gw.compress {
+			gw.Header().Set("Content-Encoding", "gzip")
+			gw.Header().Del("Content-Length")
+		}
+	}
+	gw.ResponseWriter.WriteHeader(status)
 }
 
 func (gw *gzipWriter) Write(b []byte) (int, error) {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if !gw.compress {
+		return gw.ResponseWriter.Write(b)
+	}
 	return gw.gzipWriter.Write(b)
 }
 
+// Flush pushes out what gzip has buffered too, the event streams need it
+func (gw *gzipWriter) Flush() {
+	if !gw.wroteHeader {
+		gw.WriteHeader(http.StatusOK)
+	}
+	if gw.compress {
+		gw.gzipWriter.Flush()
+	}
+	http.NewResponseController(gw.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the real writer (write deadlines and such)
+func (gw *gzipWriter) Unwrap() http.ResponseWriter {
+	return gw.ResponseWriter
+}
+
 var gzipPool = sync.Pool{
 	New: func() interface{} {
 		return gzip.NewWriter(nil)
@@ -24,7 +69,8 @@ var gzipPool = sync.Pool{
 
 func (app *application) gzipMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		// A websocket upgrade takes over the connection, there's no body to compress
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Upgrade") != "" {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -33,9 +79,7 @@ func (app *application) gzipMiddleware(next http.Handler) http.Handler {
 		defer gzipPool.Put(gz)
 		
 		gz.Reset(w)
-		defer gz.Close()
 
-		w.Header().Set("Content-Encoding", "gzip")
 		w.Header().Set("Vary", "Accept-Encoding")
 		
 		gw := &gzipWriter{
@@ -44,5 +88,92 @@ func (app *application) gzipMiddleware(next http.Handler) http.Handler {
 		}
 		
 		next.ServeHTTP(gw, r)
+		// Nothing written or a 204/304 means no gzip stream, not even an empty one
+		if gw.compress {
+			gz.Close()
+		}
 	})
-}
\ No newline at end of file
+}
+
+// requireAdmin guards the admin endpoints with the ADMIN_TOKEN bearer token
+// If nobody configured a token the endpoints stay off, better safe than sorry
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.adminToken == "" {
+			app.errorResponse(w, r, http.StatusForbidden, "admin endpoints are disabled, set ADMIN_TOKEN to use them")
+			return
+		}
+
+		if !app.isAdmin(r) {
+			app.unauthorizedError(w, r)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// isAdmin says whether the request brings the ADMIN_TOKEN, never with no token configured
+func (app *application) isAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && app.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(app.adminToken)) == 1
+}
+
+// clientID tags the request context with who's calling, so their reads after a write
+// stick to the primary instead of a replica that may be behind
+// X-Client-ID when the caller sends one, the remote IP otherwise
+// The audit trail blames the remote IP for the changes. X-Actor can name someone else,
+// but anybody can send a header, so it only counts from a TRUSTED_PROXIES address
+// or together with the admin token
+func (app *application) clientID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			remote = host
+		}
+
+		id := r.Header.Get("X-Client-ID")
+		if id == "" || len(id) > 128 {
+			id = remote
+		}
+		ctx := models.WithClientID(r.Context(), id)
+
+		actor := remote
+		if claimed := r.Header.Get("X-Actor"); claimed != "" && len(claimed) <= 255 && (app.isTrustedProxy(remote) || app.isAdmin(r)) {
+			actor = claimed
+		}
+		ctx = models.WithActor(ctx, actor)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isTrustedProxy says whether addr is in TRUSTED_PROXIES, the ones allowed to say who the actor is
+func (app *application) isTrustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range app.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestID gives every request an id, the caller's X-Request-ID if it sent a sane one
+// It goes back in the response header and into the audit trail, so a change can be
+// traced to the request (and the logs) that made it
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			var b [16]byte
+			rand.Read(b[:])
+			id = hex.EncodeToString(b[:])
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(models.WithRequestID(r.Context(), id)))
+	})
+}
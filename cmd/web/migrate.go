@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	models "classifier.buhtigexa.net/internal/models"
+)
+
+const migrateUsage = `usage: classifier migrate <command>
+
+  up          apply every pending migration
+  down        roll back the last applied migration
+  status      list migrations and whether they're applied
+  goto N      move to version N, up or down (0 rolls back everything)
+
+Going below version 1 drops the classifiers table with all its rows, even one that
+existed before the migrations; down and goto refuse to unless -drop-data comes last
+`
+
+// runMigrate is the "migrate" subcommand, same binary and same DB_DSN as the server
+// so the pipeline can run it as a job before the rollout, re piola
+func runMigrate(cfg config, args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, migrateUsage)
+		return 2
+	}
+
+	dropData := len(args) > 1 && args[len(args)-1] == "-drop-data"
+	if dropData {
+		args = args[:len(args)-1]
+	}
+
+	// Ctrl-C stops the wait or stops between statements instead of leaving us wondering
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// As a job before the rollout the database may still be coming up, same wait as the server
+	db, err := connectDB(ctx, cfg, cfg.db.dialect, cfg.db.dsn)
+	if err != nil {
+		fmt.Fprintf(stderr, "connecting to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := models.NewMigrator(db, cfg.db.dialect, models.MigratorOptions{})
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+
+	// Without -drop-data, a down or goto that would roll back 0001 stops here
+	guard := func(down bool, target int) bool {
+		if dropData {
+			return true
+		}
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return false
+		}
+		if !dropsClassifiers(status, down, target) {
+			return true
+		}
+		fmt.Fprintln(stderr, "refusing to roll back 0001_create_classifiers: it DROPS the classifiers table and every row in it,")
+		fmt.Fprintln(stderr, "also when the table was there before the migrations (databases set up with the old init.sql).")
+		fmt.Fprintln(stderr, "Back it up and add -drop-data if that's really what you want")
+		return false
+	}
+
+	var steps []models.MigrationStep
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		steps, err = migrator.Up(ctx)
+	case args[0] == "down" && len(args) == 1:
+		if !guard(true, 0) {
+			return 1
+		}
+		steps, err = migrator.Down(ctx)
+	case args[0] == "goto" && len(args) == 2:
+		version, convErr := strconv.Atoi(args[1])
+		if convErr != nil || version < 0 {
+			fmt.Fprintf(stderr, "goto needs a version number, got %q\n", args[1])
+			return 2
+		}
+		if !guard(false, version) {
+			return 1
+		}
+		steps, err = migrator.Goto(ctx, version)
+	case args[0] == "status" && len(args) == 1:
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(stderr, "%v\n", err)
+			return 1
+		}
+		printMigrationStatus(stdout, status)
+		return 0
+	default:
+		fmt.Fprint(stderr, migrateUsage)
+		return 2
+	}
+
+	printMigrationSteps(stdout, steps)
+	if err != nil {
+		fmt.Fprintf(stderr, "%v\n", err)
+		return 1
+	}
+	if len(steps) == 0 {
+		fmt.Fprintln(stdout, "nothing to do, the schema is up to date")
+	}
+	return 0
+}
+
+// dropsClassifiers says whether a down (or a goto target) rolls back version 1, the
+// one that owns the classifiers table
+func dropsClassifiers(status []models.MigrationStatus, down bool, target int) bool {
+	applied := 0
+	first := false
+	for _, s := range status {
+		if s.Applied {
+			applied++
+			first = first || s.Version == 1
+		}
+	}
+	if down {
+		return first && applied == 1
+	}
+	return first && target < 1
+}
+
+// autoMigrate applies pending migrations when the server starts (DB_AUTO_MIGRATE)
+// Every pod can do it at once, the advisory lock makes the rest wait their turn
+func autoMigrate(ctx context.Context, cfg config, migrator *models.Migrator) error {
+	steps, err := migrator.Up(ctx)
+	for _, step := range steps {
+		cfg.logger.Info("Applied migration", "version", step.Version, "name", step.Name, "took", step.Duration)
+	}
+	return err
+}
+
+func printMigrationSteps(w io.Writer, steps []models.MigrationStep) {
+	for _, step := range steps {
+		direction := "up"
+		if !step.Up {
+			direction = "down"
+		}
+		fmt.Fprintf(w, "%-4s %04d_%s (%s)\n", direction, step.Version, step.Name, step.Duration.Round(time.Millisecond))
+	}
+}
+
+func printMigrationStatus(w io.Writer, status []models.MigrationStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+	for _, s := range status {
+		state, appliedAt := "pending", ""
+		if s.Applied {
+			state, appliedAt = "applied", s.AppliedAt.UTC().Format(time.RFC3339)
+		}
+		switch {
+		case s.Modified:
+			state = "modified"
+		case s.Missing:
+			state = "unknown"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, state, appliedAt)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/internal/openapi"
+)
+
+// specVersion is the version /openapi.json says the document is at
+const specVersion = "1.1.0"
+
+// apiDoc documents one route of routes(). The spec is built from the route table
+// and these, with the schemas taken from the same types the handlers write, so the
+// paths can't drift and the shapes get caught by `classifier openapi check`
+type apiDoc struct {
+	id          string
+	summary     string
+	description string
+	tag         string
+	params      []*openapi.Parameter
+	body        interface{}                // the JSON request body, a zero value of its type
+	rawBody     map[string]*openapi.Schema // or a body that isn't JSON, by content type
+	responses   []apiResponse
+	admin       bool // needs the ADMIN_TOKEN bearer token
+	store       bool // goes to the database, so it can also answer 500, 503 and 504
+}
+
+type apiResponse struct {
+	status      int
+	description string
+	body        interface{}                // JSON: a zero value, or an object of them for an envelope
+	raw         map[string]*openapi.Schema // or not JSON, by content type
+	headers     map[string]string          // name -> description
+}
+
+// object is an envelope in a doc: every key is there, each with the schema of its value
+type object map[string]interface{}
+
+// The handlers that write maps instead of types, what's in those maps
+type homeResponse struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// createdClassifier is what the create answers with: what was sent, plus the id
+type createdClassifier struct {
+	ID          int64   `json:"id"`
+	Name        string  `json:"name"`
+	Description *string `json:"description"`
+	IsActive    *bool   `json:"is_active"`
+}
+
+type cacheFlushResponse struct {
+	Scope   string `json:"scope"`
+	Flushed int    `json:"flushed"`
+}
+
+type metricsResponse struct {
+	Metrics struct {
+		OpenConnections  int32 `json:"open_connections"`
+		InUseConnections int32 `json:"in_use_connections"`
+		WaitCount        int64 `json:"wait_count"`
+		MaxIdleClosed    int64 `json:"max_idle_closed"`
+	} `json:"metrics"`
+	CircuitBreaker struct {
+		State               string     `json:"state"`
+		ConsecutiveFailures int        `json:"consecutive_failures"`
+		Opens               int64      `json:"opens"`
+		Rejected            int64      `json:"rejected"`
+		Retries             int64      `json:"retries"`
+		OpenUntil           *time.Time `json:"open_until,omitempty"`
+	} `json:"circuit_breaker"`
+	Replicas []struct {
+		Name      string `json:"name"`
+		Healthy   bool   `json:"healthy"`
+		Failures  int64  `json:"failures"`
+		LastError string `json:"last_error"`
+	} `json:"replicas"`
+	Outbox *struct {
+		Running   bool   `json:"running"`
+		Delivered int64  `json:"delivered"`
+		Failed    int64  `json:"failed"`
+		LastError string `json:"last_error"`
+	} `json:"outbox"`
+	Webhooks *struct {
+		Running   bool   `json:"running"`
+		Delivered int64  `json:"delivered"`
+		Failed    int64  `json:"failed"`
+		Dead      int64  `json:"dead"`
+		LastError string `json:"last_error"`
+	} `json:"webhooks"`
+	EventStreams struct {
+		Running     bool   `json:"running"`
+		LastEventID int64  `json:"last_event_id"`
+		Subscribers int    `json:"subscribers"`
+		Dropped     int64  `json:"dropped"`
+		LastError   string `json:"last_error"`
+		Websockets  int64  `json:"websockets"`
+	} `json:"event_streams"`
+	LegacyRoutes map[string]int64 `json:"legacy_routes"` // calls to each legacy route
+}
+
+// Helpers for the parameters, so the table below reads like the handlers
+func number(min, max float64) *openapi.Schema {
+	s := &openapi.Schema{Type: openapi.Types{"integer"}, Minimum: &min}
+	if max > 0 {
+		s.Maximum = &max
+	}
+	return s
+}
+
+func text(maxLength int) *openapi.Schema {
+	s := &openapi.Schema{Type: openapi.Types{"string"}}
+	if maxLength > 0 {
+		s.MaxLength = &maxLength
+	}
+	return s
+}
+
+func oneOf(values ...string) *openapi.Schema {
+	s := &openapi.Schema{Type: openapi.Types{"string"}}
+	for _, v := range values {
+		s.Enum = append(s.Enum, v)
+	}
+	return s
+}
+
+func pathID(name, description string) *openapi.Parameter {
+	s := number(1, 0)
+	s.Format = "int64"
+	return &openapi.Parameter{Name: name, In: "path", Required: true, Description: description, Schema: s}
+}
+
+func query(name, description string, schema *openapi.Schema) *openapi.Parameter {
+	return &openapi.Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func required(p *openapi.Parameter) *openapi.Parameter {
+	p.Required = true
+	return p
+}
+
+var (
+	pageParam     = query("page", "Page number, from 1", number(1, 0))
+	pageSizeParam = query("page_size", "Items per page, 20 by default", number(1, 100))
+	asOfParam     = query("as_of", "A date (2025-06-30, meaning the end of that day in UTC) or an RFC 3339 timestamp", asOfSchema)
+	searchParam   = query("q", "Search in names and descriptions", text(100))
+
+	asOfSchema = &openapi.Schema{AnyOf: []*openapi.Schema{
+		{Type: openapi.Types{"string"}, Format: "date"},
+		{Type: openapi.Types{"string"}, Format: "date-time"},
+	}}
+
+	errorBody      = errorResponse{}
+	classifierBody = object{"classifier": models.Classifier{}}
+	stringBody     = &openapi.Schema{Type: openapi.Types{"string"}}
+)
+
+func badRequest(description string) apiResponse {
+	return apiResponse{status: http.StatusBadRequest, description: description, body: errorBody}
+}
+
+var notFound = apiResponse{status: http.StatusNotFound, description: "There's nothing with that id", body: errorBody}
+
+// apiDocs has every route of routes(), by pattern
+var apiDocs = map[string]apiDoc{
+	"GET /": {
+		id: "home", tag: "service", summary: "Welcome message",
+		responses: []apiResponse{{status: http.StatusOK, description: "The API is up", body: homeResponse{}}},
+	},
+	"GET /healthz": {
+		id: "healthz", tag: "service", summary: "Liveness probe",
+		responses: []apiResponse{{status: http.StatusOK, description: "Alive", body: statusResponse{}}},
+	},
+	"GET /readyz": {
+		id: "readyz", tag: "service", summary: "Readiness probe",
+		description: "Not ready while the cache warms up and while shutting down.",
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "Ready for traffic", body: statusResponse{}},
+			{status: http.StatusServiceUnavailable, description: "Not ready yet, or going away", body: statusResponse{}},
+		},
+	},
+	"GET /openapi.json": {
+		id: "openapi", tag: "service", summary: "This document",
+		responses: []apiResponse{{status: http.StatusOK, description: "The OpenAPI 3.1 document", body: json.RawMessage{}}},
+	},
+	"GET /docs": {
+		id: "docs", tag: "service", summary: "Browsable API documentation",
+		responses: []apiResponse{{status: http.StatusOK, description: "An HTML page that reads /openapi.json", raw: map[string]*openapi.Schema{"text/html; charset=utf-8": stringBody}}},
+	},
+
+	"POST /v1/classifiers": {
+		id: "createClassifier", tag: "classifiers", summary: "Create a classifier", store: true,
+		body: createClassifierRequest{},
+		responses: []apiResponse{
+			{status: http.StatusCreated, description: "Created. The body echoes what was sent, plus the id", body: object{"classifier": createdClassifier{}}, headers: map[string]string{"Location": "The new classifier"}},
+			badRequest("Invalid JSON or no name"),
+			{status: http.StatusConflict, description: "The name is taken", body: errorBody},
+		},
+	},
+	"GET /v1/classifiers": {
+		id: "listClassifiers", tag: "classifiers", summary: "List classifiers, newest first", store: true,
+		description: "With as_of, the catalog as it was at that moment, rebuilt from the history. q and as_of can't be combined.",
+		params:      []*openapi.Parameter{pageParam, pageSizeParam, searchParam, asOfParam},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "One page", body: object{"data": listResponse{}}},
+			badRequest("Invalid paging, q or as_of"),
+		},
+	},
+	"GET /v1/classifiers/export": {
+		id: "exportClassifiers", tag: "classifiers", summary: "Download the whole catalog", store: true,
+		description: "Streamed as it's read. A failure halfway cuts the connection instead of answering with an error.",
+		params: []*openapi.Parameter{
+			query("format", "csv by default", oneOf("csv", "json", "ndjson")),
+			searchParam, asOfParam,
+		},
+		responses: []apiResponse{
+			{
+				status: http.StatusOK, description: "The catalog, as an attachment",
+				raw: map[string]*openapi.Schema{
+					exportContentTypes["csv"]:    stringBody,
+					exportContentTypes["json"]:   {Type: openapi.Types{"array"}, Items: &openapi.Schema{Ref: "#/components/schemas/ClassifierSnapshot"}},
+					exportContentTypes["ndjson"]: stringBody,
+				},
+				headers: map[string]string{"Content-Disposition": "attachment; filename=classifiers-<timestamp>.<format>"},
+			},
+			badRequest("Invalid format, q or as_of"),
+		},
+	},
+	"POST /v1/classifiers/import": {
+		id: "importClassifiers", tag: "classifiers", summary: "Load a CSV or NDJSON file", store: true,
+		description: "The whole file is checked before anything is written, then it goes in batches of 500, each in its own transaction.",
+		params: []*openapi.Parameter{
+			query("format", "Or the Content-Type", oneOf("csv", "ndjson")),
+			query("on_conflict", "What to do with a name already taken, fail by default", oneOf("fail", "skip", "overwrite")),
+			query("dry_run", "Check everything and report what would happen, without writing", &openapi.Schema{Type: openapi.Types{"boolean"}}),
+			query("map", "Which column or key has each field, like name:Nombre,is_active:Activo", text(0)),
+		},
+		rawBody: map[string]*openapi.Schema{"text/csv": stringBody, "application/x-ndjson": stringBody},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "Imported, or what a dry run would do", body: object{"import": importReport{}}},
+			badRequest("Invalid parameters, or a file that can't be read"),
+			{status: http.StatusConflict, description: "Stopped at a name already taken (on_conflict=fail). The batches before it went in", body: object{"import": importReport{}}},
+			{status: http.StatusRequestEntityTooLarge, description: "Over 16 MB or 50000 rows", body: errorBody},
+			{status: http.StatusUnsupportedMediaType, description: "Neither CSV nor NDJSON", body: errorBody},
+			{status: http.StatusUnprocessableEntity, description: "Lines with errors, nothing was written", body: object{"import": importReport{}}},
+		},
+	},
+	"GET /v1/classifiers/{id}": {
+		id: "getClassifier", tag: "classifiers", summary: "Get a classifier", store: true,
+		params: []*openapi.Parameter{pathID("id", "Classifier id"), query("as_of", "The classifier as it was then, a date or an RFC 3339 timestamp", asOfSchema)},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The classifier", body: classifierBody},
+			badRequest("Invalid id or as_of"),
+			notFound,
+		},
+	},
+	"PATCH /v1/classifiers/{id}": {
+		id: "updateClassifier", tag: "classifiers", summary: "Change some fields of a classifier", store: true,
+		description: "Only the fields in the body change. An empty description clears it.",
+		params:      []*openapi.Parameter{pathID("id", "Classifier id")},
+		body:        updateClassifierRequest{},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The classifier, updated", body: classifierBody},
+			badRequest("Invalid id or body, or nothing to update"),
+			notFound,
+			{status: http.StatusConflict, description: "The new name is taken", body: errorBody},
+		},
+	},
+	"DELETE /v1/classifiers/{id}": {
+		id: "deleteClassifier", tag: "classifiers", summary: "Delete a classifier", store: true,
+		description: "Its history stays, and it can be brought back with a revert.",
+		params:      []*openapi.Parameter{pathID("id", "Classifier id")},
+		responses: []apiResponse{
+			{status: http.StatusNoContent, description: "Deleted"},
+			badRequest("Invalid id"),
+			notFound,
+		},
+	},
+	"GET /v1/classifiers/events": {
+		id: "classifierEvents", tag: "events", summary: "Change events as Server-Sent Events",
+		description: "Each event's id is the change event id and its data a ChangeEvent. Reconnecting with Last-Event-ID replays what was missed; when that's gone a reset event says to reload.",
+		params: []*openapi.Parameter{
+			query("last_event_id", "Where to start, for a first connection (Last-Event-ID wins)", number(0, 0)),
+			{Name: "Last-Event-ID", In: "header", Description: "The last event the client got", Schema: number(0, 0)},
+		},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The stream, until the client leaves", raw: map[string]*openapi.Schema{"text/event-stream": stringBody}},
+			badRequest("Invalid Last-Event-ID"),
+		},
+	},
+	"GET /v1/classifiers/live": {
+		id: "liveClassifiers", tag: "events", summary: "Change events over a WebSocket",
+		description: "Subscribe to some ids or a name prefix with {\"op\":\"subscribe\"} messages; the events come as ChangeEvent JSON.",
+		responses: []apiResponse{
+			{status: http.StatusSwitchingProtocols, description: "Upgraded to a WebSocket"},
+			badRequest("Not a WebSocket handshake"),
+			{status: http.StatusForbidden, description: "A browser on another origin that isn't in WS_ALLOWED_ORIGINS", body: errorBody},
+		},
+	},
+	"GET /v1/classifiers/{id}/history": {
+		id: "classifierHistory", tag: "history", summary: "Every version of a classifier, newest first", store: true,
+		description: "It keeps working after the classifier is deleted.",
+		params:      []*openapi.Parameter{pathID("id", "Classifier id"), pageParam, pageSizeParam},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "One page of versions", body: object{"data": historyResponse{}}},
+			badRequest("Invalid id or paging"),
+			notFound,
+		},
+	},
+	"GET /v1/classifiers/{id}/history/diff": {
+		id: "classifierDiff", tag: "history", summary: "Compare two versions field by field", store: true,
+		params: []*openapi.Parameter{
+			pathID("id", "Classifier id"),
+			required(query("from", "Version to start from", number(1, 0))),
+			required(query("to", "Version to compare with, it can be older than from", number(1, 0))),
+		},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The changes from one version to the other", body: object{"diff": diffResponse{}}},
+			badRequest("Invalid id or versions"),
+			{status: http.StatusNotFound, description: "One of the versions doesn't exist", body: errorBody},
+		},
+	},
+	"POST /v1/classifiers/{id}/revert": {
+		id: "revertClassifier", tag: "history", summary: "Restore a version as a new one", store: true,
+		description: "Works for deleted classifiers too.",
+		params:      []*openapi.Parameter{pathID("id", "Classifier id"), required(query("version", "The version to restore", number(1, 0)))},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The classifier as restored", body: classifierBody},
+			badRequest("Invalid id or version"),
+			{status: http.StatusNotFound, description: "There's no such version", body: errorBody},
+			{status: http.StatusConflict, description: "The version is the deletion, or its name was taken since", body: errorBody},
+		},
+	},
+
+	"POST /v1/webhooks": {
+		id: "createWebhook", tag: "webhooks", summary: "Subscribe a URL to the change events", store: true, admin: true,
+		description: "The answer is the only place the secret shows up.",
+		body:        createWebhookRequest{},
+		responses: []apiResponse{
+			{status: http.StatusCreated, description: "Subscribed", body: object{"webhook": models.Webhook{}}, headers: map[string]string{"Location": "The new webhook"}},
+			badRequest("Invalid URL, one that points to a non-public address, event type or secret"),
+		},
+	},
+	"GET /v1/webhooks": {
+		id: "listWebhooks", tag: "webhooks", summary: "List the webhooks", store: true, admin: true,
+		responses: []apiResponse{{status: http.StatusOK, description: "Every webhook, without secrets", body: object{"webhooks": []*models.Webhook{}}}},
+	},
+	"GET /v1/webhooks/{id}": {
+		id: "getWebhook", tag: "webhooks", summary: "Get a webhook", store: true, admin: true,
+		params: []*openapi.Parameter{pathID("id", "Webhook id")},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "The webhook, without its secret", body: object{"webhook": models.Webhook{}}},
+			badRequest("Invalid id"),
+			notFound,
+		},
+	},
+	"DELETE /v1/webhooks/{id}": {
+		id: "deleteWebhook", tag: "webhooks", summary: "Unsubscribe", store: true, admin: true,
+		description: "What was still pending for it doesn't go out.",
+		params:      []*openapi.Parameter{pathID("id", "Webhook id")},
+		responses: []apiResponse{
+			{status: http.StatusNoContent, description: "Deleted"},
+			badRequest("Invalid id"),
+			notFound,
+		},
+	},
+	"GET /v1/webhooks/{id}/deliveries": {
+		id: "webhookDeliveries", tag: "webhooks", summary: "A webhook's delivery log, newest first", store: true, admin: true,
+		params: []*openapi.Parameter{
+			pathID("id", "Webhook id"),
+			query("status", "Only the deliveries in this state, dead for the dead letters", oneOf("pending", "delivered", "dead")),
+			pageParam, pageSizeParam,
+		},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "One page of deliveries", body: object{"data": deliveriesResponse{}}},
+			badRequest("Invalid id, status or paging"),
+			notFound,
+		},
+	},
+	"POST /v1/webhooks/{id}/deliveries/{delivery}/retry": {
+		id: "retryWebhookDelivery", tag: "webhooks", summary: "Send a dead delivery again", store: true, admin: true,
+		params: []*openapi.Parameter{pathID("id", "Webhook id"), pathID("delivery", "Delivery id")},
+		responses: []apiResponse{
+			{status: http.StatusAccepted, description: "Back in the queue with a fresh set of attempts"},
+			badRequest("Invalid ids"),
+			{status: http.StatusNotFound, description: "There's no such dead delivery", body: errorBody},
+		},
+	},
+
+	"GET /debug/metrics": {
+		id: "metrics", tag: "service", summary: "Pool, breaker, replica, outbox, webhook, stream and legacy route metrics",
+		responses: []apiResponse{{status: http.StatusOK, description: "The metrics of this instance", body: metricsResponse{}}},
+	},
+	"GET /admin/cache": {
+		id: "cacheStats", tag: "admin", summary: "What's in the cache", admin: true,
+		params: []*openapi.Parameter{
+			query("prefix", "Only the keys starting with this", text(0)),
+			query("sample", "How many keys to show, 20 by default", number(0, 100)),
+		},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "Stats and a sample of keys", body: object{"cache": cacheStatsResponse{}}},
+			badRequest("Invalid sample"),
+		},
+	},
+	"DELETE /admin/cache": {
+		id: "flushCache", tag: "admin", summary: "Empty the cache, here and on the other replicas", admin: true,
+		params: []*openapi.Parameter{
+			query("prefix", "Only the keys starting with this", text(0)),
+			query("key", "Only this key, taken literally even if it ends in *", text(0)),
+		},
+		responses: []apiResponse{
+			{status: http.StatusOK, description: "What was flushed", body: cacheFlushResponse{}},
+			badRequest("Both key and prefix"),
+		},
+	},
+}
+
+var apiTags = []openapi.Tag{
+	{Name: "classifiers", Description: "The catalog"},
+	{Name: "history", Description: "Every version of every classifier"},
+	{Name: "events", Description: "Changes as they happen"},
+	{Name: "webhooks", Description: "Changes pushed to other services"},
+	{Name: "admin", Description: "Needs the ADMIN_TOKEN as a bearer token"},
+	{Name: "service", Description: "Probes, metrics and these docs"},
+	{Name: "legacy", Description: "The routes from before /v1, deprecated until their Sunset date"},
+}
+
+// legacyHeaders go in every answer of a legacy route
+var legacyHeaders = map[string]string{
+	"Deprecation": "When the route was deprecated, as @ and a Unix time (RFC 9745)",
+	"Sunset":      "When it can go away (RFC 8594)",
+	"Link":        "The route that replaces it, rel=\"successor-version\"",
+}
+
+var pathParamRE = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// buildOpenAPI puts together the document for the routes of the table. problems has
+// whatever doesn't match up: routes without docs, docs without routes, path
+// parameters documented wrong. The document is still usable with them
+func buildOpenAPI(routes []tableRoute) (doc *openapi.Document, problems []string) {
+	gen := openapi.NewGenerator()
+	schema := func(v interface{}) *openapi.Schema {
+		if obj, ok := v.(object); ok {
+			s := &openapi.Schema{Type: openapi.Types{"object"}, Properties: make(map[string]*openapi.Schema)}
+			for key, value := range obj {
+				s.Properties[key] = gen.Schema(value)
+				s.Required = append(s.Required, key)
+			}
+			sort.Strings(s.Required)
+			return s
+		}
+		return gen.Schema(v)
+	}
+	// The export's JSON array refers to it by name
+	gen.Schema(models.ClassifierSnapshot{})
+
+	doc = &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Classifier API",
+			Description: "Manages classifiers, with their history, change events and webhooks.",
+			Version:     specVersion,
+		},
+		Tags:  apiTags,
+		Paths: make(map[string]openapi.PathItem),
+		Components: openapi.Components{
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"adminToken": {Type: "http", Scheme: "bearer", Description: "The ADMIN_TOKEN the server was started with"},
+			},
+		},
+	}
+
+	routed := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		pattern, key := route.pattern, route.pattern
+		if route.successor != "" {
+			key = route.successor
+		}
+		routed[key] = true
+		d, ok := apiDocs[key]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: the route has no docs", pattern))
+			continue
+		}
+
+		op := &openapi.Operation{
+			OperationID: d.id,
+			Summary:     d.summary,
+			Description: d.description,
+			Tags:        []string{d.tag},
+			Parameters:  d.params,
+			Responses:   make(map[string]*openapi.Response),
+		}
+		// A legacy alias is its successor with another name, and deprecated
+		if route.successor != "" {
+			op.OperationID = "legacy" + strings.ToUpper(d.id[:1]) + d.id[1:]
+			op.Tags = []string{"legacy"}
+			op.Deprecated = true
+			op.Description = strings.TrimSpace(fmt.Sprintf("Use %s instead. %s", route.successor, d.description))
+		}
+
+		// The path parameters in the pattern and in the docs have to be the same ones
+		_, path, _ := strings.Cut(pattern, " ")
+		inPath := make(map[string]bool)
+		for _, m := range pathParamRE.FindAllStringSubmatch(path, -1) {
+			inPath[m[1]] = true
+		}
+		for _, p := range d.params {
+			if p.In != "path" {
+				continue
+			}
+			if !inPath[p.Name] {
+				problems = append(problems, fmt.Sprintf("%s: path parameter %q isn't in the pattern", pattern, p.Name))
+			}
+			delete(inPath, p.Name)
+		}
+		for name := range inPath {
+			problems = append(problems, fmt.Sprintf("%s: path parameter %q has no docs", pattern, name))
+		}
+
+		switch {
+		case d.body != nil:
+			op.RequestBody = &openapi.RequestBody{
+				Required: true,
+				Content:  map[string]*openapi.MediaType{"application/json": {Schema: schema(d.body)}},
+			}
+		case d.rawBody != nil:
+			op.RequestBody = &openapi.RequestBody{Required: true, Content: make(map[string]*openapi.MediaType)}
+			for contentType, s := range d.rawBody {
+				op.RequestBody.Content[contentType] = &openapi.MediaType{Schema: s}
+			}
+		}
+
+		responses := d.responses
+		if d.admin {
+			op.Security = []map[string][]string{{"adminToken": {}}}
+			responses = append(responses,
+				apiResponse{status: http.StatusUnauthorized, description: "No token or the wrong one", body: errorBody},
+				apiResponse{status: http.StatusForbidden, description: "The server has no ADMIN_TOKEN, the admin endpoints are off", body: errorBody},
+			)
+		}
+		if d.store {
+			retryAfter := map[string]string{
+				"Retry-After":   "Seconds until it's worth trying again",
+				retrySafeHeader: "true when the request was turned away before doing anything (the breaker is open), so even a POST can go again",
+			}
+			responses = append(responses,
+				apiResponse{status: http.StatusInternalServerError, description: "Something broke on our side", body: errorBody},
+				apiResponse{status: http.StatusServiceUnavailable, description: "The database is down or the circuit breaker is open", body: errorBody, headers: retryAfter},
+				apiResponse{status: http.StatusGatewayTimeout, description: "The database took too long", body: errorBody},
+			)
+		}
+		for _, r := range responses {
+			res := &openapi.Response{Description: r.description}
+			switch {
+			case r.body != nil:
+				res.Content = map[string]*openapi.MediaType{"application/json": {Schema: schema(r.body)}}
+			case r.raw != nil:
+				res.Content = make(map[string]*openapi.MediaType)
+				for contentType, s := range r.raw {
+					res.Content[contentType] = &openapi.MediaType{Schema: s}
+				}
+			}
+			headers := r.headers
+			if route.successor != "" {
+				headers = make(map[string]string, len(r.headers)+len(legacyHeaders))
+				maps.Copy(headers, r.headers)
+				maps.Copy(headers, legacyHeaders)
+			}
+			for name, description := range headers {
+				if res.Headers == nil {
+					res.Headers = make(map[string]*openapi.Header)
+				}
+				res.Headers[name] = &openapi.Header{Description: description, Schema: &openapi.Schema{Type: openapi.Types{"string"}}}
+			}
+			op.Responses[strconv.Itoa(r.status)] = res
+		}
+
+		doc.AddOperation(pattern, op)
+	}
+
+	for pattern := range apiDocs {
+		if !routed[pattern] {
+			problems = append(problems, fmt.Sprintf("%s: documented, but there's no such route", pattern))
+		}
+	}
+	sort.Strings(problems)
+
+	doc.Components.Schemas = gen.Schemas
+	return doc, problems
+}
+
+// routeTable is the mux plus the list of what was registered on it, for the spec.
+// wrap, when set, goes around every handler: inside the mux, where r.Pattern is known
+type routeTable struct {
+	mux    *http.ServeMux
+	wrap   func(http.HandlerFunc) http.HandlerFunc
+	routes []tableRoute
+}
+
+// tableRoute is a registered pattern. A deprecated alias has the docs of its successor
+type tableRoute struct {
+	pattern   string
+	successor string
+}
+
+func (t *routeTable) HandleFunc(pattern string, handler http.HandlerFunc) {
+	t.handle(tableRoute{pattern: pattern}, handler, nil)
+}
+
+// handle registers a route; outer, when set, goes around wrap too, so whatever it
+// adds to the answer is there even when wrap answers by itself
+func (t *routeTable) handle(route tableRoute, handler http.HandlerFunc, outer func(http.HandlerFunc) http.HandlerFunc) {
+	t.routes = append(t.routes, route)
+	if t.wrap != nil {
+		handler = t.wrap(handler)
+	}
+	if outer != nil {
+		handler = outer(handler)
+	}
+	t.mux.HandleFunc(route.pattern, handler)
+}
+
+// OpenAPI serves the spec, built once from the route table when the routes were set up
+func (app *application) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(app.openapiJSON)
+}
+
+//go:embed docs.html
+var docsPage []byte
+
+// Docs is a page that renders /openapi.json, embedded so it works without internet
+func (app *application) Docs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(docsPage)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+)
+
+// The same checks as `classifier openapi check`, so drift fails go test too
+
+func TestOpenAPIRoutesAndDocs(t *testing.T) {
+	cfg := loadConfig()
+	cfg.logger = slog.New(slog.DiscardHandler)
+	app := &application{config: cfg}
+	app.routes()
+
+	// buildOpenAPI goes both ways: a route without docs, docs without a route, and
+	// path parameters that don't match
+	for _, problem := range app.docProblems {
+		t.Error(problem)
+	}
+	for pattern := range apiDocs {
+		if app.openapi.Operation(pattern) == nil {
+			t.Errorf("%s: documented but not in the document", pattern)
+		}
+	}
+}
+
+func TestOpenAPIConformance(t *testing.T) {
+	cfg := loadConfig()
+	cfg.logger = slog.New(slog.DiscardHandler)
+
+	requests, operations, failures, err := runCheck(cfg, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, failure := range failures {
+		t.Error(failure)
+	}
+	t.Logf("%d requests over %d operations", requests, operations)
+}
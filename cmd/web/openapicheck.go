@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	models "classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/internal/openapi"
+)
+
+const openapiUsage = `usage: classifier openapi [check]
+
+  classifier openapi          print the OpenAPI document, same as GET /openapi.json
+  classifier openapi check    run every documented operation against a throwaway
+                              SQLite database and check the answers match the docs
+
+The check fails on routes without docs, docs without routes, operations the scenario
+doesn't reach, and answers with a status, content type or body the docs don't have.
+It doesn't need DB_DSN, the database lives in a temp directory.
+`
+
+const checkAdminToken = "openapi-check-admin-token"
+
+func runOpenAPI(cfg config, args []string, stdout, stderr io.Writer) int {
+	cfg.logger = slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	switch {
+	case len(args) == 0:
+		app := &application{config: cfg}
+		app.routes()
+		var out bytes.Buffer
+		json.Indent(&out, app.openapiJSON, "", "  ")
+		out.WriteByte('\n')
+		stdout.Write(out.Bytes())
+		return 0
+	case len(args) == 1 && args[0] == "check":
+		return checkOpenAPI(cfg, stdout, stderr)
+	case len(args) == 1 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help"):
+		fmt.Fprint(stdout, openapiUsage)
+		return 0
+	}
+	fmt.Fprint(stderr, openapiUsage)
+	return 2
+}
+
+// checkStep is one request of the scenario and the status it should get. The
+// scenario runs in order on an empty database, so the ids are known beforehand
+type checkStep struct {
+	pattern string // the route it exercises
+	method  string
+	target  string // path and query
+	body    string
+	header  map[string]string
+	status  int
+}
+
+func jsonHeader() map[string]string {
+	return map[string]string{"Content-Type": "application/json"}
+}
+
+func adminHeader() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + checkAdminToken}
+}
+
+func adminJSONHeader() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + checkAdminToken, "Content-Type": "application/json"}
+}
+
+// checkScenario goes through every operation, the happy path and the errors worth
+// checking the shape of
+var checkScenario = []checkStep{
+	{pattern: "GET /", method: "GET", target: "/", status: 200},
+	{pattern: "GET /healthz", method: "GET", target: "/healthz", status: 200},
+	{pattern: "GET /readyz", method: "GET", target: "/readyz", status: 200},
+	{pattern: "GET /openapi.json", method: "GET", target: "/openapi.json", status: 200},
+	{pattern: "GET /docs", method: "GET", target: "/docs", status: 200},
+
+	{pattern: "POST /v1/classifiers", method: "POST", target: "/v1/classifiers", header: jsonHeader(), body: `{"name":"Alfa","description":"el primero","is_active":true}`, status: 201},
+	{pattern: "POST /v1/classifiers", method: "POST", target: "/v1/classifiers", header: jsonHeader(), body: `{"name":"Beta"}`, status: 201},
+	{pattern: "POST /v1/classifiers", method: "POST", target: "/v1/classifiers", header: jsonHeader(), body: `{"name":"Alfa"}`, status: 409},
+	{pattern: "POST /v1/classifiers", method: "POST", target: "/v1/classifiers", header: jsonHeader(), body: `{}`, status: 400},
+	{pattern: "GET /v1/classifiers", method: "GET", target: "/v1/classifiers?page_size=1", status: 200},
+	{pattern: "GET /v1/classifiers", method: "GET", target: "/v1/classifiers?page=0", status: 400},
+	{pattern: "GET /v1/classifiers/{id}", method: "GET", target: "/v1/classifiers/1", status: 200},
+	{pattern: "GET /v1/classifiers/{id}", method: "GET", target: "/v1/classifiers/1?as_of=2999-01-01", status: 200},
+	{pattern: "GET /v1/classifiers/{id}", method: "GET", target: "/v1/classifiers/999", status: 404},
+	{pattern: "GET /v1/classifiers/{id}", method: "GET", target: "/v1/classifiers/abc", status: 400},
+	{pattern: "PATCH /v1/classifiers/{id}", method: "PATCH", target: "/v1/classifiers/1", header: jsonHeader(), body: `{"description":"cambiado"}`, status: 200},
+	{pattern: "PATCH /v1/classifiers/{id}", method: "PATCH", target: "/v1/classifiers/2", header: jsonHeader(), body: `{"name":"Alfa"}`, status: 409},
+	{pattern: "PATCH /v1/classifiers/{id}", method: "PATCH", target: "/v1/classifiers/999", header: jsonHeader(), body: `{"name":"Gamma"}`, status: 404},
+
+	{pattern: "GET /v1/classifiers/{id}/history", method: "GET", target: "/v1/classifiers/1/history", status: 200},
+	{pattern: "GET /v1/classifiers/{id}/history/diff", method: "GET", target: "/v1/classifiers/1/history/diff?from=1&to=2", status: 200},
+	{pattern: "GET /v1/classifiers/{id}/history/diff", method: "GET", target: "/v1/classifiers/1/history/diff?from=1&to=99", status: 404},
+	{pattern: "POST /v1/classifiers/{id}/revert", method: "POST", target: "/v1/classifiers/1/revert?version=1", status: 200},
+	{pattern: "POST /v1/classifiers/{id}/revert", method: "POST", target: "/v1/classifiers/1/revert?version=99", status: 404},
+
+	{pattern: "GET /v1/classifiers/export", method: "GET", target: "/v1/classifiers/export", status: 200},
+	{pattern: "GET /v1/classifiers/export", method: "GET", target: "/v1/classifiers/export?format=json", status: 200},
+	{pattern: "GET /v1/classifiers/export", method: "GET", target: "/v1/classifiers/export?format=ndjson", status: 200},
+	{pattern: "GET /v1/classifiers/export", method: "GET", target: "/v1/classifiers/export?format=xml", status: 400},
+	{pattern: "POST /v1/classifiers/import", method: "POST", target: "/v1/classifiers/import?dry_run=true", header: map[string]string{"Content-Type": "text/csv"}, body: "name,description,is_active\nGamma,tercero,true\n", status: 200},
+	{pattern: "POST /v1/classifiers/import", method: "POST", target: "/v1/classifiers/import", header: map[string]string{"Content-Type": "application/x-ndjson"}, body: `{"name":"Alfa"}` + "\n", status: 409},
+	{pattern: "POST /v1/classifiers/import", method: "POST", target: "/v1/classifiers/import?format=ndjson", body: `{"name":""}` + "\n", status: 422},
+	{pattern: "POST /v1/classifiers/import", method: "POST", target: "/v1/classifiers/import", header: map[string]string{"Content-Type": "application/xml"}, body: "<classifiers/>", status: 415},
+
+	{pattern: "DELETE /v1/classifiers/{id}", method: "DELETE", target: "/v1/classifiers/2", status: 204},
+	{pattern: "DELETE /v1/classifiers/{id}", method: "DELETE", target: "/v1/classifiers/2", status: 404},
+
+	// The dispatcher doesn't run here, nothing goes out to the documentation address
+	{pattern: "POST /v1/webhooks", method: "POST", target: "/v1/webhooks", header: adminJSONHeader(), body: `{"url":"https://198.51.100.7/hook","event_types":["created"]}`, status: 201},
+	{pattern: "POST /v1/webhooks", method: "POST", target: "/v1/webhooks", header: adminJSONHeader(), body: `{"url":"ftp://nope"}`, status: 400},
+	{pattern: "POST /v1/webhooks", method: "POST", target: "/v1/webhooks", header: adminJSONHeader(), body: `{"url":"http://169.254.169.254/latest/meta-data"}`, status: 400},
+	{pattern: "POST /v1/webhooks", method: "POST", target: "/v1/webhooks", header: jsonHeader(), body: `{"url":"https://198.51.100.7/hook"}`, status: 401},
+	{pattern: "GET /v1/webhooks", method: "GET", target: "/v1/webhooks", header: adminHeader(), status: 200},
+	{pattern: "GET /v1/webhooks", method: "GET", target: "/v1/webhooks", status: 401},
+	{pattern: "GET /v1/webhooks/{id}", method: "GET", target: "/v1/webhooks/1", header: adminHeader(), status: 200},
+	{pattern: "GET /v1/webhooks/{id}", method: "GET", target: "/v1/webhooks/99", header: adminHeader(), status: 404},
+	{pattern: "GET /v1/webhooks/{id}/deliveries", method: "GET", target: "/v1/webhooks/1/deliveries", header: adminHeader(), status: 200},
+	{pattern: "GET /v1/webhooks/{id}/deliveries", method: "GET", target: "/v1/webhooks/1/deliveries?status=lost", header: adminHeader(), status: 400},
+	{pattern: "POST /v1/webhooks/{id}/deliveries/{delivery}/retry", method: "POST", target: "/v1/webhooks/1/deliveries/99/retry", header: adminHeader(), status: 404},
+	{pattern: "DELETE /v1/webhooks/{id}", method: "DELETE", target: "/v1/webhooks/1", status: 401},
+	{pattern: "DELETE /v1/webhooks/{id}", method: "DELETE", target: "/v1/webhooks/1", header: adminHeader(), status: 204},
+	{pattern: "DELETE /v1/webhooks/{id}", method: "DELETE", target: "/v1/webhooks/1", header: adminHeader(), status: 404},
+
+	{pattern: "GET /debug/metrics", method: "GET", target: "/debug/metrics", status: 200},
+	{pattern: "GET /admin/cache", method: "GET", target: "/admin/cache", header: adminHeader(), status: 200},
+	{pattern: "GET /admin/cache", method: "GET", target: "/admin/cache", status: 401},
+	{pattern: "DELETE /admin/cache", method: "DELETE", target: "/admin/cache?key=a&prefix=b", header: adminHeader(), status: 400},
+	{pattern: "DELETE /admin/cache", method: "DELETE", target: "/admin/cache", header: adminHeader(), status: 200},
+
+	// The streams don't end, so for these the check looks at the answer's head only
+	{pattern: "GET /v1/classifiers/events", method: "GET", target: "/v1/classifiers/events", status: 200},
+	{pattern: "GET /v1/classifiers/events", method: "GET", target: "/v1/classifiers/events", header: map[string]string{"Last-Event-ID": "nope"}, status: 400},
+	{pattern: "GET /v1/classifiers/live", method: "GET", target: "/v1/classifiers/live", header: map[string]string{
+		"Connection": "Upgrade", "Upgrade": "websocket", "Sec-WebSocket-Version": "13", "Sec-WebSocket-Key": "dGhlIHNhbXBsZSBub25jZQ==",
+	}, status: 101},
+	{pattern: "GET /v1/classifiers/live", method: "GET", target: "/v1/classifiers/live", status: 400},
+}
+
+// checkOpenAPI is `classifier openapi check`: runCheck on a temp directory, with the
+// problems on stderr
+func checkOpenAPI(cfg config, stdout, stderr io.Writer) int {
+	// The scenario asks for errors on purpose, the handlers logging them is noise here
+	cfg.logger = slog.New(slog.DiscardHandler)
+
+	dir, err := os.MkdirTemp("", "classifier-openapi-")
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	requests, operations, failures, err := runCheck(cfg, dir)
+	if err != nil {
+		fmt.Fprintf(stderr, "error: %v\n", err)
+		return 1
+	}
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			fmt.Fprintln(stderr, failure)
+		}
+		fmt.Fprintf(stderr, "%d problems, the document and the API don't match\n", len(failures))
+		return 1
+	}
+	fmt.Fprintf(stdout, "ok: %d requests over %d operations match the document\n", requests, operations)
+	return 0
+}
+
+// runCheck is the divergence check: the real routes and handlers on SQLite files in
+// dir, every answer held against the document. The scenario runs twice, each time on
+// a fresh database: on the versioned routes, then on the legacy aliases, which have
+// to answer the same plus the deprecation headers. The go test runs it too
+func runCheck(cfg config, dir string) (requests, operations int, failures []string, err error) {
+	var doc *openapi.Document
+	covered := make(map[string]bool)
+	for _, legacy := range []bool{false, true} {
+		app, cleanup, err := checkApp(cfg, filepath.Join(dir, fmt.Sprintf("check-%t.db", legacy)))
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		n, passFailures, err := runCheckPass(app, legacy, covered)
+		cleanup()
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		if !legacy {
+			failures = append(failures, app.docProblems...)
+		}
+		failures = append(failures, passFailures...)
+		requests += n
+		doc = app.openapi
+	}
+
+	for _, pattern := range doc.Patterns() {
+		if !covered[pattern] {
+			failures = append(failures, fmt.Sprintf("%s: the check never calls it", pattern))
+		}
+	}
+	return requests, len(covered), failures, nil
+}
+
+// runCheckPass serves app and runs the scenario on it. With legacy the steps on
+// versioned routes go to their legacy aliases instead, and the rest are skipped
+func runCheckPass(app *application, legacy bool, covered map[string]bool) (requests int, failures []string, err error) {
+	handler := app.routes()
+
+	aliases := make(map[string]string)
+	for _, version := range app.apiVersions() {
+		for _, route := range version.routes {
+			if route.legacy != "" {
+				aliases[versioned(version.prefix, route.pattern)] = route.legacy
+			}
+		}
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, nil, err
+	}
+	srv := &http.Server{Handler: handler}
+	go srv.Serve(ln)
+	defer srv.Close()
+	base := "http://" + ln.Addr().String()
+
+	validator := &openapi.Validator{Schemas: app.openapi.Components.Schemas, Strict: true}
+	for _, step := range checkScenario {
+		if legacy {
+			alias, ok := aliases[step.pattern]
+			if !ok {
+				continue
+			}
+			step.target = aliasTarget(step.pattern, alias, step.target)
+			step.pattern = alias
+		}
+
+		op := app.openapi.Operation(step.pattern)
+		if op == nil {
+			failures = append(failures, fmt.Sprintf("%s %s: %s isn't in the document", step.method, step.target, step.pattern))
+			continue
+		}
+		covered[step.pattern] = true
+		requests++
+		for _, problem := range runCheckStep(base, step, op, validator) {
+			failures = append(failures, fmt.Sprintf("%s %s: %s", step.method, step.target, problem))
+		}
+	}
+	return requests, failures, nil
+}
+
+// aliasTarget moves a request from one pattern to another with the same wildcards:
+// /v1/classifiers/7?as_of=2025-06-30 for GET /classifiers/{id} is /classifiers/7?as_of=2025-06-30
+func aliasTarget(from, to, target string) string {
+	_, fromPath, _ := strings.Cut(from, " ")
+	_, toPath, _ := strings.Cut(to, " ")
+	path, query, _ := strings.Cut(target, "?")
+
+	values := make(map[string]string)
+	segments := strings.Split(path, "/")
+	for i, segment := range strings.Split(fromPath, "/") {
+		if m := pathParamRE.FindStringSubmatch(segment); m != nil && i < len(segments) {
+			values[m[1]] = segments[i]
+		}
+	}
+	moved := fillPath(toPath, func(name string) string { return values[name] })
+	if query != "" {
+		moved += "?" + query
+	}
+	return moved
+}
+
+// checkApp wires an application like main does, on a fresh SQLite file and without
+// the background dispatchers
+func checkApp(cfg config, path string) (*application, func(), error) {
+	cfg.db.dialect, cfg.db.dsn = parseDSN("sqlite://" + path)
+	cfg.db.replicas.dsns = nil
+	cfg.cache.backend = "memory"
+	cfg.cache.invalidation = "none"
+	cfg.adminToken = checkAdminToken
+	cfg.webhooks.allowPrivate = false // the scenario expects the private URL turned down
+
+	ctx := context.Background()
+	db, err := connectDB(ctx, cfg, cfg.db.dialect, cfg.db.dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening the check database: %w", err)
+	}
+	migrator, err := models.NewMigrator(db, cfg.db.dialect, models.MigratorOptions{})
+	if err == nil {
+		_, err = migrator.Up(ctx)
+	}
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("migrating the check database: %w", err)
+	}
+
+	app := &application{config: cfg}
+	cleanup := func() { db.Close() }
+	fail := func(err error) (*application, func(), error) {
+		cleanup()
+		return nil, nil, err
+	}
+
+	timeouts, err := cfg.queryTimeouts()
+	if err != nil {
+		return fail(err)
+	}
+	replicas, err := openReplicas(cfg, db)
+	if err != nil {
+		return fail(err)
+	}
+	app.cache, _ = openCache(cfg)
+	model, err := models.NewClassifierModel(db, models.ClassifierModelOptions{
+		Cache:    app.cache,
+		Dialect:  cfg.db.dialect,
+		Timeouts: timeouts,
+		Replicas: replicas,
+	})
+	if err != nil {
+		return fail(err)
+	}
+	resilienceOpts, err := cfg.resilienceOptions()
+	if err != nil {
+		return fail(err)
+	}
+	resilient := models.NewResilientStore(model, resilienceOpts)
+	app.model = resilient
+	app.resilience = resilient
+	app.replicas = replicas
+	app.metrics = models.NewMetricsCollector(db)
+
+	if app.webhooks, err = models.NewWebhookModel(db, cfg.db.dialect); err != nil {
+		return fail(err)
+	}
+	if app.stream, err = cfg.streamSettings(); err != nil {
+		return fail(err)
+	}
+	if app.feed, err = models.NewChangeFeed(db, cfg.db.dialect, models.ChangeFeedOptions{ReplaySize: cfg.events.replaySize}); err != nil {
+		return fail(err)
+	}
+	if app.legacy, err = cfg.legacySettings(); err != nil {
+		return fail(err)
+	}
+	if app.trustedProxies, err = cfg.trustedProxyPrefixes(); err != nil {
+		return fail(err)
+	}
+	app.feed.Start()
+	app.ready.Store(true)
+
+	cleanup = func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		app.feed.Stop(stopCtx)
+		db.Close()
+	}
+	return app, cleanup, nil
+}
+
+// runCheckStep makes the request and says what in the answer the document doesn't have
+func runCheckStep(base string, step checkStep, op *openapi.Operation, validator *openapi.Validator) []string {
+	// The streams stay open, the head is enough
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, step.method, base+step.target, strings.NewReader(step.body))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	for name, value := range step.header {
+		req.Header.Set(name, value)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	defer res.Body.Close()
+
+	var problems []string
+	if res.StatusCode != step.status {
+		problems = append(problems, fmt.Sprintf("got %d, the scenario expects %d", res.StatusCode, step.status))
+	}
+
+	var body []byte
+	if !isStream(res.StatusCode, res.Header) {
+		if body, err = io.ReadAll(res.Body); err != nil {
+			return append(problems, err.Error())
+		}
+		body = append([]byte{}, body...)
+	}
+	return append(problems, checkResponse(op, res.StatusCode, res.Header, body, validator)...)
+}
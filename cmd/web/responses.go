@@ -23,6 +23,26 @@ type listMetadata struct {
 	Pages    int `json:"pages"`
 }
 
+type historyResponse struct {
+	History  []*models.AuditEntry `json:"history"`
+	Metadata listMetadata        `json:"metadata"`
+}
+
+// diffResponse compares two versions: each side is the classifier as that version
+// left it (null after the delete)
+type diffResponse struct {
+	ClassifierID int64                `json:"classifier_id"`
+	From         diffVersion          `json:"from"`
+	To           diffVersion          `json:"to"`
+	Changes      []models.FieldChange `json:"changes"`
+}
+
+type diffVersion struct {
+	Version    int                        `json:"version"`
+	Action     models.AuditAction         `json:"action"`
+	Classifier *models.ClassifierSnapshot `json:"classifier"`
+}
+
 type classifierResponse struct {
 	Classifier *models.Classifier `json:"classifier"`
 }
@@ -62,3 +82,8 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 func (app *application) badRequestError(w http.ResponseWriter, r *http.Request, err error) {
 	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
 }
+
+// conflictError returns a 409 Conflict, for when what you send clashes with what we have
+func (app *application) conflictError(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
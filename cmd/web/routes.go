@@ -1,26 +1,75 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
+	"sync/atomic"
 )
 
 func (app *application) routes() http.Handler {
 	// Aca definimos todas las routes, super important stuff
 	mux := http.NewServeMux()
+	// Every handler gets checked against the OpenAPI document, when that's turned on
+	api := &routeTable{mux: mux, wrap: app.validateOpenAPI}
 	
 	// Home endpoint, nothing fancy viste
-	mux.HandleFunc("GET /", app.Home)
+	api.HandleFunc("GET /", app.Home)
+
+	// Probes for the orchestrator: alive always, ready after the warm-up
+	api.HandleFunc("GET /healthz", app.healthzHandler)
+	api.HandleFunc("GET /readyz", app.readyzHandler)
+
+	// The OpenAPI document, built from this same table, and a page to browse it
+	api.HandleFunc("GET /openapi.json", app.OpenAPI)
+	api.HandleFunc("GET /docs", app.Docs)
 	
-	// CRUD operations for our classifiers, re piola
-	mux.HandleFunc("POST /classifiers/create", app.CreateClassifier)
-	mux.HandleFunc("GET /classifiers", app.ListClassifiers)
-	mux.HandleFunc("GET /classifiers/{id}", app.GetClassifier)
+	// The API proper, by version, each under its prefix (see apiVersions). The routes
+	// from before /v1 keep working as deprecated aliases until the sunset
+	app.legacyCalls = make(map[string]*atomic.Int64)
+	for _, version := range app.apiVersions() {
+		app.addVersion(api, version)
+	}
 	
 	// Metrics endpoint for cuando everything explota
-	mux.HandleFunc("GET /debug/metrics", app.metricsHandler)
+	api.HandleFunc("GET /debug/metrics", app.metricsHandler)
+
+	// Admin stuff, solo con ADMIN_TOKEN
+	api.HandleFunc("GET /admin/cache", app.requireAdmin(app.cacheStatsHandler))
+	api.HandleFunc("DELETE /admin/cache", app.requireAdmin(app.cacheFlushHandler))
+
+	// Routes and docs that don't match up get logged, `classifier openapi check` fails on them
+	app.openapi, app.docProblems = buildOpenAPI(api.routes)
+	for _, problem := range app.docProblems {
+		app.logger.Warn("API docs out of date", "problem", problem)
+	}
+	app.openapiJSON, _ = json.Marshal(app.openapi)
 
 	// Add the gzip middleware porque performance viste
 	// This makes everything mas rapido, trust me
-	handler := app.gzipMiddleware(mux)
+	handler := app.gzipMiddleware(app.requestID(app.clientID(mux)))
 	return handler
 }
+
+// bootRoutes is what we serve while the database comes up (SERVER_EARLY_PROBES):
+// alive, not ready, and a 503 for everything else
+func (app *application) bootRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", app.healthzHandler)
+	mux.HandleFunc("GET /readyz", app.readyzHandler)
+	mux.HandleFunc("/", app.startingUpHandler)
+	return mux
+}
+
+// swapHandler lets main switch from the boot routes to the real ones without
+// restarting the listener
+type swapHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+func (s *swapHandler) set(h http.Handler) {
+	s.handler.Store(&h)
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.handler.Load()).ServeHTTP(w, r)
+}
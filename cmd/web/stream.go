@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+// streamSettings is how the event streams behave, parsed from the EVENTS_* settings
+type streamSettings struct {
+	queue        int
+	heartbeat    time.Duration
+	writeTimeout time.Duration
+	retry        time.Duration // how long browsers wait to reconnect
+	origins      []string      // allowed on the WebSocket besides our own
+}
+
+// ClassifierEvents streams the change events as Server-Sent Events
+// A client that reconnects with Last-Event-ID (or ?last_event_id=, for the first
+// connection) gets what it missed from the replay buffer; if that's gone too far back
+// it gets a "reset" event and should reload the list. A client that can't keep up is
+// cut off and comes back the same way
+func (app *application) ClassifierEvents(w http.ResponseWriter, r *http.Request) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		raw = r.URL.Query().Get("last_event_id")
+	}
+	var lastEventID int64
+	if raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id < 0 {
+			app.badRequestError(w, r, fmt.Errorf("invalid Last-Event-ID, it has to be an event id"))
+			return
+		}
+		lastEventID = id
+	}
+
+	sub := app.feed.Subscribe(lastEventID, app.stream.queue)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // que nginx no se guarde los eventos
+	w.WriteHeader(http.StatusOK)
+
+	// The server's WriteTimeout would cut the stream after 30s, so every write gets its
+	// own deadline instead: a client that stops reading gets dropped after that
+	rc := http.NewResponseController(w)
+	send := func(write func(w io.Writer) error) bool {
+		rc.SetWriteDeadline(time.Now().Add(app.stream.writeTimeout))
+		if err := write(w); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	// retry tells the browser how long to wait before reconnecting
+	if !send(func(w io.Writer) error {
+		if _, err := fmt.Fprintf(w, "retry: %d\n\n", app.stream.retry.Milliseconds()); err != nil {
+			return err
+		}
+		if sub.Reset {
+			_, err := io.WriteString(w, "event: reset\ndata: {}\n\n")
+			return err
+		}
+		for _, event := range sub.Replay {
+			if err := writeEvent(w, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}) {
+		return
+	}
+
+	// Comments keep proxies from closing a quiet connection, and tell us when it's gone
+	heartbeat := time.NewTicker(app.stream.heartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Dropped for lagging or we're shutting down: either way the client
+				// reconnects with its Last-Event-ID, here or on another pod
+				if sub.Lagged() {
+					app.logger.Warn("Event stream dropped, the client wasn't keeping up",
+						"client_id", models.ClientID(r.Context()))
+				}
+				return
+			}
+			if !send(func(w io.Writer) error { return writeEvent(w, event) }) {
+				return
+			}
+		case <-heartbeat.C:
+			if !send(func(w io.Writer) error {
+				_, err := io.WriteString(w, ": ping\n\n")
+				return err
+			}) {
+				return
+			}
+		}
+	}
+}
+
+// writeEvent writes one event in SSE format, the outbox id is the event id
+func writeEvent(w io.Writer, event models.ChangeEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
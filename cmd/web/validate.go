@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"classifier.buhtigexa.net/internal/openapi"
+)
+
+// Bodies past this aren't validated, they go to the handler as they came
+const maxValidatedBody = 1 << 20
+
+// validateOpenAPI checks each request against the operation r.Pattern matched in the
+// OpenAPI document, and with OPENAPI_VALIDATE_RESPONSES the answer too. It goes
+// around every handler in the route table, the pattern isn't known any earlier
+func (app *application) validateOpenAPI(next http.HandlerFunc) http.HandlerFunc {
+	if !app.validation.requests && !app.validation.responses {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// The document is built after the routes, so it's looked up here and not above
+		op := app.openapi.Operation(r.Pattern)
+		if op == nil {
+			next(w, r)
+			return
+		}
+
+		if app.validation.requests {
+			if err := app.validateRequest(op, r); err != nil {
+				app.badRequestError(w, r, err)
+				return
+			}
+		}
+
+		// A websocket takes the connection, there's no answer to check
+		if !app.validation.responses || r.Header.Get("Upgrade") != "" {
+			next(w, r)
+			return
+		}
+		vw := &validatingWriter{ResponseWriter: w}
+		next(vw, r)
+		if vw.status == 0 {
+			vw.status = http.StatusOK
+		}
+		var body []byte
+		if !vw.dropped {
+			body = append([]byte{}, vw.body.Bytes()...)
+		}
+		validator := &openapi.Validator{Schemas: app.openapi.Components.Schemas, Strict: true}
+		for _, problem := range checkResponse(op, vw.status, vw.Header(), body, validator) {
+			app.logger.Warn("Response doesn't match the OpenAPI document",
+				"method", r.Method, "url", r.URL.RequestURI(), "pattern", r.Pattern, "status", vw.status, "problem", problem)
+		}
+	}
+}
+
+// validateRequest checks the parameters and the JSON body. Fields the schema doesn't
+// list are let through, same as the handlers' decoders do
+func (app *application) validateRequest(op *openapi.Operation, r *http.Request) error {
+	validator := &openapi.Validator{Schemas: app.openapi.Components.Schemas}
+
+	query := r.URL.Query()
+	for _, p := range op.Parameters {
+		var raw string
+		var present bool
+		switch p.In {
+		case "path":
+			raw = r.PathValue(p.Name)
+			present = true
+		case "query":
+			present = query.Has(p.Name)
+			raw = query.Get(p.Name)
+		case "header":
+			raw = r.Header.Get(p.Name)
+			present = raw != ""
+		}
+		if !present {
+			if p.Required {
+				return fmt.Errorf("%s parameter is required", p.Name)
+			}
+			continue
+		}
+		value, err := parameterValue(p.Schema, raw)
+		if err == nil {
+			err = validator.Validate(p.Schema, value)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s parameter: %s", p.Name, validationMessage(err))
+		}
+	}
+
+	if op.RequestBody == nil || op.RequestBody.Content["application/json"] == nil {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxValidatedBody+1))
+	if err != nil {
+		return fmt.Errorf("reading the body: %w", err)
+	}
+	if len(data) > maxValidatedBody {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+		return nil
+	}
+	r.Body = readCloser{bytes.NewReader(data), r.Body}
+	if err := validator.ValidateJSON(op.RequestBody.Content["application/json"].Schema, data); err != nil {
+		return fmt.Errorf("invalid body: %s", validationMessage(err))
+	}
+	return nil
+}
+
+// readCloser is the body again after reading it, closing the original one
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// parameterValue turns a parameter from the URL or a header into what the schema
+// expects, so 5 is a number and not the string "5"
+func parameterValue(s *openapi.Schema, raw string) (interface{}, error) {
+	types := s.Type
+	if len(types) == 0 && len(s.AnyOf) > 0 {
+		types = s.AnyOf[0].Type
+	}
+	switch {
+	case types.Has("integer"):
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, errors.New("should be an integer")
+		}
+		return float64(n), nil
+	case types.Has("number"):
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, errors.New("should be a number")
+		}
+		return n, nil
+	case types.Has("boolean"):
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, errors.New("should be true or false")
+		}
+		return b, nil
+	}
+	return raw, nil
+}
+
+// validationMessage drops the pointer for a parameter (it's the root) and keeps it
+// for a body, where it says which field
+func validationMessage(err error) string {
+	var verr *openapi.ValidationError
+	if errors.As(err, &verr) && verr.Path == "" {
+		return verr.Message
+	}
+	return err.Error()
+}
+
+// validatingWriter keeps a copy of the answer while it goes out, to check it after
+type validatingWriter struct {
+	http.ResponseWriter
+	status  int
+	body    bytes.Buffer
+	dropped bool // a stream, or too big to keep
+}
+
+func (vw *validatingWriter) WriteHeader(status int) {
+	if vw.status == 0 {
+		vw.status = status
+	}
+	vw.ResponseWriter.WriteHeader(status)
+}
+
+func (vw *validatingWriter) Write(b []byte) (int, error) {
+	if vw.status == 0 {
+		vw.status = http.StatusOK
+	}
+	if !vw.dropped {
+		if isStream(vw.status, vw.Header()) || vw.body.Len()+len(b) > maxValidatedBody {
+			vw.dropped = true
+			vw.body = bytes.Buffer{}
+		} else {
+			vw.body.Write(b)
+		}
+	}
+	return vw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the real writer, the streams flush through it
+func (vw *validatingWriter) Unwrap() http.ResponseWriter {
+	return vw.ResponseWriter
+}
+
+// checkResponse says what in an answer the operation's docs don't have: the status,
+// the headers it promises, the content type and, for JSON, the body. body is nil when
+// it wasn't kept (a stream), then only the head is checked. The middleware logs these
+// and `classifier openapi check` fails on them
+func checkResponse(op *openapi.Operation, status int, header http.Header, body []byte, validator *openapi.Validator) []string {
+	doc, ok := op.Responses[strconv.Itoa(status)]
+	if !ok {
+		return []string{fmt.Sprintf("%d isn't a documented response", status)}
+	}
+
+	var problems []string
+	names := make([]string, 0, len(doc.Headers))
+	for name := range doc.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if header.Get(name) == "" {
+			problems = append(problems, fmt.Sprintf("no %s header, the docs say there is one", name))
+		}
+	}
+
+	if len(doc.Content) == 0 {
+		if len(body) > 0 {
+			problems = append(problems, "has a body, the docs say it doesn't")
+		}
+		return problems
+	}
+
+	contentType := header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	var media *openapi.MediaType
+	for documented, m := range doc.Content {
+		if want, _, _ := mime.ParseMediaType(documented); want == mediaType {
+			media = m
+		}
+	}
+	if media == nil {
+		return append(problems, fmt.Sprintf("Content-Type %q isn't documented", contentType))
+	}
+	if mediaType == "application/json" && body != nil {
+		if err := validator.ValidateJSON(media.Schema, body); err != nil {
+			problems = append(problems, "body: "+err.Error())
+		}
+	}
+	return problems
+}
+
+// isStream says whether an answer doesn't end on its own, so nobody should wait for its body
+func isStream(status int, header http.Header) bool {
+	return status == http.StatusSwitchingProtocols || strings.HasPrefix(header.Get("Content-Type"), "text/event-stream")
+}
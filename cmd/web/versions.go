@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// apiRoute is one operation of a version of the API, its pattern without the prefix.
+// legacy is the route it had before the API was versioned, still served as a
+// deprecated alias until the sunset
+type apiRoute struct {
+	pattern string
+	handler http.HandlerFunc
+	legacy  string
+}
+
+// apiVersion is a version of the API, served under its prefix. The docs of its
+// operations are in apiDocs under the full pattern, "POST /v1/classifiers"
+type apiVersion struct {
+	prefix string
+	routes []apiRoute
+}
+
+// apiVersions are served side by side. A /v2 gets its own entry and its own docs,
+// pointing at the v1 handlers for whatever didn't change; v1 stays as it is
+func (app *application) apiVersions() []apiVersion {
+	return []apiVersion{
+		{prefix: "/v1", routes: []apiRoute{
+			// CRUD operations for our classifiers, re piola
+			{"POST /classifiers", app.CreateClassifier, "POST /classifiers/create"},
+			{"GET /classifiers", app.ListClassifiers, "GET /classifiers"},
+			// The whole catalog as a download, streamed (literal paths win over {id})
+			{"GET /classifiers/export", app.ExportClassifiers, "GET /classifiers/export"},
+			// and the other way around, loading a CSV or NDJSON file
+			{"POST /classifiers/import", app.ImportClassifiers, "POST /classifiers/import"},
+			{"GET /classifiers/{id}", app.GetClassifier, "GET /classifiers/{id}"},
+			{"PATCH /classifiers/{id}", app.UpdateClassifier, "PATCH /classifiers/{id}"},
+			{"DELETE /classifiers/{id}", app.DeleteClassifier, "DELETE /classifiers/{id}"},
+
+			// Change events as Server-Sent Events, for the admin UI instead of polling
+			{"GET /classifiers/events", app.ClassifierEvents, "GET /classifiers/events"},
+			// and over a WebSocket, subscribing to some ids or a subtree
+			{"GET /classifiers/live", app.LiveClassifiers, "GET /classifiers/live"},
+
+			// Audit trail: every version of a classifier, the diff between any two and going back to one
+			{"GET /classifiers/{id}/history", app.ClassifierHistory, "GET /classifiers/{id}/history"},
+			{"GET /classifiers/{id}/history/diff", app.ClassifierDiff, "GET /classifiers/{id}/history/diff"},
+			{"POST /classifiers/{id}/revert", app.RevertClassifier, "POST /classifiers/{id}/revert"},
+
+			// Webhooks: who gets the change events pushed, and how each delivery went
+			// Admin only, they're everybody's subscriptions and they make us send requests
+			{"POST /webhooks", app.requireAdmin(app.CreateWebhook), "POST /webhooks"},
+			{"GET /webhooks", app.requireAdmin(app.ListWebhooks), "GET /webhooks"},
+			{"GET /webhooks/{id}", app.requireAdmin(app.GetWebhook), "GET /webhooks/{id}"},
+			{"DELETE /webhooks/{id}", app.requireAdmin(app.DeleteWebhook), "DELETE /webhooks/{id}"},
+			{"GET /webhooks/{id}/deliveries", app.requireAdmin(app.WebhookDeliveries), "GET /webhooks/{id}/deliveries"},
+			{"POST /webhooks/{id}/deliveries/{delivery}/retry", app.requireAdmin(app.RetryWebhookDelivery), "POST /webhooks/{id}/deliveries/{delivery}/retry"},
+		}},
+	}
+}
+
+// versioned puts the pattern under the prefix: "POST /classifiers" -> "POST /v1/classifiers"
+func versioned(prefix, pattern string) string {
+	method, path, _ := strings.Cut(pattern, " ")
+	return method + " " + prefix + path
+}
+
+// addVersion registers a version's routes and their legacy aliases. Every alias gets a
+// counter in app.legacyCalls, to know who still has to move before the sunset
+func (app *application) addVersion(api *routeTable, v apiVersion) {
+	for _, route := range v.routes {
+		pattern := versioned(v.prefix, route.pattern)
+		api.HandleFunc(pattern, route.handler)
+		if route.legacy == "" {
+			continue
+		}
+		calls := new(atomic.Int64)
+		app.legacyCalls[route.legacy] = calls
+		api.handle(tableRoute{pattern: route.legacy, successor: pattern}, route.handler, app.deprecated(pattern, calls))
+	}
+}
+
+// deprecated serves a legacy route: the same handler, plus the headers that say it's
+// going away (Deprecation, RFC 9745, and Sunset, RFC 8594) and what replaces it
+func (app *application) deprecated(successor string, calls *atomic.Int64) func(http.HandlerFunc) http.HandlerFunc {
+	_, successorPath, _ := strings.Cut(successor, " ")
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+
+			h := w.Header()
+			if !app.legacy.deprecated.IsZero() {
+				h.Set("Deprecation", fmt.Sprintf("@%d", app.legacy.deprecated.Unix()))
+			}
+			if !app.legacy.sunset.IsZero() {
+				h.Set("Sunset", app.legacy.sunset.UTC().Format(http.TimeFormat))
+			}
+			h.Set("Link", fmt.Sprintf("<%s>; rel=\"successor-version\"", fillPath(successorPath, r.PathValue)))
+			next(w, r)
+		}
+	}
+}
+
+// fillPath puts the values of the wildcards in a pattern's path: /v1/classifiers/{id}
+// with id 7 is /v1/classifiers/7
+func fillPath(path string, value func(name string) string) string {
+	return pathParamRE.ReplaceAllStringFunc(path, func(wildcard string) string {
+		return value(pathParamRE.FindStringSubmatch(wildcard)[1])
+	})
+}
+
+// legacySettings are the dates the legacy routes announce
+type legacySettings struct {
+	deprecated time.Time
+	sunset     time.Time // after this they can go away
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+// warmUp preloads the first list pages and the newest classifiers into the model cache
+// so the first users after a deploy don't eat the cold-cache latency. It's best effort:
+// on timeout or error we log and move on, readiness turns green anyway
+func (app *application) warmUp(ctx context.Context) {
+	cfg := app.warmup
+	if cfg.pages <= 0 && cfg.recent <= 0 {
+		return
+	}
+
+	timeout, err := time.ParseDuration(cfg.timeout)
+	if err != nil {
+		app.logger.Warn("Invalid warm-up timeout, skipping warm-up", "timeout", cfg.timeout, "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	app.logger.Info("Cache warm-up starting",
+		"pages", cfg.pages,
+		"page_size", cfg.pageSize,
+		"recent", cfg.recent,
+		"timeout", timeout,
+	)
+
+	pagesLoaded := 0
+	for page := 1; page <= cfg.pages; page++ {
+		if ctx.Err() != nil {
+			app.warmUpAborted(ctx, start, pagesLoaded, 0)
+			return
+		}
+
+		classifiers, total, err := app.model.List(ctx, models.ListClassifiersOptions{
+			Page:     page,
+			PageSize: cfg.pageSize,
+		})
+		if err != nil {
+			app.logger.Warn("Cache warm-up failed loading a page", "page", page, "error", err)
+			return
+		}
+		pagesLoaded++
+
+		app.logger.Info("Cache warm-up progress",
+			"step", "list",
+			"page", page,
+			"of", cfg.pages,
+			"rows", len(classifiers),
+		)
+
+		if page*cfg.pageSize >= total {
+			break // No hay mas paginas, para que seguir
+		}
+	}
+
+	// The newest classifiers are the ones people open right after listing
+	ids, err := app.recentClassifierIDs(ctx, cfg.recent)
+	if err != nil {
+		app.logger.Warn("Cache warm-up failed listing recent classifiers", "error", err)
+		return
+	}
+
+	recentLoaded := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			app.warmUpAborted(ctx, start, pagesLoaded, recentLoaded)
+			return
+		}
+		if _, err := app.model.Get(ctx, id); err != nil {
+			app.logger.Warn("Cache warm-up failed loading a classifier", "id", id, "error", err)
+			continue
+		}
+		recentLoaded++
+
+		if recentLoaded%25 == 0 || recentLoaded == len(ids) {
+			app.logger.Info("Cache warm-up progress",
+				"step", "recent",
+				"loaded", recentLoaded,
+				"of", len(ids),
+			)
+		}
+	}
+
+	app.logger.Info("Cache warm-up done",
+		"pages", pagesLoaded,
+		"recent", recentLoaded,
+		"elapsed", time.Since(start),
+	)
+}
+
+// recentClassifierIDs walks the list (newest first) until it has n ids or runs out
+func (app *application) recentClassifierIDs(ctx context.Context, n int) ([]int64, error) {
+	const pageSize = 100
+
+	ids := make([]int64, 0, n)
+	for page := 1; len(ids) < n; page++ {
+		if ctx.Err() != nil {
+			return ids, nil
+		}
+
+		classifiers, total, err := app.model.List(ctx, models.ListClassifiersOptions{
+			Page:     page,
+			PageSize: pageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range classifiers {
+			if len(ids) == n {
+				break
+			}
+			ids = append(ids, c.ID)
+		}
+
+		if page*pageSize >= total {
+			break
+		}
+	}
+	return ids, nil
+}
+
+func (app *application) warmUpAborted(ctx context.Context, start time.Time, pages, recent int) {
+	app.logger.Warn("Cache warm-up stopped early",
+		"reason", context.Cause(ctx),
+		"pages", pages,
+		"recent", recent,
+		"elapsed", time.Since(start),
+	)
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+type createWebhookRequest struct {
+	URL        string             `json:"url" jsonschema:"format=uri,maxLength=2048"`
+	EventTypes []models.EventType `json:"event_types,omitempty"` // empty means every event
+	Secret     string             `json:"secret,omitempty" jsonschema:"minLength=16,maxLength=128"`
+}
+
+type deliveriesResponse struct {
+	Deliveries []*models.WebhookDelivery `json:"deliveries"`
+	Metadata   listMetadata              `json:"metadata"`
+}
+
+// CreateWebhook subscribes a URL to the change events
+// The answer is the only place the secret shows up, after that it's never read back
+func (app *application) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req createWebhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" || len(req.URL) > 2048 {
+		app.badRequestError(w, r, fmt.Errorf("url has to be an absolute http or https URL"))
+		return
+	}
+	for _, t := range req.EventTypes {
+		if t != models.EventCreated && t != models.EventUpdated && t != models.EventDeleted {
+			app.badRequestError(w, r, fmt.Errorf("unknown event type %q, use created, updated or deleted", t))
+			return
+		}
+	}
+	if req.Secret != "" && (len(req.Secret) < 16 || len(req.Secret) > 128) {
+		app.badRequestError(w, r, fmt.Errorf("secret has to be between 16 and 128 characters, or leave it out and we make one"))
+		return
+	}
+	// Loopback, private and link-local addresses are off limits, unless WEBHOOK_ALLOW_PRIVATE
+	if !app.config.webhooks.allowPrivate {
+		if err := models.CheckWebhookTarget(r.Context(), req.URL); err != nil {
+			if errors.Is(err, models.ErrPrivateWebhookTarget) {
+				app.badRequestError(w, r, fmt.Errorf("url has to point to a public address"))
+			} else {
+				app.badRequestError(w, r, fmt.Errorf("url host can't be resolved"))
+			}
+			return
+		}
+	}
+
+	webhook, err := app.webhooks.Insert(r.Context(), models.WebhookInput{
+		URL:        req.URL,
+		EventTypes: req.EventTypes,
+		Secret:     req.Secret,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	headers := http.Header{"Location": []string{fmt.Sprintf("/v1/webhooks/%d", webhook.ID)}}
+	err = app.writeJSON(w, http.StatusCreated, envelope{"webhook": webhook}, headers)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.webhooks.List(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhooks": webhooks}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+func (app *application) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+
+	webhook, err := app.webhooks.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFoundError(w, r, fmt.Sprintf("%d", id))
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"webhook": webhook}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// DeleteWebhook unsubscribes, what was still pending for it doesn't go out
+func (app *application) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+
+	if err := app.webhooks.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFoundError(w, r, fmt.Sprintf("%d", id))
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries is the delivery log, newest first: ?status=dead finds the dead letters
+func (app *application) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+
+	page, pageSize, err := readPagination(r)
+	if err != nil {
+		app.badRequestError(w, r, err)
+		return
+	}
+
+	status := models.DeliveryStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", models.DeliveryPending, models.DeliveryDelivered, models.DeliveryDead:
+	default:
+		app.badRequestError(w, r, fmt.Errorf("invalid status parameter, use pending, delivered or dead"))
+		return
+	}
+
+	// The log of a webhook that doesn't exist is a 404, not an empty page
+	if _, err := app.webhooks.Get(r.Context(), id); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFoundError(w, r, fmt.Sprintf("%d", id))
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	deliveries, total, err := app.webhooks.Deliveries(r.Context(), id, models.DeliveryOptions{
+		Status:   status,
+		Page:     page,
+		PageSize: pageSize,
+	})
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	response := deliveriesResponse{
+		Deliveries: deliveries,
+		Metadata: listMetadata{
+			Total:    total,
+			Page:     page,
+			PageSize: pageSize,
+			Pages:    (total + pageSize - 1) / pageSize,
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"data": response}, nil)
+	if err != nil {
+		app.serverError(w, r, err)
+	}
+}
+
+// RetryWebhookDelivery brings a dead delivery back with a fresh set of attempts
+func (app *application) RetryWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid id parameter"))
+		return
+	}
+	deliveryID, err := strconv.ParseInt(r.PathValue("delivery"), 10, 64)
+	if err != nil || deliveryID < 1 {
+		app.badRequestError(w, r, fmt.Errorf("invalid delivery parameter"))
+		return
+	}
+
+	if err := app.webhooks.RetryDelivery(r.Context(), id, deliveryID); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFoundError(w, r, fmt.Sprintf("%d/%d", id, deliveryID))
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -4,17 +4,12 @@
 USE classifiersdb;
 
 
-create table if not exists classifiers (
-	id integer not null primary key auto_increment,
-	name varchar(100) not null,
-	description text null,
-	is_active boolean null default true,
-	created_at datetime not null default current_timestamp
-);
-
-
+-- The tables live in internal/models/migrations/mysql, embedded in the binary
+-- Run `classifier migrate up` (or DB_AUTO_MIGRATE=true) after this script
 
 
 CREATE USER 'appuser'@'localhost';
-GRANT SELECT, INSERT, UPDATE , DELETE ON classifiersdb.* TO 'appuser'@'localhost';
+-- The migrations run as this same user (classifier migrate up, DB_AUTO_MIGRATE), so on top
+-- of the data it needs to create, alter, index and drop tables. GET_LOCK needs nothing
+GRANT SELECT, INSERT, UPDATE, DELETE, CREATE, ALTER, INDEX, DROP ON classifiersdb.* TO 'appuser'@'localhost';
 ALTER USER 'appuser'@'localhost' IDENTIFIED by 'websecret';
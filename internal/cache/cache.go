@@ -3,7 +3,10 @@
 package cache
 
 import (
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,6 +23,27 @@ type Cache struct {
 	items    map[string]item     // The actual storage, nothing fancy viste
 	done     chan struct{}       // Channel to tell the cleanup goroutine "che, time to go home"
 	stopOnce sync.Once          // Makes sure we don't close things twice, would be alta cagada
+	hits     atomic.Uint64       // Counters for the admin endpoints, so we know if this thing is worth it
+	misses   atomic.Uint64
+}
+
+// Stats is a snapshot of how the cache is doing
+// The expiry times are zero when the cache is empty
+type Stats struct {
+	Entries      int
+	Hits         uint64
+	Misses       uint64
+	OldestExpiry time.Time // the entry that goes away first
+	NewestExpiry time.Time // the entry that stays the longest
+}
+
+// HitRatio is hits over lookups, zero when nobody asked yet
+func (s Stats) HitRatio() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
 }
 
 // New creates a fresh cache, everything ready to rock
@@ -35,10 +59,15 @@ func New() *Cache {
 
 // Set puts something in the cache for a while
 // Like when you leave the mate somewhere and grab it later, ya know?
+// A ttl <= 0 doesn't store anything, it just drops the key (same as the RESP store)
 func (c *Cache) Set(key string, value interface{}, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	if ttl <= 0 {
+		delete(c.items, key)
+		return
+	}
 	c.items[key] = item{
 		value:     value,
 		expiresAt: time.Now().Add(ttl),
@@ -53,14 +82,17 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 
 	item, exists := c.items[key]
 	if !exists {
+		c.misses.Add(1)
 		return nil, false // Nah, not here che
 	}
 
 	if time.Now().After(item.expiresAt) {
-		delete(c.items, key) // This one's past its prime, delete it
+		// Past its prime, but we only hold the read lock, so the cleanup goroutine deletes it
+		c.misses.Add(1)
 		return nil, false
 	}
 
+	c.hits.Add(1)
 	return item.value, true // Found it! Everything copado
 }
 
@@ -72,6 +104,71 @@ func (c *Cache) Delete(key string) {
 	delete(c.items, key)
 }
 
+// Stats counts the live entries and finds the oldest and newest expiry
+func (c *Cache) Stats() Stats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := Stats{
+		Hits:   c.hits.Load(),
+		Misses: c.misses.Load(),
+	}
+
+	now := time.Now()
+	for _, item := range c.items {
+		if now.After(item.expiresAt) {
+			continue // Already dead, just waiting for the cleanup
+		}
+		stats.Entries++
+		if stats.OldestExpiry.IsZero() || item.expiresAt.Before(stats.OldestExpiry) {
+			stats.OldestExpiry = item.expiresAt
+		}
+		if item.expiresAt.After(stats.NewestExpiry) {
+			stats.NewestExpiry = item.expiresAt
+		}
+	}
+	return stats
+}
+
+// Keys lists the live keys starting with prefix, sorted so the output is stable
+// An empty prefix means every key
+func (c *Cache) Keys(prefix string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]string, 0, len(c.items))
+	for key, item := range c.items {
+		if strings.HasPrefix(key, prefix) && !now.After(item.expiresAt) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Flush removes every key starting with prefix and says how many went away
+// An empty prefix empties the whole thing, tabula rasa
+func (c *Cache) Flush(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if prefix == "" {
+		n := len(c.items)
+		c.items = make(map[string]item)
+		return n
+	}
+
+	n := 0
+	for key := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.items, key)
+			n++
+		}
+	}
+	return n
+}
+
 // Close tells the cleanup goroutine "che, time to go home"
 // Super important to call this or you'll leave goroutines hanging like dirty ropa
 func (c *Cache) Close() error {
@@ -0,0 +1,530 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStoreClosed is what you get for using a RedisStore after Close, no seas así
+var ErrStoreClosed = errors.New("cache: store is closed")
+
+// The most ReadReply takes from a peer before giving up on it. Bulk strings stop where
+// Redis stops them (proto-max-bulk-len), arrays a lot sooner, ours never get near
+const (
+	maxBulkLen  = 512 << 20
+	maxArrayLen = 1 << 20
+)
+
+// RedisOptions has everything needed to talk to a Redis-protocol server
+type RedisOptions struct {
+	Addr        string
+	Password    string
+	DB          int
+	DialTimeout time.Duration
+	IOTimeout   time.Duration
+	PoolSize    int         // how many idle connections we keep around
+	Codec       Codec       // required, we can't guess how to serialize your stuff
+	OnError     func(error) // the cache is best effort, so errors land here instead of the caller
+}
+
+// RedisStore is a Store backed by anything that speaks RESP (Redis, KeyDB, Valkey...)
+// Every replica talks to the same server, so they all see the same data, re piola
+type RedisStore struct {
+	opts RedisOptions
+
+	mu     sync.Mutex
+	idle   []*respConn
+	closed bool
+
+	hits   atomic.Uint64 // counted on this pod only, the server doesn't know who asked
+	misses atomic.Uint64
+}
+
+// NewRedis creates a RESP store and pings the server so we fail fast on a bad address
+func NewRedis(opts RedisOptions) (*RedisStore, error) {
+	if opts.Codec == nil {
+		return nil, errors.New("cache: redis store needs a codec")
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = 2 * time.Second
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 10
+	}
+
+	s := &RedisStore{opts: opts}
+	if _, err := s.do("PING"); err != nil {
+		return nil, fmt.Errorf("cache: pinging %s: %w", opts.Addr, err)
+	}
+	return s, nil
+}
+
+// Get fetches and decodes a value, any failure counts as a miss
+func (s *RedisStore) Get(key string) (interface{}, bool) {
+	reply, err := s.do("GET", key)
+	if err != nil {
+		s.reportError(err)
+		s.misses.Add(1)
+		return nil, false
+	}
+
+	data, ok := reply.([]byte)
+	if !ok || data == nil {
+		s.misses.Add(1)
+		return nil, false // Nil bulk string, not here che
+	}
+
+	value, err := s.opts.Codec.Unmarshal(data)
+	if err != nil {
+		s.reportError(fmt.Errorf("cache: decoding %q: %w", key, err))
+		s.misses.Add(1)
+		return nil, false
+	}
+	s.hits.Add(1)
+	return value, true
+}
+
+// Set encodes the value and stores it with a millisecond TTL
+// A ttl <= 0 stores nothing and deletes the key, same as the memory cache
+func (s *RedisStore) Set(key string, value interface{}, ttl time.Duration) {
+	if ttl <= 0 {
+		s.Delete(key)
+		return
+	}
+	data, err := s.opts.Codec.Marshal(value)
+	if err != nil {
+		s.reportError(fmt.Errorf("cache: encoding %q: %w", key, err))
+		return
+	}
+
+	// PX takes whole milliseconds and 0 is an error, so anything shorter gets one
+	px := max(ttl.Milliseconds(), 1)
+	if _, err := s.do("SET", key, string(data), "PX", strconv.FormatInt(px, 10)); err != nil {
+		s.reportError(err)
+	}
+}
+
+// Delete removes a key from the server
+func (s *RedisStore) Delete(key string) {
+	if _, err := s.do("DEL", key); err != nil {
+		s.reportError(err)
+	}
+}
+
+// Stats looks at the whole database, so give the cache a DB of its own
+// Expiries come from a pipelined PTTL over every key, fine for a cache this size
+func (s *RedisStore) Stats() Stats {
+	stats := Stats{
+		Hits:   s.hits.Load(),
+		Misses: s.misses.Load(),
+	}
+
+	keys, err := s.scan("")
+	if err != nil {
+		s.reportError(err)
+		return stats
+	}
+	if len(keys) == 0 {
+		return stats
+	}
+
+	cmds := make([][]string, len(keys))
+	for i, key := range keys {
+		cmds[i] = []string{"PTTL", key}
+	}
+	replies, err := s.pipeline(cmds)
+	if err != nil {
+		s.reportError(err)
+		return stats
+	}
+
+	now := time.Now()
+	for _, reply := range replies {
+		ms, ok := reply.(int64)
+		if !ok || ms == -2 {
+			continue // Expired between the SCAN and the PTTL
+		}
+		stats.Entries++
+		if ms < 0 {
+			continue // No TTL at all, nothing to compare
+		}
+		expiresAt := now.Add(time.Duration(ms) * time.Millisecond)
+		if stats.OldestExpiry.IsZero() || expiresAt.Before(stats.OldestExpiry) {
+			stats.OldestExpiry = expiresAt
+		}
+		if expiresAt.After(stats.NewestExpiry) {
+			stats.NewestExpiry = expiresAt
+		}
+	}
+	return stats
+}
+
+// Keys scans for keys starting with prefix, sorted like the memory cache
+func (s *RedisStore) Keys(prefix string) []string {
+	keys, err := s.scan(prefix)
+	if err != nil {
+		s.reportError(err)
+		return nil
+	}
+	return keys
+}
+
+// Flush deletes every key starting with prefix
+// We SCAN and DEL instead of FLUSHDB so a shared database doesn't lose other people's stuff
+func (s *RedisStore) Flush(prefix string) int {
+	keys, err := s.scan(prefix)
+	if err != nil {
+		s.reportError(err)
+		return 0
+	}
+
+	flushed := 0
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > 500 {
+			batch = batch[:500]
+		}
+		keys = keys[len(batch):]
+
+		reply, err := s.do(append([]string{"DEL"}, batch...)...)
+		if err != nil {
+			s.reportError(err)
+			return flushed
+		}
+		if n, ok := reply.(int64); ok {
+			flushed += int(n)
+		}
+	}
+	return flushed
+}
+
+// scan walks the keyspace with SCAN MATCH, never KEYS, so we don't block the server
+func (s *RedisStore) scan(prefix string) ([]string, error) {
+	match := escapeGlob(prefix) + "*"
+	cursor := "0"
+	var keys []string
+
+	for {
+		reply, err := s.do("SCAN", cursor, "MATCH", match, "COUNT", "500")
+		if err != nil {
+			return nil, err
+		}
+
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, fmt.Errorf("cache: unexpected SCAN reply %T", reply)
+		}
+		next, _ := parts[0].([]byte)
+		batch, _ := parts[1].([]interface{})
+		for _, k := range batch {
+			if b, ok := k.([]byte); ok {
+				keys = append(keys, string(b))
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" || cursor == "" {
+			break
+		}
+	}
+
+	// SCAN can hand back the same key twice, so sort and squash duplicates
+	sort.Strings(keys)
+	out := keys[:0]
+	for i, k := range keys {
+		if i == 0 || k != keys[i-1] {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+// escapeGlob makes sure a prefix like "a*b" is matched literally
+func escapeGlob(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Close hangs up every idle connection, the server keeps the data
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var firstErr error
+	for _, c := range s.idle {
+		if err := c.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.idle = nil
+	return firstErr
+}
+
+func (s *RedisStore) reportError(err error) {
+	if s.opts.OnError != nil {
+		s.opts.OnError(err)
+	}
+}
+
+// do runs a single command on a pooled connection
+// If anything goes wrong on the wire we throw the connection away, can't trust it anymore
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	c, err := s.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := c.do(s.opts.IOTimeout, args...)
+	if err != nil {
+		var replyErr RESPError
+		if errors.As(err, &replyErr) {
+			s.putConn(c) // The server said no, but the connection is fine
+		} else {
+			c.Close()
+		}
+		return nil, err
+	}
+
+	s.putConn(c)
+	return reply, nil
+}
+
+// pipeline sends every command before reading any reply, one round trip total
+// Error replies come back in place as RESPError values
+func (s *RedisStore) pipeline(cmds [][]string) ([]interface{}, error) {
+	c, err := s.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := c.pipeline(s.opts.IOTimeout, cmds)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	s.putConn(c)
+	return replies, nil
+}
+
+func (s *RedisStore) getConn() (*respConn, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, ErrStoreClosed
+	}
+	if n := len(s.idle); n > 0 {
+		c := s.idle[n-1]
+		s.idle = s.idle[:n-1]
+		s.mu.Unlock()
+		return c, nil
+	}
+	s.mu.Unlock()
+
+	return s.dial()
+}
+
+func (s *RedisStore) putConn(c *respConn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || len(s.idle) >= s.opts.PoolSize {
+		c.Close()
+		return
+	}
+	s.idle = append(s.idle, c)
+}
+
+func (s *RedisStore) dial() (*respConn, error) {
+	conn, err := net.DialTimeout("tcp", s.opts.Addr, s.opts.DialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &respConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+		w:    bufio.NewWriter(conn),
+	}
+
+	if s.opts.Password != "" {
+		if _, err := c.do(s.opts.IOTimeout, "AUTH", s.opts.Password); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("cache: auth: %w", err)
+		}
+	}
+	if s.opts.DB != 0 {
+		if _, err := c.do(s.opts.IOTimeout, "SELECT", strconv.Itoa(s.opts.DB)); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("cache: select db: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// RESPError is an error reply sent by the server, like "-ERR unknown command"
+type RESPError string
+
+func (e RESPError) Error() string {
+	return "cache: server error: " + string(e)
+}
+
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (c *respConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if err := WriteCommand(c.w, args...); err != nil {
+		return nil, err
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return ReadReply(c.r)
+}
+
+func (c *respConn) pipeline(timeout time.Duration, cmds [][]string) ([]interface{}, error) {
+	if err := c.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	for _, args := range cmds {
+		if err := WriteCommand(c.w, args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	replies := make([]interface{}, 0, len(cmds))
+	for range cmds {
+		reply, err := ReadReply(c.r)
+		if err != nil {
+			var replyErr RESPError
+			if !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			reply = replyErr
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// WriteCommand writes args as a RESP array of bulk strings
+// Exported so the resptest stand-in server can share the wire format
+func WriteCommand(w *bufio.Writer, args ...string) error {
+	fmt.Fprintf(w, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n", len(arg))
+		w.WriteString(arg)
+		if _, err := w.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadReply parses one RESP value
+// Simple strings come back as string, bulk strings as []byte (nil for the null bulk),
+// integers as int64, arrays as []interface{} and error replies as a RESPError
+func ReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("cache: empty reply line")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RESPError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("cache: bad bulk length %q", line)
+		}
+		if n < 0 {
+			return []byte(nil), nil
+		}
+		if n > maxBulkLen {
+			return nil, fmt.Errorf("cache: bulk length %d is over the %d limit", n, maxBulkLen)
+		}
+		buf := make([]byte, n+2) // payload plus the trailing \r\n
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("cache: bad array length %q", line)
+		}
+		if n < 0 {
+			return []interface{}(nil), nil
+		}
+		if n > maxArrayLen {
+			return nil, fmt.Errorf("cache: array length %d is over the %d limit", n, maxArrayLen)
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := ReadReply(r)
+			if err != nil {
+				var replyErr RESPError
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				item = replyErr
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("cache: unknown reply type %q", line[0])
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("cache: malformed line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
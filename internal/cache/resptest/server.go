@@ -0,0 +1,337 @@
+// Package resptest has a tiny in-process RESP server so the cache backend
+// can be exercised without a real Redis, re util for tests and local dev
+package resptest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"classifier.buhtigexa.net/internal/cache"
+)
+
+type entry struct {
+	value     []byte
+	expiresAt time.Time // zero means it never expires
+}
+
+// Server speaks just enough RESP for cache.RedisStore, nothing more
+type Server struct {
+	password string // when set, clients must AUTH first like the real thing
+
+	ln   net.Listener
+	mu   sync.Mutex
+	data map[string]entry
+	wg   sync.WaitGroup
+
+	connsMu sync.Mutex
+	conns   map[net.Conn]struct{}
+}
+
+// NewServer starts listening on a random local port
+func NewServer() (*Server, error) {
+	return NewAuthServer("")
+}
+
+// NewAuthServer is NewServer but clients have to AUTH with password first
+func NewAuthServer(password string) (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		password: password,
+		ln:       ln,
+		data:     make(map[string]entry),
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr is the host:port to hand to cache.RedisOptions
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Len tells how many live keys the server holds
+func (s *Server) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	now := time.Now()
+	for _, e := range s.data {
+		if !e.expired(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// Close stops accepting, drops every client and waits for the goroutines
+func (s *Server) Close() error {
+	err := s.ln.Close()
+
+	s.connsMu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.connsMu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.connsMu.Lock()
+		s.conns[conn] = struct{}{}
+		s.connsMu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.connsMu.Lock()
+		delete(s.conns, conn)
+		s.connsMu.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	authed := s.password == ""
+
+	for {
+		reply, err := cache.ReadReply(r)
+		if err != nil {
+			return
+		}
+
+		args, err := toArgs(reply)
+		if err != nil {
+			writeError(w, err.Error())
+		} else {
+			cmd := strings.ToUpper(args[0])
+			switch {
+			case cmd == "QUIT":
+				w.WriteString("+OK\r\n")
+				w.Flush()
+				return
+			case cmd == "AUTH":
+				if len(args) == 2 && args[1] == s.password {
+					authed = true
+					w.WriteString("+OK\r\n")
+				} else {
+					writeError(w, "WRONGPASS invalid password")
+				}
+			case !authed:
+				writeError(w, "NOAUTH Authentication required.")
+			default:
+				s.exec(w, cmd, args[1:])
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) exec(w *bufio.Writer, cmd string, args []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	switch cmd {
+	case "PING":
+		w.WriteString("+PONG\r\n")
+	case "SELECT":
+		w.WriteString("+OK\r\n") // One database is enough for a stand-in
+	case "GET":
+		if len(args) != 1 {
+			writeArity(w, cmd)
+			return
+		}
+		e, ok := s.data[args[0]]
+		if !ok || e.expired(now) {
+			delete(s.data, args[0])
+			w.WriteString("$-1\r\n")
+			return
+		}
+		writeBulk(w, e.value)
+	case "SET":
+		if len(args) < 2 {
+			writeArity(w, cmd)
+			return
+		}
+		e := entry{value: []byte(args[1])}
+		for i := 2; i < len(args); i += 2 {
+			if i+1 >= len(args) {
+				writeError(w, "ERR syntax error")
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || n <= 0 {
+				writeError(w, "ERR invalid expire time in 'set' command")
+				return
+			}
+			switch strings.ToUpper(args[i]) {
+			case "PX":
+				e.expiresAt = now.Add(time.Duration(n) * time.Millisecond)
+			case "EX":
+				e.expiresAt = now.Add(time.Duration(n) * time.Second)
+			default:
+				writeError(w, "ERR syntax error")
+				return
+			}
+		}
+		s.data[args[0]] = e
+		w.WriteString("+OK\r\n")
+	case "DEL":
+		deleted := 0
+		for _, key := range args {
+			if e, ok := s.data[key]; ok {
+				if !e.expired(now) {
+					deleted++
+				}
+				delete(s.data, key)
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", deleted)
+	case "PTTL":
+		if len(args) != 1 {
+			writeArity(w, cmd)
+			return
+		}
+		e, ok := s.data[args[0]]
+		switch {
+		case !ok || e.expired(now):
+			w.WriteString(":-2\r\n")
+		case e.expiresAt.IsZero():
+			w.WriteString(":-1\r\n")
+		default:
+			fmt.Fprintf(w, ":%d\r\n", e.expiresAt.Sub(now).Milliseconds())
+		}
+	case "DBSIZE":
+		n := 0
+		for _, e := range s.data {
+			if !e.expired(now) {
+				n++
+			}
+		}
+		fmt.Fprintf(w, ":%d\r\n", n)
+	case "SCAN":
+		// We hand back everything in one go with cursor 0, a real server pages
+		if len(args) < 1 {
+			writeArity(w, cmd)
+			return
+		}
+		pattern := "*"
+		for i := 1; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				pattern = args[i+1]
+			}
+		}
+		var keys []string
+		for key, e := range s.data {
+			if !e.expired(now) && matchGlob(pattern, key) {
+				keys = append(keys, key)
+			}
+		}
+		w.WriteString("*2\r\n")
+		writeBulk(w, []byte("0"))
+		fmt.Fprintf(w, "*%d\r\n", len(keys))
+		for _, key := range keys {
+			writeBulk(w, []byte(key))
+		}
+	case "FLUSHDB", "FLUSHALL":
+		s.data = make(map[string]entry)
+		w.WriteString("+OK\r\n")
+	default:
+		writeError(w, fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(cmd)))
+	}
+}
+
+func (e entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// matchGlob understands the bits of the Redis glob syntax the store uses: *, ? and \ escapes
+func matchGlob(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchGlob(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			pattern, s = pattern[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+func toArgs(reply interface{}) ([]string, error) {
+	items, ok := reply.([]interface{})
+	if !ok || len(items) == 0 {
+		return nil, errors.New("ERR expected a command array")
+	}
+
+	args := make([]string, 0, len(items))
+	for _, item := range items {
+		b, ok := item.([]byte)
+		if !ok {
+			return nil, errors.New("ERR expected bulk string arguments")
+		}
+		args = append(args, string(b))
+	}
+	return args, nil
+}
+
+func writeBulk(w io.Writer, b []byte) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(b), b)
+}
+
+func writeError(w *bufio.Writer, msg string) {
+	w.WriteString("-" + msg + "\r\n")
+}
+
+func writeArity(w *bufio.Writer, cmd string) {
+	writeError(w, fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd)))
+}
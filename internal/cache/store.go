@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"time"
+)
+
+// Store is everything the models need from a cache, viste?
+// The in-memory Cache is the default, but with more than one replica you
+// want something shared like the RESP backend so every pod sees the same data
+type Store interface {
+	Get(key string) (interface{}, bool)
+	// Set keeps the value for ttl. A ttl <= 0 stores nothing and drops what was
+	// under the key, there's no "forever" in a cache
+	Set(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+	Close() error
+
+	// Introspection for the admin endpoints
+	Stats() Stats
+	Keys(prefix string) []string
+	Flush(prefix string) int
+}
+
+// Codec turns cached values into bytes and back
+// Remote backends need this because they can't keep Go pointers, obvio
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+var (
+	_ Store = (*Cache)(nil)
+	_ Store = (*RedisStore)(nil)
+)
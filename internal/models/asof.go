@@ -0,0 +1,135 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// The audit trail has every version with its date, so it doubles as the temporal
+// storage: the classifier at a point in time is the last version written by then.
+// Versions are dated to the second, same as created_at, so everything written during
+// that second counts as already there
+
+// AsOfOptions pages through the catalog as it was at At
+type AsOfOptions struct {
+	At       time.Time
+	Page     int
+	PageSize int
+}
+
+// GetAsOf is the classifier as it was at asOf
+// ErrNoRecord if it didn't exist yet or had been deleted by then
+func (m *ClassifierModel) GetAsOf(ctx context.Context, id int64, asOf time.Time) (*Classifier, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Get)
+	defer cancel()
+
+	entry, err := scanAuditEntry(m.DB.QueryRowContext(ctx, m.queries.versionAt, id, asOf.UTC()))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, contextError(ctx, err)
+	}
+	if entry.After == nil {
+		return nil, ErrNoRecord
+	}
+	return entry.After.classifier(), nil
+}
+
+// ListAsOf is the whole catalog as it was at opts.At, paged like List
+// No cache here: a date in the past never changes, but one in the future does
+func (m *ClassifierModel) ListAsOf(ctx context.Context, opts AsOfOptions) ([]*Classifier, int, error) {
+	opts = opts.normalize()
+
+	ctx, cancel := withTimeout(ctx, m.timeouts.List)
+	defer cancel()
+
+	asOf := opts.At.UTC()
+	var total int
+	if err := m.DB.QueryRowContext(ctx, m.queries.countAsOf, asOf).Scan(&total); err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+
+	offset := (opts.Page - 1) * opts.PageSize
+	rows, err := m.DB.QueryContext(ctx, m.queries.listAsOf, asOf, opts.PageSize, offset)
+	if err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	classifiers := make([]*Classifier, 0, opts.PageSize)
+	for rows.Next() {
+		var data sql.NullString
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, contextError(ctx, err)
+		}
+		snapshot, err := parseSnapshot(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		classifiers = append(classifiers, snapshot.classifier())
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+	return classifiers, total, nil
+}
+
+// Revert puts the classifier back the way version left it, as a new "reverted" version
+// A deleted classifier comes back with its old id. Reverting to what's already there
+// changes nothing and records nothing, so running it twice is harmless
+func (m *ClassifierModel) Revert(ctx context.Context, id int64, version int) (*Classifier, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Update)
+	defer cancel()
+
+	var reverted *Classifier
+	changed := false
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		target, err := scanAuditEntry(tx.QueryRowContext(ctx, m.queries.getVersion, id, version))
+		if err != nil {
+			return err
+		}
+		if target.After == nil {
+			return ErrDeletedVersion
+		}
+		after := target.After.classifier()
+		reverted = after
+
+		before, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.getForUpdate, id))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			before = nil
+			_, err = tx.ExecContext(ctx, m.queries.restore, id, after.Name, after.Description, after.IsActive, after.CreatedAt)
+		case err != nil:
+			return err
+		case sameContent(before, after):
+			reverted = before
+			return nil
+		default:
+			_, err = tx.ExecContext(ctx, m.queries.update, after.Name, after.Description, after.IsActive, id)
+		}
+		if err != nil {
+			return err
+		}
+
+		changed = true
+		return m.writeAudit(ctx, tx, id, AuditReverted, before, after)
+	})
+	if err != nil {
+		return nil, m.mutationError(ctx, err)
+	}
+
+	if changed {
+		m.invalidate(ctx, PrefixInvalidation(listKeyPrefix), KeyInvalidation(classifierKey(id)))
+	}
+	return reverted, nil
+}
+
+// normalize uses the same paging defaults as the list
+func (opts AsOfOptions) normalize() AsOfOptions {
+	list := ListClassifiersOptions{Page: opts.Page, PageSize: opts.PageSize}.normalize()
+	opts.Page, opts.PageSize = list.Page, list.PageSize
+	return opts
+}
@@ -0,0 +1,391 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// AuditAction is what a mutation did to the classifier
+type AuditAction string
+
+const (
+	AuditCreated AuditAction = "created"
+	AuditUpdated AuditAction = "updated"
+	AuditDeleted AuditAction = "deleted"
+	// AuditReverted is a restore of an older version, after is that version's state
+	AuditReverted AuditAction = "reverted"
+)
+
+// ClassifierSnapshot is a classifier as the audit trail stores it
+// Plain pointers instead of sql.Null*, so the JSON reads like the request did
+type ClassifierSnapshot struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	IsActive    *bool     `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AuditEntry is one version of a classifier: who changed what, and how it looked
+// before and after. Before is nil for the create, After is nil for the delete
+type AuditEntry struct {
+	ID           int64               `json:"id"`
+	ClassifierID int64               `json:"classifier_id"`
+	Version      int                 `json:"version"`
+	Action       AuditAction         `json:"action"`
+	Actor        string              `json:"actor"`
+	RequestID    string              `json:"request_id,omitempty"`
+	Before       *ClassifierSnapshot `json:"before"`
+	After        *ClassifierSnapshot `json:"after"`
+	Changes      []FieldChange       `json:"changes"`
+	CreatedAt    time.Time           `json:"created_at"`
+}
+
+// FieldChange is one field that differs between two snapshots, nil means null or not there
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// HistoryOptions pages through a classifier's versions, newest first
+type HistoryOptions struct {
+	Page     int
+	PageSize int
+}
+
+// ClassifierPatch is a partial update, nil fields are left alone
+// An empty Description clears it (stored as NULL, same as on insert)
+type ClassifierPatch struct {
+	Name        *string
+	Description *string
+	IsActive    *bool
+}
+
+// normalize uses the same defaults as the list
+func (opts HistoryOptions) normalize() HistoryOptions {
+	list := ListClassifiersOptions{Page: opts.Page, PageSize: opts.PageSize}.normalize()
+	return HistoryOptions{Page: list.Page, PageSize: list.PageSize}
+}
+
+// apply returns c with the patch on top
+func (p ClassifierPatch) apply(c Classifier) Classifier {
+	if p.Name != nil {
+		c.Name = *p.Name
+	}
+	if p.Description != nil {
+		c.Description, _ = nullableFields(*p.Description, nil)
+	}
+	if p.IsActive != nil {
+		c.IsActive = sql.NullBool{Bool: *p.IsActive, Valid: true}
+	}
+	return c
+}
+
+// Snapshot is the classifier as the audit trail sees it
+func (c *Classifier) Snapshot() *ClassifierSnapshot {
+	if c == nil {
+		return nil
+	}
+	s := &ClassifierSnapshot{ID: c.ID, Name: c.Name, CreatedAt: c.CreatedAt.UTC()}
+	if c.Description.Valid {
+		description := c.Description.String
+		s.Description = &description
+	}
+	if c.IsActive.Valid {
+		isActive := c.IsActive.Bool
+		s.IsActive = &isActive
+	}
+	return s
+}
+
+// classifier turns the snapshot back into a row
+func (s *ClassifierSnapshot) classifier() *Classifier {
+	c := &Classifier{ID: s.ID, Name: s.Name, CreatedAt: s.CreatedAt}
+	if s.Description != nil {
+		c.Description = sql.NullString{String: *s.Description, Valid: true}
+	}
+	if s.IsActive != nil {
+		c.IsActive = sql.NullBool{Bool: *s.IsActive, Valid: true}
+	}
+	return c
+}
+
+// DiffSnapshots lists the fields that changed going from one version to the other
+// A nil snapshot is a classifier that doesn't exist (yet or anymore): every field is null
+func DiffSnapshots(from, to *ClassifierSnapshot) []FieldChange {
+	a, b := from.fields(), to.fields()
+	changes := []FieldChange{}
+	for i := range a {
+		if a[i].value != b[i].value {
+			changes = append(changes, FieldChange{Field: a[i].name, From: a[i].value, To: b[i].value})
+		}
+	}
+	return changes
+}
+
+type snapshotField struct {
+	name  string
+	value interface{}
+}
+
+// fields are the ones a mutation can change, id and created_at never do
+func (s *ClassifierSnapshot) fields() []snapshotField {
+	fields := []snapshotField{{name: "name"}, {name: "description"}, {name: "is_active"}}
+	if s == nil {
+		return fields
+	}
+	fields[0].value = s.Name
+	if s.Description != nil {
+		fields[1].value = *s.Description
+	}
+	if s.IsActive != nil {
+		fields[2].value = *s.IsActive
+	}
+	return fields
+}
+
+// newAuditEntry fills in what every backend computes the same way
+func newAuditEntry(ctx context.Context, id int64, version int, action AuditAction, before, after *Classifier) AuditEntry {
+	entry := AuditEntry{
+		ClassifierID: id,
+		Version:      version,
+		Action:       action,
+		Actor:        Actor(ctx),
+		RequestID:    RequestID(ctx),
+		Before:       before.Snapshot(),
+		After:        after.Snapshot(),
+	}
+	entry.Changes = DiffSnapshots(entry.Before, entry.After)
+	return entry
+}
+
+// sameContent is true when a mutation wouldn't change anything worth auditing
+func sameContent(a, b *Classifier) bool {
+	return len(DiffSnapshots(a.Snapshot(), b.Snapshot())) == 0
+}
+
+// Update applies the patch and records the change in the same transaction
+// A patch that changes nothing gives back the row as is and leaves no audit row
+func (m *ClassifierModel) Update(ctx context.Context, id int64, patch ClassifierPatch) (*Classifier, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Update)
+	defer cancel()
+
+	var updated *Classifier
+	changed := false
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		// FOR UPDATE so two patches on the same row take turns and get consecutive versions
+		before, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.getForUpdate, id))
+		if err != nil {
+			return err
+		}
+
+		after := patch.apply(*before)
+		updated = &after
+		if sameContent(before, &after) {
+			return nil
+		}
+
+		if _, err := tx.ExecContext(ctx, m.queries.update, after.Name, after.Description, after.IsActive, id); err != nil {
+			return err
+		}
+		changed = true
+		return m.writeAudit(ctx, tx, id, AuditUpdated, before, &after)
+	})
+	if err != nil {
+		return nil, m.mutationError(ctx, err)
+	}
+
+	if changed {
+		m.invalidate(ctx, PrefixInvalidation(listKeyPrefix), KeyInvalidation(classifierKey(id)))
+	}
+	return updated, nil
+}
+
+// Delete removes the classifier, its history stays (that's the whole point of it)
+func (m *ClassifierModel) Delete(ctx context.Context, id int64) error {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Delete)
+	defer cancel()
+
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.getForUpdate, id))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, m.queries.delete, id); err != nil {
+			return err
+		}
+		return m.writeAudit(ctx, tx, id, AuditDeleted, before, nil)
+	})
+	if err != nil {
+		return m.mutationError(ctx, err)
+	}
+
+	m.invalidate(ctx, PrefixInvalidation(listKeyPrefix), KeyInvalidation(classifierKey(id)))
+	return nil
+}
+
+// History pages through a classifier's versions, newest first
+// It reads from the primary and skips the cache: when you're chasing down what
+// happened, a replica a few seconds behind is the last thing you want
+func (m *ClassifierModel) History(ctx context.Context, id int64, opts HistoryOptions) ([]*AuditEntry, int, error) {
+	opts = opts.normalize()
+
+	ctx, cancel := withTimeout(ctx, m.timeouts.List)
+	defer cancel()
+
+	var total int
+	if err := m.DB.QueryRowContext(ctx, m.queries.countHistory, id).Scan(&total); err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+
+	offset := (opts.Page - 1) * opts.PageSize
+	rows, err := m.DB.QueryContext(ctx, m.queries.listHistory, id, opts.PageSize, offset)
+	if err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	entries := make([]*AuditEntry, 0, opts.PageSize)
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, 0, contextError(ctx, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+	return entries, total, nil
+}
+
+// GetVersion is one entry of the history, ErrNoRecord if the classifier never had it
+func (m *ClassifierModel) GetVersion(ctx context.Context, id int64, version int) (*AuditEntry, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Get)
+	defer cancel()
+
+	entry, err := scanAuditEntry(m.DB.QueryRowContext(ctx, m.queries.getVersion, id, version))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, contextError(ctx, err)
+	}
+	return entry, nil
+}
+
+// inTx runs fn in a transaction, committing only if it returns nil
+func (m *ClassifierModel) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeAudit adds the next version of the classifier to the audit trail and queues its
+// change event in the outbox. It runs inside the mutation's transaction: no change
+// without its rows and vice versa
+func (m *ClassifierModel) writeAudit(ctx context.Context, tx *sql.Tx, id int64, action AuditAction, before, after *Classifier) error {
+	var version int
+	if err := tx.QueryRowContext(ctx, m.queries.nextVersion, id).Scan(&version); err != nil {
+		return err
+	}
+
+	entry := newAuditEntry(ctx, id, version, action, before, after)
+	beforeJSON, err := snapshotJSON(entry.Before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := snapshotJSON(entry.After)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, m.queries.insertAudit,
+		id, version, string(action), entry.Actor, entry.RequestID, beforeJSON, afterJSON)
+	if err != nil {
+		return err
+	}
+	return m.writeOutbox(ctx, tx, entry)
+}
+
+// mutationError maps what a write transaction can fail with to the package errors
+func (m *ClassifierModel) mutationError(ctx context.Context, err error) error {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNoRecord
+	case m.queries.isDuplicate(err):
+		return ErrDuplicateName
+	default:
+		return contextError(ctx, err)
+	}
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanClassifier reads a row of selectColumns into a fresh classifier
+// No pool here: these end up in the audit trail and in the response, not in the cache
+func scanClassifier(row rowScanner) (*Classifier, error) {
+	c := &Classifier{}
+	if err := row.Scan(&c.ID, &c.Name, &c.Description, &c.IsActive, &c.CreatedAt); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func scanAuditEntry(row rowScanner) (*AuditEntry, error) {
+	var (
+		entry                 AuditEntry
+		action                string
+		beforeJSON, afterJSON sql.NullString
+	)
+	err := row.Scan(&entry.ID, &entry.ClassifierID, &entry.Version, &action, &entry.Actor,
+		&entry.RequestID, &beforeJSON, &afterJSON, &entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	entry.Action = AuditAction(action)
+
+	if entry.Before, err = parseSnapshot(beforeJSON); err != nil {
+		return nil, err
+	}
+	if entry.After, err = parseSnapshot(afterJSON); err != nil {
+		return nil, err
+	}
+	entry.Changes = DiffSnapshots(entry.Before, entry.After)
+	return &entry, nil
+}
+
+// snapshotJSON is NULL for a missing snapshot, JSON text otherwise
+func snapshotJSON(s *ClassifierSnapshot) (sql.NullString, error) {
+	if s == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func parseSnapshot(data sql.NullString) (*ClassifierSnapshot, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	var s ClassifierSnapshot
+	if err := json.Unmarshal([]byte(data.String), &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
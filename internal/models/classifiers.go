@@ -1,9 +1,11 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	"classifier.buhtigexa.net/internal/cache"
@@ -21,38 +23,116 @@ type Classifier struct {
 }
 
 type ClassifierModel struct {
-	DB        *sql.DB
-	cache     *cache.Cache
-	countStmt *sql.Stmt
-	listStmt  *sql.Stmt
+	DB              *sql.DB
+	queries         dialectQueries
+	cache           cache.Store
+	bus             InvalidationBus
+	unsubscribe     func()
+	replicas        *ReplicaSet
+	readsMu         sync.Mutex
+	reads           map[*sql.DB]*readStatements // one set per pool, a Stmt is tied to its DB
+	timeouts        QueryTimeouts
 }
 
-func NewClassifierModel(db *sql.DB) (*ClassifierModel, error) {
-	countStmt, err := db.Prepare("SELECT COUNT(*) FROM classifiers")
+// readStatements are the list queries, prepared on the primary and on every replica
+type readStatements struct {
+	count       *sql.Stmt
+	list        *sql.Stmt
+	countSearch *sql.Stmt
+	listSearch  *sql.Stmt
+}
+
+// ClassifierModelOptions has the optional pieces of the model
+// Leave Cache nil and you get the good old in-memory cache, no drama
+// Leave Invalidations nil and writes only evict from this instance's cache
+// Leave Dialect empty and you're talking MySQL like always
+// Leave Timeouts zero and only the caller's context limits the queries
+// Leave Replicas nil and every read goes to db
+type ClassifierModelOptions struct {
+	Cache         cache.Store
+	Invalidations InvalidationBus
+	Dialect       Dialect
+	Timeouts      QueryTimeouts
+	Replicas      *ReplicaSet
+}
+
+// QueryTimeouts caps how long each operation can keep the database busy, on top of
+// whatever deadline the caller's context already has. Zero means no cap of our own
+// When one runs out the error wraps context.DeadlineExceeded
+type QueryTimeouts struct {
+	Get    time.Duration
+	List   time.Duration
+	Insert time.Duration
+	Upsert time.Duration
+	Update time.Duration
+	Delete time.Duration
+	Export time.Duration // the whole export, not each row
+	Import time.Duration // a whole batch, dry run or not
+}
+
+// NewClassifierModel wires the model to the database, the cache and the invalidation bus
+func NewClassifierModel(db *sql.DB, opts ClassifierModelOptions) (*ClassifierModel, error) {
+	queries, err := opts.Dialect.queries()
 	if err != nil {
 		return nil, err
 	}
 
-	listStmt, err := db.Prepare(`
-		SELECT id, name, description, is_active, created_at 
-		FROM classifiers 
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?`)
-	if err != nil {
-		countStmt.Close()
-		return nil, err
+	if opts.Replicas == nil {
+		opts.Replicas = NewReplicaSet(db, nil, ReplicaOptions{})
+	}
+
+	m := &ClassifierModel{
+		DB:       db,
+		queries:  queries,
+		replicas: opts.Replicas,
+		reads:    make(map[*sql.DB]*readStatements),
+		timeouts: opts.Timeouts,
+	}
+
+	// Preparamos todo de una, si algo falla cerramos lo que ya estaba abierto
+	// A replica that's down right now is fine: database/sql prepares lazily on
+	// each connection, so the statements work once it comes back
+	pools := []*sql.DB{db}
+	for _, r := range opts.Replicas.replicas {
+		pools = append(pools, r.DB)
+	}
+	for _, pool := range pools {
+		stmts, err := prepareReads(pool, queries)
+		if err != nil && pool == db {
+			m.CloseStatements()
+			return nil, err
+		}
+		if err != nil {
+			opts.Replicas.ReportFailure(pool, err)
+			continue
+		}
+		m.reads[pool] = stmts
+	}
+
+	if opts.Cache == nil {
+		opts.Cache = cache.New()
 	}
+	m.cache = opts.Cache
+	m.bus = opts.Invalidations
 
-	return &ClassifierModel{
-		DB:        db,
-		cache:     cache.New(),
-		countStmt: countStmt,
-		listStmt:  listStmt,
-	}, nil
+	// When another replica writes, we drop our copy of whatever it touched
+	if m.bus != nil {
+		m.unsubscribe = m.bus.Subscribe(func(invs []Invalidation) {
+			ApplyInvalidations(m.cache, invs)
+			// Someone wrote on another pod, our replicas may not have it yet
+			m.replicas.MarkWrite(context.Background())
+		})
+	}
+	return m, nil
 }
 
 // Close releases all resources (cache and prepared statements)
 func (m *ClassifierModel) Close() error {
+	// Dejamos de escuchar invalidaciones, el bus lo cierra quien lo creo
+	if m.unsubscribe != nil {
+		m.unsubscribe()
+	}
+
 	// Primero cerramos la caché y su goroutine
 	if err := m.cache.Close(); err != nil {
 		return fmt.Errorf("error closing cache: %w", err)
@@ -64,93 +144,271 @@ func (m *ClassifierModel) Close() error {
 
 // CloseStatements releases the prepared statements
 func (m *ClassifierModel) CloseStatements() error {
-	if err := m.countStmt.Close(); err != nil {
-		return fmt.Errorf("error closing count statement: %w", err)
+	for _, stmts := range m.reads {
+		if err := stmts.close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepareReads prepares the list queries on one pool
+func prepareReads(db *sql.DB, queries dialectQueries) (*readStatements, error) {
+	stmts := &readStatements{}
+	for _, s := range []struct {
+		dst   **sql.Stmt
+		query string
+	}{
+		{&stmts.count, queries.count},
+		{&stmts.list, queries.list},
+		{&stmts.countSearch, queries.countSearch},
+		{&stmts.listSearch, queries.listSearch},
+	} {
+		stmt, err := db.Prepare(s.query)
+		if err != nil {
+			stmts.close()
+			return nil, err
+		}
+		*s.dst = stmt
 	}
-	if err := m.listStmt.Close(); err != nil {
-		return fmt.Errorf("error closing list statement: %w", err)
+	return stmts, nil
+}
+
+func (s *readStatements) close() error {
+	for _, stmt := range []*sql.Stmt{s.count, s.list, s.countSearch, s.listSearch} {
+		if stmt == nil {
+			continue
+		}
+		if err := stmt.Close(); err != nil {
+			return fmt.Errorf("error closing statement: %w", err)
+		}
 	}
 	return nil
 }
 
-func (m *ClassifierModel) Insert(name string, description string, isActive *bool) (int64, error) {
-	// Re piola query para insertar un classifier con los campos nuevos
-	query := `INSERT INTO classifiers (name, description, is_active) VALUES (?, ?, ?)`
-	
+func (m *ClassifierModel) Insert(ctx context.Context, name string, description string, isActive *bool) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Insert)
+	defer cancel()
+
 	// Manejamos los campos nullables con mucho cuidado, viste
+	descriptionSQL, isActiveSQL := nullableFields(description, isActive)
+
+	// The row and its audit entry go in together or not at all
+	var id int64
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		id, err = m.queries.insertReturningID(ctx, tx, m.queries.insert, name, descriptionSQL, isActiveSQL)
+		if err != nil {
+			return err
+		}
+		// Read it back for the snapshot, created_at is the database's
+		after, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.get, id))
+		if err != nil {
+			return err
+		}
+		return m.writeAudit(ctx, tx, id, AuditCreated, nil, after)
+	})
+	if err != nil {
+		// Uh, something went wrong with the DB, que quilombo!
+		return 0, m.mutationError(ctx, err)
+	}
+
+	// Tenemos que invalidar el cache porque hay data nueva
+	// Si no hacemos esto, everything gets desynchronized viste
+	m.invalidate(ctx, PrefixInvalidation(listKeyPrefix), KeyInvalidation(classifierKey(id)))
+	return id, nil
+}
+
+// Upsert inserts the classifier or, if the name is taken, overwrites its description
+// and is_active. Either way you get the id of the row that ended up with that name
+// The audit trail gets a "created" or an "updated", or nothing when the values were the same
+func (m *ClassifierModel) Upsert(ctx context.Context, name string, description string, isActive *bool) (int64, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Upsert)
+	defer cancel()
+
+	descriptionSQL, isActiveSQL := nullableFields(description, isActive)
+
+	var id int64
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.getByNameForUpdate, name))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		id, err = m.queries.insertReturningID(ctx, tx, m.queries.upsert, name, descriptionSQL, isActiveSQL)
+		if err != nil {
+			return err
+		}
+		after, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.get, id))
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case before == nil:
+			return m.writeAudit(ctx, tx, id, AuditCreated, nil, after)
+		case sameContent(before, after):
+			return nil
+		default:
+			return m.writeAudit(ctx, tx, id, AuditUpdated, before, after)
+		}
+	})
+	if err != nil {
+		return 0, m.mutationError(ctx, err)
+	}
+
+	m.invalidate(ctx, PrefixInvalidation(listKeyPrefix), KeyInvalidation(classifierKey(id)))
+	return id, nil
+}
+
+// execQuerier is what insertReturningID needs, a *sql.DB or a *sql.Tx
+type execQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// insertReturningID runs an insert and gets the id back, with RETURNING where the
+// dialect has it and LastInsertId where it doesn't (Postgres has no LastInsertId at all)
+func (q dialectQueries) insertReturningID(ctx context.Context, db execQuerier, query string, args ...interface{}) (int64, error) {
+	if q.returningID {
+		var id int64
+		err := db.QueryRowContext(ctx, query, args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	// Che, if we can't get the ID that's alto bardo, the caller gets the error
+	return result.LastInsertId()
+}
+
+// nullableFields maps the optional inputs to NULL-able columns:
+// empty description and nil is_active are stored as NULL
+func nullableFields(description string, isActive *bool) (sql.NullString, sql.NullBool) {
 	var descriptionSQL sql.NullString
 	if description != "" {
 		descriptionSQL = sql.NullString{String: description, Valid: true}
 	}
-	
+
 	var isActiveSQL sql.NullBool
 	if isActive != nil {
 		isActiveSQL = sql.NullBool{Bool: *isActive, Valid: true}
 	}
-	
-	result, err := m.DB.Exec(query, name, descriptionSQL, isActiveSQL)
-	if err != nil {
-		// Uh, something went wrong with the DB, que quilombo!
-		return 0, err
-	}
-	
-	id, err := result.LastInsertId()
-	if err != nil {
-		// Che, couldn't get the ID, alto bardo!
-		return 0, err
+	return descriptionSQL, isActiveSQL
+}
+
+// invalidate evicts keys locally and tells the other replicas to do the same
+// Every mutation has to go through here, si no cada pod ve otra cosa
+// It also starts the read-your-writes window for the client that wrote
+func (m *ClassifierModel) invalidate(ctx context.Context, invs ...Invalidation) {
+	m.replicas.MarkWrite(ctx)
+	ApplyInvalidations(m.cache, invs)
+	if m.bus != nil {
+		m.bus.Publish(invs...)
 	}
+}
 
-	// Tenemos que invalidar el cache porque hay data nueva
-	// Si no hacemos esto, everything gets desynchronized viste
-	m.cache.Delete("classifiers:list")
-	return id, nil
+// Every list page lives under this prefix, one key per page and page size
+const listKeyPrefix = "classifiers:list:"
+
+func classifierKey(id int64) string {
+	return fmt.Sprintf("classifier:%d", id)
 }
 
-func (m *ClassifierModel) Get(id int64) (*Classifier, error) {
+func (m *ClassifierModel) Get(ctx context.Context, id int64) (*Classifier, error) {
 	// Try to get from cache first
-	cacheKey := fmt.Sprintf("classifier:%d", id)
+	cacheKey := classifierKey(id)
 	if cached, ok := m.cache.Get(cacheKey); ok {
 		return cached.(*Classifier), nil
 	}
 
-	query := `SELECT id, name, description, is_active, created_at FROM classifiers WHERE id = ?`
+	ctx, cancel := withTimeout(ctx, m.timeouts.Get)
+	defer cancel()
+
 	c := getClassifier() // Get from pool
-	err := m.DB.QueryRow(query, id).Scan(&c.ID, &c.Name, &c.Description, &c.IsActive, &c.CreatedAt)
+	scan := func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, m.queries.get, id).Scan(&c.ID, &c.Name, &c.Description, &c.IsActive, &c.CreatedAt)
+	}
+
+	db, fromReplica := m.replicas.Reader(ctx)
+	err := scan(db)
+	if fromReplica && m.fallBack(ctx, db, err) {
+		db, fromReplica = m.DB, false
+		err = scan(db)
+	}
 	if err != nil {
 		putClassifier(c) // Return to pool on error
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
 		}
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
 
 	// Cache the result for 5 minutes
-	m.cache.Set(cacheKey, c, 5*time.Minute)
+	m.cacheRead(fromReplica, cacheKey, c, 5*time.Minute)
 	return c, nil
 }
 
 type ListClassifiersOptions struct {
 	Page     int
 	PageSize int
+	Search   string // matches name or description, empty means no filter
 }
 
-func (m *ClassifierModel) List(opts ListClassifiersOptions) ([]*Classifier, int, error) {
-	if opts.Page < 1 {
-		opts.Page = 1
-	}
-	if opts.PageSize < 1 || opts.PageSize > 100 {
-		opts.PageSize = 20
-	}
+// listPage is what we cache per page, the total goes along so a hit doesn't lose it
+type listPage struct {
+	Classifiers []*Classifier
+	Total       int
+}
+
+func (m *ClassifierModel) List(ctx context.Context, opts ListClassifiersOptions) ([]*Classifier, int, error) {
+	opts = opts.normalize()
 
 	// Try to get from cache first
-	cacheKey := makeCacheKey("classifiers", "list", strconv.Itoa(opts.Page), strconv.Itoa(opts.PageSize))
+	cacheKey := makeCacheKey("classifiers", "list", strconv.Itoa(opts.Page), strconv.Itoa(opts.PageSize), opts.Search)
 	if cached, ok := m.cache.Get(cacheKey); ok {
-		return cached.([]*Classifier), 0, nil
+		page := cached.(listPage)
+		return page.Classifiers, page.Total, nil
+	}
+
+	ctx, cancel := withTimeout(ctx, m.timeouts.List)
+	defer cancel()
+
+	db, fromReplica := m.replicas.Reader(ctx)
+	classifiers, total, err := m.listFrom(ctx, db, opts)
+	if fromReplica && m.fallBack(ctx, db, err) {
+		fromReplica = false
+		classifiers, total, err = m.listFrom(ctx, m.DB, opts)
+	}
+	if err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+
+	// Cache the result for 1 minute since this data changes more frequently
+	m.cacheRead(fromReplica, cacheKey, listPage{Classifiers: classifiers, Total: total}, 1*time.Minute)
+	return classifiers, total, nil
+}
+
+// listFrom runs the count and the page query on one pool
+func (m *ClassifierModel) listFrom(ctx context.Context, db *sql.DB, opts ListClassifiersOptions) ([]*Classifier, int, error) {
+	stmts, err := m.readsFor(db)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// With a search we use the filtered statements, same argument order in every dialect
+	countStmt, listStmt := stmts.count, stmts.list
+	var filter []interface{}
+	if opts.Search != "" {
+		countStmt, listStmt = stmts.countSearch, stmts.listSearch
+		filter = m.queries.searchArgs(opts.Search)
 	}
 
 	// Get total count using a prepared statement
 	var total int
-	if err := m.countStmt.QueryRow().Scan(&total); err != nil {
+	if err := countStmt.QueryRowContext(ctx, filter...).Scan(&total); err != nil {
 		return nil, 0, err
 	}
 
@@ -158,7 +416,7 @@ func (m *ClassifierModel) List(opts ListClassifiersOptions) ([]*Classifier, int,
 	offset := (opts.Page - 1) * opts.PageSize
 
 	// Use prepared statement and preallocate slice with exact capacity
-	rows, err := m.listStmt.Query(opts.PageSize, offset)
+	rows, err := listStmt.QueryContext(ctx, append(filter, opts.PageSize, offset)...)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -170,7 +428,7 @@ func (m *ClassifierModel) List(opts ListClassifiersOptions) ([]*Classifier, int,
 	// Reuse classifier objects from pool
 	for rows.Next() {
 		c := getClassifier()
-		if err := rows.Scan(&c.ID, &c.Name, &c.CreatedAt); err != nil {
+		if err := rows.Scan(&c.ID, &c.Name, &c.Description, &c.IsActive, &c.CreatedAt); err != nil {
 			// Return objects to pool on error
 			for _, cls := range classifiers {
 				putClassifier(cls)
@@ -188,8 +446,60 @@ func (m *ClassifierModel) List(opts ListClassifiersOptions) ([]*Classifier, int,
 		}
 		return nil, 0, err
 	}
-
-	// Cache the result for 1 minute since this data changes more frequently
-	m.cache.Set(cacheKey, classifiers, 1*time.Minute)
 	return classifiers, total, nil
 }
+
+// readsFor hands back the statements for a pool, preparing them the first time
+// for a replica that was down when the model started
+func (m *ClassifierModel) readsFor(db *sql.DB) (*readStatements, error) {
+	m.readsMu.Lock()
+	defer m.readsMu.Unlock()
+
+	if stmts, ok := m.reads[db]; ok {
+		return stmts, nil
+	}
+	stmts, err := prepareReads(db, m.queries)
+	if err != nil {
+		return nil, err
+	}
+	m.reads[db] = stmts
+	return stmts, nil
+}
+
+// fallBack decides whether a read that failed on a replica gets another go on the
+// primary: yes for anything but "not there" and the context running out
+func (m *ClassifierModel) fallBack(ctx context.Context, replica *sql.DB, err error) bool {
+	if err == nil || errors.Is(err, sql.ErrNoRows) || ctx.Err() != nil {
+		return false
+	}
+	m.replicas.ReportFailure(replica, err)
+	return true
+}
+
+// cacheRead caches what we read, unless it came from a replica right after a write:
+// the replica may be behind and we'd be serving the old row for the whole TTL
+func (m *ClassifierModel) cacheRead(fromReplica bool, key string, value interface{}, ttl time.Duration) {
+	if fromReplica && m.replicas.RecentWrite() {
+		return
+	}
+	m.cache.Set(key, value, ttl)
+}
+
+// withTimeout applies the per-operation cap, if there is one
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// contextError makes sure a query killed by its context says so
+// Not every driver wraps ctx.Err() (pgx and mysql sometimes just say "bad connection"),
+// and the handlers need errors.Is(err, context.DeadlineExceeded) to pick the status
+func contextError(ctx context.Context, err error) error {
+	ctxErr := ctx.Err()
+	if ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ctxErr, err)
+}
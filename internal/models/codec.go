@@ -0,0 +1,56 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ClassifierCodec serializes whatever ClassifierModel puts in the cache
+// so remote cache backends can store it, plain JSON so you can read it from redis-cli
+type ClassifierCodec struct{}
+
+// cachedValue wraps the value with a kind so we know what to give back, viste
+type cachedValue struct {
+	Kind        string        `json:"kind"`
+	Classifier  *Classifier   `json:"classifier,omitempty"`
+	Classifiers []*Classifier `json:"classifiers,omitempty"`
+	Total       int           `json:"total,omitempty"`
+}
+
+const (
+	cachedKindClassifier = "classifier"
+	cachedKindList       = "list"
+)
+
+func (ClassifierCodec) Marshal(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case *Classifier:
+		return json.Marshal(cachedValue{Kind: cachedKindClassifier, Classifier: v})
+	case listPage:
+		return json.Marshal(cachedValue{Kind: cachedKindList, Classifiers: v.Classifiers, Total: v.Total})
+	default:
+		return nil, fmt.Errorf("models: can't encode %T for the cache", value)
+	}
+}
+
+func (ClassifierCodec) Unmarshal(data []byte) (interface{}, error) {
+	var cv cachedValue
+	if err := json.Unmarshal(data, &cv); err != nil {
+		return nil, err
+	}
+
+	switch cv.Kind {
+	case cachedKindClassifier:
+		if cv.Classifier == nil {
+			return nil, fmt.Errorf("models: cached classifier is empty")
+		}
+		return cv.Classifier, nil
+	case cachedKindList:
+		if cv.Classifiers == nil {
+			cv.Classifiers = []*Classifier{} // An empty page is still a page
+		}
+		return listPage{Classifiers: cv.Classifiers, Total: cv.Total}, nil
+	default:
+		return nil, fmt.Errorf("models: unknown cached kind %q", cv.Kind)
+	}
+}
@@ -0,0 +1,52 @@
+package models
+
+import "context"
+
+type contextKey string
+
+const clientIDKey = contextKey("client_id")
+
+// WithClientID tags ctx with who is calling, so reads after their own writes
+// go to the primary (see ReplicaSet)
+func WithClientID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, clientIDKey, id)
+}
+
+// ClientID is the id set by WithClientID, empty when there's none
+func ClientID(ctx context.Context) string {
+	id, _ := ctx.Value(clientIDKey).(string)
+	return id
+}
+
+const (
+	actorKey     = contextKey("actor")
+	requestIDKey = contextKey("request_id")
+)
+
+// WithActor tags ctx with who is making the change, it ends up in the audit trail
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey, actor)
+}
+
+// Actor is who the audit trail blames for a change: the one set by WithActor,
+// the client id if there's none, and "system" for the app's own writes
+func Actor(ctx context.Context) string {
+	if actor, _ := ctx.Value(actorKey).(string); actor != "" {
+		return actor
+	}
+	if id := ClientID(ctx); id != "" {
+		return id
+	}
+	return "system"
+}
+
+// WithRequestID tags ctx with the request's id, so an audit row can be matched to the logs
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID is the id set by WithRequestID, empty when there's none
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
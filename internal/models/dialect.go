@@ -0,0 +1,447 @@
+package models
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Dialect says which SQL flavour ClassifierModel speaks
+// The zero value is MySQL, so nothing changes for the existing deployments
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DriverName is the database/sql driver registered for the dialect
+func (d Dialect) DriverName() string {
+	switch d {
+	case SQLite:
+		return "sqlite3"
+	case Postgres:
+		return "pgx"
+	default:
+		return "mysql"
+	}
+}
+
+func (d Dialect) queries() (dialectQueries, error) {
+	switch d {
+	case "", MySQL:
+		return mysqlQueries, nil
+	case SQLite:
+		return sqliteQueries, nil
+	case Postgres:
+		return postgresQueries, nil
+	default:
+		return dialectQueries{}, fmt.Errorf("models: unknown dialect %q", d)
+	}
+}
+
+// dialectQueries is every statement the model runs, one set per flavour
+// The arguments always go in the same order, only the SQL around them changes
+type dialectQueries struct {
+	insert      string // name, description, is_active
+	get         string // id
+	getByName   string // name, no lock, for the import's dry run
+	count       string
+	countSearch string // searchArgs
+	list        string // limit, offset
+	listSearch  string // searchArgs, limit, offset
+	upsert      string // name, description, is_active
+
+	// The whole list in one go, streamed by the export
+	export       string
+	exportSearch string // searchArgs
+	exportAsOf   string // as_of
+
+	// The audited mutations, all inside a transaction
+	getForUpdate       string // id, locks the row where the dialect can
+	getByNameForUpdate string // name
+	update             string // name, description, is_active, id
+	delete             string // id
+	nextVersion        string // classifier_id
+	insertAudit        string // classifier_id, version, action, actor, request_id, before_json, after_json
+	countHistory       string // classifier_id
+	listHistory        string // classifier_id, limit, offset
+	getVersion         string // classifier_id, version
+	versionAt          string // classifier_id, as_of
+	countAsOf          string // as_of
+	listAsOf           string // as_of, limit, offset
+	restore            string // id, name, description, is_active, created_at
+
+	// The outbox and its dispatcher
+	insertOutbox   string // classifier_id, event_type, payload
+	pendingOutbox  string // now, limit
+	markDispatched string // dispatched_at, id
+	markFailed     string // next_attempt_at, last_error, id
+	pruneOutbox    string // dispatched before
+	pruneOldOutbox string // created before, dispatched or not
+	latestOutbox   string // the last id, 0 when empty
+	tailOutbox     string // after id, limit
+	tryLockQuery   string // name, true when we got it; empty where the database is single writer
+	unlockQuery    string // name
+
+	// Webhook subscriptions and their deliveries
+	insertWebhook      string // url, secret, event_types
+	getWebhook         string // id
+	listWebhooks       string
+	deleteWebhook      string // id
+	deleteDeliveries   string // webhook_id
+	insertDelivery     string // webhook_id, event_id, event_type, payload, next_attempt_at; a repeat is ignored
+	dueDeliveries      string // now, limit
+	markDelivered      string // last_status, delivered_at, id
+	markDeliveryFailed string // status, next_attempt_at, last_status, last_error, id
+	countDeliveries    string // webhook_id, status, status
+	listDeliveries     string // webhook_id, status, status, limit, offset
+	retryDelivery      string // next_attempt_at, id, webhook_id
+	pruneDeliveries    string // delivered before
+
+	// returningID means insert and upsert end in RETURNING id, otherwise we use LastInsertId
+	returningID bool
+
+	searchArgs  func(term string) []interface{}
+	isDuplicate func(err error) bool
+	isRetryable func(err error) bool // transient: trying again a bit later can work
+}
+
+const selectColumns = `SELECT id, name, description, is_active, created_at FROM classifiers`
+
+// Newest first, with the id breaking ties: DATETIME only has second resolution
+const listOrder = ` ORDER BY created_at DESC, id DESC`
+
+// The audit statements are the same everywhere but for the placeholders (see numbered)
+// and FOR UPDATE, which SQLite doesn't have: there the transaction locks the whole file
+const (
+	updateQuery      = `UPDATE classifiers SET name = ?, description = ?, is_active = ? WHERE id = ?`
+	deleteQuery      = `DELETE FROM classifiers WHERE id = ?`
+	nextVersionQuery = `SELECT COALESCE(MAX(version), 0) + 1 FROM classifier_audit WHERE classifier_id = ?`
+	insertAuditQuery = `INSERT INTO classifier_audit
+		(classifier_id, version, action, actor, request_id, before_json, after_json)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`
+	countHistoryQuery = `SELECT COUNT(*) FROM classifier_audit WHERE classifier_id = ?`
+	auditColumns      = `SELECT id, classifier_id, version, action, actor, request_id, before_json, after_json, created_at
+		FROM classifier_audit`
+	listHistoryQuery = auditColumns + ` WHERE classifier_id = ? ORDER BY version DESC LIMIT ? OFFSET ?`
+	getVersionQuery  = auditColumns + ` WHERE classifier_id = ? AND version = ?`
+	versionAtQuery   = auditColumns + ` WHERE classifier_id = ? AND created_at <= ? ORDER BY version DESC LIMIT 1`
+
+	// The catalog at a point in time: each classifier's last version by then, unless it
+	// was a delete. Sorted by when the classifier was created, like the list
+	asOfFrom = ` FROM classifier_audit a
+		JOIN (SELECT classifier_id, MAX(version) AS version FROM classifier_audit
+			WHERE created_at <= ? GROUP BY classifier_id) latest
+			ON latest.classifier_id = a.classifier_id AND latest.version = a.version
+		JOIN classifier_audit origin ON origin.classifier_id = a.classifier_id AND origin.version = 1
+		WHERE a.after_json IS NOT NULL`
+	countAsOfQuery  = `SELECT COUNT(*)` + asOfFrom
+	exportAsOfQuery = `SELECT a.after_json` + asOfFrom + ` ORDER BY origin.created_at DESC, a.classifier_id DESC`
+	listAsOfQuery   = exportAsOfQuery + ` LIMIT ? OFFSET ?`
+
+	insertOutboxQuery = `INSERT INTO outbox (classifier_id, event_type, payload) VALUES (?, ?, ?)`
+	// A classifier waiting on a retry sits out with everything after it, so its order
+	// holds and its rows don't take the places of the ones that can go now
+	pendingOutboxQuery = `SELECT id, classifier_id, payload, attempts, next_attempt_at FROM outbox o
+		WHERE dispatched_at IS NULL AND NOT EXISTS (
+			SELECT 1 FROM outbox w
+			WHERE w.classifier_id = o.classifier_id AND w.dispatched_at IS NULL
+				AND w.next_attempt_at > ? AND w.id <= o.id
+		)
+		ORDER BY id LIMIT ?`
+	markDispatchedQuery = `UPDATE outbox SET dispatched_at = ?, last_error = NULL WHERE id = ?`
+	markFailedQuery     = `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = ?, last_error = ? WHERE id = ?`
+	pruneOutboxQuery    = `DELETE FROM outbox WHERE dispatched_at < ?`
+	pruneOldOutboxQuery = `DELETE FROM outbox WHERE created_at < ?`
+	latestOutboxQuery   = `SELECT COALESCE(MAX(id), 0) FROM outbox`
+	tailOutboxQuery     = `SELECT id, payload FROM outbox WHERE id > ? ORDER BY id LIMIT ?`
+
+	webhookColumns     = `SELECT id, url, event_types, created_at FROM webhooks`
+	insertWebhookQuery = `INSERT INTO webhooks (url, secret, event_types) VALUES (?, ?, ?)`
+	getWebhookQuery    = webhookColumns + ` WHERE id = ?`
+	listWebhooksQuery  = webhookColumns + ` ORDER BY id`
+	deleteWebhookQuery = `DELETE FROM webhooks WHERE id = ?`
+	// deleteDeliveriesQuery goes with deleteWebhookQuery, there's no foreign key to cascade
+	deleteDeliveriesQuery = `DELETE FROM webhook_deliveries WHERE webhook_id = ?`
+	insertDeliveryQuery   = `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, next_attempt_at)
+		VALUES (?, ?, ?, ?, ?)`
+	dueDeliveriesQuery = `SELECT d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.attempts, w.url, w.secret
+		FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = 'pending' AND d.next_attempt_at <= ? ORDER BY d.id LIMIT ?`
+	markDeliveredQuery = `UPDATE webhook_deliveries SET status = 'delivered', attempts = attempts + 1,
+		last_status = ?, last_error = NULL, delivered_at = ? WHERE id = ?`
+	markDeliveryFailedQuery = `UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1,
+		next_attempt_at = ?, last_status = ?, last_error = ? WHERE id = ?`
+	// An empty status matches every delivery
+	deliveriesWhere      = ` FROM webhook_deliveries WHERE webhook_id = ? AND (? = '' OR status = ?)`
+	countDeliveriesQuery = `SELECT COUNT(*)` + deliveriesWhere
+	listDeliveriesQuery  = `SELECT id, webhook_id, event_id, event_type, status, attempts, next_attempt_at,
+		last_status, last_error, created_at, delivered_at` + deliveriesWhere + ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	retryDeliveryQuery = `UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = ?
+		WHERE id = ? AND webhook_id = ? AND status = 'dead'`
+	pruneDeliveriesQuery = `DELETE FROM webhook_deliveries WHERE status = 'delivered' AND delivered_at < ?`
+
+	// restoreQuery brings a deleted classifier back with its old id and created_at
+	restoreQuery = `INSERT INTO classifiers (id, name, description, is_active, created_at) VALUES (?, ?, ?, ?, ?)`
+)
+
+var mysqlQueries = dialectQueries{
+	insert:      `INSERT INTO classifiers (name, description, is_active) VALUES (?, ?, ?)`,
+	get:         selectColumns + ` WHERE id = ?`,
+	getByName:   selectColumns + ` WHERE name = ?`,
+	count:       `SELECT COUNT(*) FROM classifiers`,
+	countSearch: `SELECT COUNT(*) FROM classifiers WHERE name LIKE ? OR description LIKE ?`,
+	list:        selectColumns + listOrder + ` LIMIT ? OFFSET ?`,
+	listSearch:  selectColumns + ` WHERE name LIKE ? OR description LIKE ?` + listOrder + ` LIMIT ? OFFSET ?`,
+	// LAST_INSERT_ID(id) makes LastInsertId return the existing row on an update, truquito de MySQL
+	upsert: `INSERT INTO classifiers (name, description, is_active) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id), description = VALUES(description), is_active = VALUES(is_active)`,
+	export:             selectColumns + listOrder,
+	exportSearch:       selectColumns + ` WHERE name LIKE ? OR description LIKE ?` + listOrder,
+	exportAsOf:         exportAsOfQuery,
+	getForUpdate:       selectColumns + ` WHERE id = ? FOR UPDATE`,
+	getByNameForUpdate: selectColumns + ` WHERE name = ? FOR UPDATE`,
+	update:             updateQuery,
+	delete:             deleteQuery,
+	nextVersion:        nextVersionQuery,
+	insertAudit:        insertAuditQuery,
+	countHistory:       countHistoryQuery,
+	listHistory:        listHistoryQuery,
+	getVersion:         getVersionQuery,
+	versionAt:          versionAtQuery,
+	countAsOf:          countAsOfQuery,
+	listAsOf:           listAsOfQuery,
+	restore:            restoreQuery,
+	insertOutbox:       insertOutboxQuery,
+	pendingOutbox:      pendingOutboxQuery,
+	markDispatched:     markDispatchedQuery,
+	markFailed:         markFailedQuery,
+	pruneOutbox:        pruneOutboxQuery,
+	pruneOldOutbox:     pruneOldOutboxQuery,
+	latestOutbox:       latestOutboxQuery,
+	tailOutbox:         tailOutboxQuery,
+	tryLockQuery:       `SELECT GET_LOCK(?, 0)`,
+	unlockQuery:        `SELECT RELEASE_LOCK(?)`,
+	insertWebhook:      insertWebhookQuery,
+	getWebhook:         getWebhookQuery,
+	listWebhooks:       listWebhooksQuery,
+	deleteWebhook:      deleteWebhookQuery,
+	deleteDeliveries:   deleteDeliveriesQuery,
+	insertDelivery:     `INSERT IGNORE` + strings.TrimPrefix(insertDeliveryQuery, `INSERT`),
+	dueDeliveries:      dueDeliveriesQuery,
+	markDelivered:      markDeliveredQuery,
+	markDeliveryFailed: markDeliveryFailedQuery,
+	countDeliveries:    countDeliveriesQuery,
+	listDeliveries:     listDeliveriesQuery,
+	retryDelivery:      retryDeliveryQuery,
+	pruneDeliveries:    pruneDeliveriesQuery,
+	searchArgs:         likeArgs,
+	isDuplicate: func(err error) bool {
+		var myErr *mysql.MySQLError
+		return errors.As(err, &myErr) && myErr.Number == 1062 // ER_DUP_ENTRY
+	},
+	isRetryable: func(err error) bool {
+		var myErr *mysql.MySQLError
+		if errors.As(err, &myErr) {
+			return myErr.Number == 1213 || myErr.Number == 1205 // deadlock, lock wait timeout
+		}
+		return errors.Is(err, mysql.ErrInvalidConn) || isConnectionError(err)
+	},
+}
+
+// SQLite has no default LIKE escape character, so we spell it out
+var sqliteQueries = dialectQueries{
+	insert:      `INSERT INTO classifiers (name, description, is_active) VALUES (?, ?, ?) RETURNING id`,
+	get:         selectColumns + ` WHERE id = ?`,
+	getByName:   selectColumns + ` WHERE name = ?`,
+	count:       `SELECT COUNT(*) FROM classifiers`,
+	countSearch: `SELECT COUNT(*) FROM classifiers WHERE name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\'`,
+	list:        selectColumns + listOrder + ` LIMIT ? OFFSET ?`,
+	listSearch: selectColumns + ` WHERE name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\'` +
+		listOrder + ` LIMIT ? OFFSET ?`,
+	upsert: `INSERT INTO classifiers (name, description, is_active) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET description = excluded.description, is_active = excluded.is_active
+		RETURNING id`,
+	export:             selectColumns + listOrder,
+	exportSearch:       selectColumns + ` WHERE name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\'` + listOrder,
+	exportAsOf:         exportAsOfQuery,
+	getForUpdate:       selectColumns + ` WHERE id = ?`,
+	getByNameForUpdate: selectColumns + ` WHERE name = ?`,
+	update:             updateQuery,
+	delete:             deleteQuery,
+	nextVersion:        nextVersionQuery,
+	insertAudit:        insertAuditQuery,
+	countHistory:       countHistoryQuery,
+	listHistory:        listHistoryQuery,
+	getVersion:         getVersionQuery,
+	versionAt:          versionAtQuery,
+	countAsOf:          countAsOfQuery,
+	listAsOf:           listAsOfQuery,
+	restore:            restoreQuery,
+	insertOutbox:       insertOutboxQuery,
+	pendingOutbox:      pendingOutboxQuery,
+	markDispatched:     markDispatchedQuery,
+	markFailed:         markFailedQuery,
+	pruneOutbox:        pruneOutboxQuery,
+	pruneOldOutbox:     pruneOldOutboxQuery,
+	latestOutbox:       latestOutboxQuery,
+	tailOutbox:         tailOutboxQuery,
+	insertWebhook:      insertWebhookQuery + ` RETURNING id`,
+	getWebhook:         getWebhookQuery,
+	listWebhooks:       listWebhooksQuery,
+	deleteWebhook:      deleteWebhookQuery,
+	deleteDeliveries:   deleteDeliveriesQuery,
+	insertDelivery:     insertDeliveryQuery + ` ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+	dueDeliveries:      dueDeliveriesQuery,
+	markDelivered:      markDeliveredQuery,
+	markDeliveryFailed: markDeliveryFailedQuery,
+	countDeliveries:    countDeliveriesQuery,
+	listDeliveries:     listDeliveriesQuery,
+	retryDelivery:      retryDeliveryQuery,
+	pruneDeliveries:    pruneDeliveriesQuery,
+	returningID:        true,
+	searchArgs:         likeArgs,
+	isDuplicate: func(err error) bool {
+		// Matching the message keeps the cgo driver out of this package
+		return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+	},
+	isRetryable: func(err error) bool {
+		// SQLITE_BUSY once the busy timeout ran out, another writer had the file
+		return (err != nil && strings.Contains(err.Error(), "database is locked")) || isConnectionError(err)
+	},
+}
+
+// Postgres search is full text (english config, so "country" finds "Countries") plus
+// an ILIKE so substrings match like they do on the other backends
+const postgresSearch = ` WHERE to_tsvector('english', name || ' ' || COALESCE(description, '')) @@ plainto_tsquery('english', $1)
+	OR name ILIKE $2 OR description ILIKE $2`
+
+var postgresQueries = dialectQueries{
+	insert:      `INSERT INTO classifiers (name, description, is_active) VALUES ($1, $2, $3) RETURNING id`,
+	get:         selectColumns + ` WHERE id = $1`,
+	getByName:   selectColumns + ` WHERE name = $1`,
+	count:       `SELECT COUNT(*) FROM classifiers`,
+	countSearch: `SELECT COUNT(*) FROM classifiers` + postgresSearch,
+	list:        selectColumns + listOrder + ` LIMIT $1 OFFSET $2`,
+	listSearch:  selectColumns + postgresSearch + listOrder + ` LIMIT $3 OFFSET $4`,
+	upsert: `INSERT INTO classifiers (name, description, is_active) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET description = excluded.description, is_active = excluded.is_active
+		RETURNING id`,
+	export:             selectColumns + listOrder,
+	exportSearch:       selectColumns + postgresSearch + listOrder,
+	exportAsOf:         numbered(exportAsOfQuery),
+	getForUpdate:       selectColumns + ` WHERE id = $1 FOR UPDATE`,
+	getByNameForUpdate: selectColumns + ` WHERE name = $1 FOR UPDATE`,
+	update:             numbered(updateQuery),
+	delete:             numbered(deleteQuery),
+	nextVersion:        numbered(nextVersionQuery),
+	insertAudit:        numbered(insertAuditQuery),
+	countHistory:       numbered(countHistoryQuery),
+	listHistory:        numbered(listHistoryQuery),
+	getVersion:         numbered(getVersionQuery),
+	versionAt:          numbered(versionAtQuery),
+	countAsOf:          numbered(countAsOfQuery),
+	listAsOf:           numbered(listAsOfQuery),
+	restore:            numbered(restoreQuery),
+	insertOutbox:       numbered(insertOutboxQuery),
+	pendingOutbox:      numbered(pendingOutboxQuery),
+	markDispatched:     numbered(markDispatchedQuery),
+	markFailed:         numbered(markFailedQuery),
+	pruneOutbox:        numbered(pruneOutboxQuery),
+	pruneOldOutbox:     numbered(pruneOldOutboxQuery),
+	latestOutbox:       latestOutboxQuery,
+	tailOutbox:         numbered(tailOutboxQuery),
+	tryLockQuery:       `SELECT pg_try_advisory_lock(hashtext($1))`,
+	unlockQuery:        `SELECT pg_advisory_unlock(hashtext($1))`,
+	insertWebhook:      numbered(insertWebhookQuery) + ` RETURNING id`,
+	getWebhook:         numbered(getWebhookQuery),
+	listWebhooks:       listWebhooksQuery,
+	deleteWebhook:      numbered(deleteWebhookQuery),
+	deleteDeliveries:   numbered(deleteDeliveriesQuery),
+	insertDelivery:     numbered(insertDeliveryQuery) + ` ON CONFLICT (webhook_id, event_id) DO NOTHING`,
+	dueDeliveries:      numbered(dueDeliveriesQuery),
+	markDelivered:      numbered(markDeliveredQuery),
+	markDeliveryFailed: numbered(markDeliveryFailedQuery),
+	countDeliveries:    numbered(countDeliveriesQuery),
+	listDeliveries:     numbered(listDeliveriesQuery),
+	retryDelivery:      numbered(retryDeliveryQuery),
+	pruneDeliveries:    numbered(pruneDeliveriesQuery),
+	returningID:        true,
+	searchArgs: func(term string) []interface{} {
+		return []interface{}{term, likePattern(term)}
+	},
+	isDuplicate: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == "23505" // unique_violation
+	},
+	isRetryable: func(err error) bool {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			// serialization_failure, deadlock_detected, lock_not_available
+			return pgErr.Code == "40001" || pgErr.Code == "40P01" || pgErr.Code == "55P03"
+		}
+		return pgconn.SafeToRetry(err) || isConnectionError(err)
+	},
+}
+
+// IsRetryable says whether err is transient for the dialect: a deadlock, a lock wait,
+// a dropped or refused connection. Those are worth retrying, the rest aren't
+func (d Dialect) IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	queries, qerr := d.queries()
+	if qerr != nil {
+		return isConnectionError(err)
+	}
+	return queries.isRetryable(err)
+}
+
+// isConnectionError is the part every driver shares: the pool handed us a dead
+// connection or we couldn't dial the server at all (failover, restart)
+func isConnectionError(err error) bool {
+	// context.DeadlineExceeded passes for a net.Error too, but once the context is
+	// done there's no point trying again
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr)
+}
+
+// numbered turns ? placeholders into Postgres' $1, $2...
+// Only for our own statements: it doesn't know about quotes, and none of them have a ?
+func numbered(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// likeArgs is the search for the LIKE dialects: same pattern for name and description
+func likeArgs(term string) []interface{} {
+	pattern := likePattern(term)
+	return []interface{}{pattern, pattern}
+}
+
+// likePattern turns a search term into a LIKE pattern, escaping the wildcards
+// so searching for "50%" doesn't match everything
+func likePattern(term string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(term) + "%"
+}
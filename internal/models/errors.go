@@ -5,3 +5,17 @@ import (
 )
 
 var ErrNoRecord = errors.New("models: no matching record found")
+
+// ErrDuplicateName means another classifier already uses that name
+var ErrDuplicateName = errors.New("models: duplicate classifier name")
+
+// ErrUnavailable means the database is down or flapping: retries ran out or the
+// circuit breaker is open. Worth trying again later, it's not the request's fault
+var ErrUnavailable = errors.New("models: database unavailable")
+
+// ErrDeletedVersion means the version asked for is a delete, there's no state to restore
+var ErrDeletedVersion = errors.New("models: that version is a deletion")
+
+// ErrPrivateWebhookTarget means a webhook URL leads inside: loopback, a private range,
+// link-local (where the cloud metadata endpoints live) or an unspecified address
+var ErrPrivateWebhookTarget = errors.New("models: webhook url points to a non-public address")
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// ExportOptions picks what Export goes through: the same filters as the list, minus
+// the paging. A zero AsOf is the catalog as it is now
+type ExportOptions struct {
+	Search string
+	AsOf   time.Time
+}
+
+// Export hands every classifier to fn, in the list's order, one row at a time
+// straight from the cursor, so the whole catalog never sits in memory. An error from
+// fn stops it and comes back as is. With Search and AsOf both set AsOf wins, the
+// history has no search index (the handlers don't let them meet anyway)
+func (m *ClassifierModel) Export(ctx context.Context, opts ExportOptions, fn func(*Classifier) error) error {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Export)
+	defer cancel()
+
+	if !opts.AsOf.IsZero() {
+		rows, err := m.DB.QueryContext(ctx, m.queries.exportAsOf, opts.AsOf.UTC())
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		return exportRows(ctx, rows, func(rows *sql.Rows) (*Classifier, error) {
+			var data sql.NullString
+			if err := rows.Scan(&data); err != nil {
+				return nil, err
+			}
+			snapshot, err := parseSnapshot(data)
+			if err != nil {
+				return nil, err
+			}
+			return snapshot.classifier(), nil
+		}, fn)
+	}
+
+	query, args := m.queries.export, []interface{}(nil)
+	if opts.Search != "" {
+		query, args = m.queries.exportSearch, m.queries.searchArgs(opts.Search)
+	}
+
+	// Only the query itself can go back to the primary: once rows went out to fn,
+	// starting over would hand them out twice
+	db, fromReplica := m.replicas.Reader(ctx)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if fromReplica && m.fallBack(ctx, db, err) {
+		rows, err = m.DB.QueryContext(ctx, query, args...)
+	}
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	return exportRows(ctx, rows, func(rows *sql.Rows) (*Classifier, error) {
+		return scanClassifier(rows)
+	}, fn)
+}
+
+func exportRows(ctx context.Context, rows *sql.Rows, scan func(*sql.Rows) (*Classifier, error), fn func(*Classifier) error) error {
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scan(rows)
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
@@ -0,0 +1,330 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChangeFeed streams the change events to subscribers inside this process, for the
+// SSE endpoint and friends. Every pod tails the outbox table on its own (the outbox
+// dispatcher runs in only one of them), so a client gets every event whatever pod it
+// lands on, with the outbox id as the event id on all of them.
+//
+// Events go out strictly in id order. An id that isn't there yet is a transaction
+// still in flight (or one that rolled back), so the ones after it wait for it up to
+// GapTimeout. The last ReplaySize events stay in memory for clients coming back with
+// the last id they saw. A subscriber that doesn't keep up is dropped instead of holding
+// everyone else back; it reconnects and replays what it missed
+type ChangeFeed struct {
+	db      *sql.DB
+	queries dialectQueries
+	opts    ChangeFeedOptions
+	loop    *pollLoop
+
+	// Only the loop touches these
+	low      int64                 // every event up to here went out, or we gave up waiting on it
+	ahead    map[int64]ChangeEvent // read but waiting for a missing id before them
+	gapSince time.Time
+
+	mu         sync.Mutex
+	ready      bool          // low is known
+	replay     []ChangeEvent // oldest first
+	replayFrom int64         // every event after this one is in replay
+	subs       map[*FeedSubscription]struct{}
+	closed     bool
+
+	dropped atomic.Int64
+	lastErr atomic.Value // string
+}
+
+// ChangeFeedOptions tunes the feed, zero values get the defaults
+type ChangeFeedOptions struct {
+	PollInterval time.Duration // how often we look at the outbox, 1s by default
+	BatchSize    int           // events per query, 500 by default
+	ReplaySize   int           // events kept for resuming, 1000 by default
+	GapTimeout   time.Duration // how long a missing id holds back the rest, 5s by default
+}
+
+// ChangeFeedStats is the feed's state for the metrics endpoint
+type ChangeFeedStats struct {
+	Running     bool
+	LastEventID int64
+	Subscribers int
+	Dropped     int64 // subscribers dropped for not keeping up, since start
+	LastError   string
+}
+
+func NewChangeFeed(db *sql.DB, d Dialect, opts ChangeFeedOptions) (*ChangeFeed, error) {
+	queries, err := d.queries()
+	if err != nil {
+		return nil, err
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	if opts.ReplaySize <= 0 {
+		opts.ReplaySize = 1000
+	}
+	if opts.GapTimeout <= 0 {
+		opts.GapTimeout = 5 * time.Second
+	}
+
+	return &ChangeFeed{
+		db:      db,
+		queries: queries,
+		opts:    opts,
+		loop:    newPollLoop(),
+		ahead:   make(map[int64]ChangeEvent),
+		subs:    make(map[*FeedSubscription]struct{}),
+	}, nil
+}
+
+// Start tails the outbox in the background, from the events written after now
+func (f *ChangeFeed) Start() {
+	f.loop.start(f.opts.PollInterval, func() {
+		if err := f.poll(); err != nil && f.loop.ctx.Err() == nil {
+			f.lastErr.Store(err.Error())
+		}
+	})
+}
+
+// Stop ends the tailing and closes every subscription, so the streams can finish
+func (f *ChangeFeed) Stop(ctx context.Context) error {
+	err := f.loop.stop(ctx)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	for sub := range f.subs {
+		close(sub.events)
+		delete(f.subs, sub)
+	}
+	return err
+}
+
+// Stats is a snapshot of the feed
+func (f *ChangeFeed) Stats() ChangeFeedStats {
+	f.mu.Lock()
+	subscribers := len(f.subs)
+	f.mu.Unlock()
+
+	lastErr, _ := f.lastErr.Load().(string)
+	return ChangeFeedStats{
+		Running:     f.loop.running.Load(),
+		LastEventID: f.lastEventID(),
+		Subscribers: subscribers,
+		Dropped:     f.dropped.Load(),
+		LastError:   lastErr,
+	}
+}
+
+func (f *ChangeFeed) lastEventID() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.replay) == 0 {
+		return f.replayFrom
+	}
+	return f.replay[len(f.replay)-1].ID
+}
+
+// FeedSubscription is one subscriber's view of the feed
+type FeedSubscription struct {
+	// Replay is what happened after the id the subscriber resumed from, send it first
+	Replay []ChangeEvent
+	// Reset means we no longer have everything after that id: the subscriber missed
+	// events and should reload whatever state it keeps
+	Reset bool
+
+	feed   *ChangeFeed
+	events chan ChangeEvent
+	after  int64 // skip up to here, the subscriber saw them on a pod that was ahead
+	lagged bool
+}
+
+// Events is closed when the subscriber falls behind (see Lagged) or the feed stops
+func (s *FeedSubscription) Events() <-chan ChangeEvent {
+	return s.events
+}
+
+// Lagged says the subscription was dropped for not keeping up with the events
+func (s *FeedSubscription) Lagged() bool {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	return s.lagged
+}
+
+// Close unsubscribes, it's fine to call it more than once
+func (s *FeedSubscription) Close() {
+	f := s.feed
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.subs[s]; ok {
+		delete(f.subs, s)
+		close(s.events)
+	}
+}
+
+// Subscribe starts a subscription with room for queue events not yet taken
+// lastEventID is the last event the subscriber saw, 0 to start from now
+func (f *ChangeFeed) Subscribe(lastEventID int64, queue int) *FeedSubscription {
+	sub := &FeedSubscription{
+		feed:   f,
+		events: make(chan ChangeEvent, max(queue, 1)),
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		close(sub.events)
+		return sub
+	}
+	if lastEventID > 0 {
+		if !f.ready || lastEventID < f.replayFrom {
+			sub.Reset = true
+		} else {
+			sub.after = lastEventID
+			i, _ := slices.BinarySearchFunc(f.replay, lastEventID+1, func(e ChangeEvent, id int64) int {
+				return cmp.Compare(e.ID, id)
+			})
+			sub.Replay = slices.Clone(f.replay[i:])
+		}
+	}
+	f.subs[sub] = struct{}{}
+	return sub
+}
+
+func (f *ChangeFeed) poll() error {
+	f.mu.Lock()
+	started := f.ready
+	f.mu.Unlock()
+
+	if !started {
+		var latest int64
+		if err := f.db.QueryRowContext(f.loop.ctx, f.queries.latestOutbox).Scan(&latest); err != nil {
+			return fmt.Errorf("models: reading the outbox position: %w", err)
+		}
+		f.low = latest
+		f.mu.Lock()
+		f.ready, f.replayFrom = true, latest
+		f.mu.Unlock()
+	}
+
+	for !f.loop.stopping() {
+		n, err := f.read()
+		if err != nil {
+			return err
+		}
+		ready := f.settle()
+		f.publish(ready)
+		// A full batch stuck behind a gap would read the same rows again, wait for the tick
+		if n < f.opts.BatchSize || len(ready) == 0 {
+			return nil
+		}
+	}
+	return nil
+}
+
+// read takes what's new in the outbox into ahead
+func (f *ChangeFeed) read() (int, error) {
+	rows, err := f.db.QueryContext(f.loop.ctx, f.queries.tailOutbox, f.low, f.opts.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+		var id int64
+		var payload string
+		if err := rows.Scan(&id, &payload); err != nil {
+			return n, err
+		}
+		if _, ok := f.ahead[id]; ok {
+			continue
+		}
+		var event ChangeEvent
+		if err := json.Unmarshal([]byte(payload), &event); err != nil {
+			return n, fmt.Errorf("models: outbox event %d: %w", id, err)
+		}
+		event.ID = id
+		f.ahead[id] = event
+	}
+	return n, rows.Err()
+}
+
+// settle takes out of ahead the events that can go now, in order
+func (f *ChangeFeed) settle() []ChangeEvent {
+	var ready []ChangeEvent
+	for {
+		if event, ok := f.ahead[f.low+1]; ok {
+			delete(f.ahead, f.low+1)
+			f.low++
+			f.gapSince = time.Time{}
+			ready = append(ready, event)
+			continue
+		}
+		if len(f.ahead) == 0 {
+			f.gapSince = time.Time{}
+			return ready
+		}
+		if f.gapSince.IsZero() {
+			f.gapSince = time.Now()
+			return ready
+		}
+		if time.Since(f.gapSince) < f.opts.GapTimeout {
+			return ready
+		}
+		// Waited long enough, that id isn't coming: skip to the next one we have
+		f.low = slices.Min(slices.Collect(maps.Keys(f.ahead))) - 1
+		f.gapSince = time.Time{}
+	}
+}
+
+// publish keeps the events for replay and hands them to the subscribers
+func (f *ChangeFeed) publish(events []ChangeEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.replay = append(f.replay, events...)
+	if extra := len(f.replay) - f.opts.ReplaySize; extra > 0 {
+		f.replayFrom = f.replay[extra-1].ID
+		f.replay = slices.Delete(f.replay, 0, extra)
+	}
+
+	for sub := range f.subs {
+		for _, event := range events {
+			if event.ID <= sub.after {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				// Its queue is full: drop it rather than wait, it can come back and replay
+				sub.lagged = true
+				close(sub.events)
+				delete(f.subs, sub)
+				f.dropped.Add(1)
+			}
+			if sub.lagged {
+				break
+			}
+		}
+	}
+}
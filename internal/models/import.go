@@ -0,0 +1,192 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ConflictStrategy is what an import does with a name that's already taken
+type ConflictStrategy string
+
+const (
+	ConflictFail      ConflictStrategy = "fail"      // the batch rolls back and the import stops
+	ConflictSkip      ConflictStrategy = "skip"      // the existing classifier stays as is
+	ConflictOverwrite ConflictStrategy = "overwrite" // description and is_active get replaced, like Upsert
+)
+
+// ImportRow is one classifier to load, the same fields Insert takes
+type ImportRow struct {
+	Name        string
+	Description string
+	IsActive    *bool
+}
+
+// ImportOptions says what to do with names already taken, and whether to write at all
+// A DryRun only reads: it looks each name up and works out what the real thing would
+// do (give or take whatever changes in between), without locks, ids or audit entries
+type ImportOptions struct {
+	OnConflict ConflictStrategy // ConflictFail if empty
+	DryRun     bool
+}
+
+// ImportAction is what happened to one row of an import
+type ImportAction string
+
+const (
+	ImportCreated   ImportAction = "created"
+	ImportUpdated   ImportAction = "updated"
+	ImportUnchanged ImportAction = "unchanged" // overwritten with what it already had
+	ImportSkipped   ImportAction = "skipped"
+	// ImportConflict only shows up in a dry run with ConflictFail, the real import
+	// would have stopped there
+	ImportConflict ImportAction = "conflict"
+)
+
+// ImportOutcome is one row's result. ID is 0 for a row a dry run would create
+type ImportOutcome struct {
+	ID     int64
+	Action ImportAction
+}
+
+// ImportConflictError is a taken name under ConflictFail. Nothing in its batch was
+// written; it matches ErrDuplicateName with errors.Is
+type ImportConflictError struct {
+	Row  int // index in the batch
+	Name string
+}
+
+func (e *ImportConflictError) Error() string {
+	return fmt.Sprintf("models: import row %d: a classifier named %q already exists", e.Row, e.Name)
+}
+
+func (e *ImportConflictError) Unwrap() error {
+	return ErrDuplicateName
+}
+
+// Import writes a batch of rows in one transaction, each with its audit entry like any
+// other mutation, and returns an outcome per row in the same order. Callers with a big
+// file send it in batches: each one is all or nothing, the ones before it stay
+func (m *ClassifierModel) Import(ctx context.Context, rows []ImportRow, opts ImportOptions) ([]ImportOutcome, error) {
+	ctx, cancel := withTimeout(ctx, m.timeouts.Import)
+	defer cancel()
+
+	if opts.DryRun {
+		return m.importPlan(ctx, rows, opts)
+	}
+
+	outcomes := make([]ImportOutcome, len(rows))
+	var changed []string
+	err := m.inTx(ctx, func(tx *sql.Tx) error {
+		for i, row := range rows {
+			outcome, err := m.importRow(ctx, tx, row, opts)
+			if err != nil {
+				var conflict *ImportConflictError
+				if errors.As(err, &conflict) {
+					conflict.Row = i
+				}
+				return err
+			}
+			outcomes[i] = outcome
+			if outcome.Action == ImportCreated || outcome.Action == ImportUpdated {
+				changed = append(changed, classifierKey(outcome.ID))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var conflict *ImportConflictError
+		if errors.As(err, &conflict) {
+			return nil, err
+		}
+		return nil, m.mutationError(ctx, err)
+	}
+
+	if len(changed) > 0 {
+		m.invalidate(ctx, append([]Invalidation{PrefixInvalidation(listKeyPrefix)}, keyInvalidations(changed)...)...)
+	}
+	return outcomes, nil
+}
+
+// importPlan is the dry run: plain reads on the primary, with what the rows before in
+// the batch would have done on top, the same as the transaction would see them
+func (m *ClassifierModel) importPlan(ctx context.Context, rows []ImportRow, opts ImportOptions) ([]ImportOutcome, error) {
+	outcomes := make([]ImportOutcome, len(rows))
+	planned := make(map[string]*Classifier) // by name, ID 0 for the ones we'd create
+	for i, row := range rows {
+		descriptionSQL, isActiveSQL := nullableFields(row.Description, row.IsActive)
+
+		before, ok := planned[row.Name]
+		if !ok {
+			var err error
+			before, err = scanClassifier(m.DB.QueryRowContext(ctx, m.queries.getByName, row.Name))
+			if errors.Is(err, sql.ErrNoRows) {
+				before = nil
+			} else if err != nil {
+				return nil, contextError(ctx, err)
+			} else {
+				planned[row.Name] = before
+			}
+		}
+		if before == nil {
+			planned[row.Name] = &Classifier{Name: row.Name, Description: descriptionSQL, IsActive: isActiveSQL}
+			outcomes[i] = ImportOutcome{Action: ImportCreated}
+			continue
+		}
+
+		switch opts.OnConflict {
+		case ConflictSkip:
+			outcomes[i] = ImportOutcome{ID: before.ID, Action: ImportSkipped}
+		case ConflictOverwrite:
+			after := *before
+			after.Description, after.IsActive = descriptionSQL, isActiveSQL
+			outcomes[i] = ImportOutcome{ID: before.ID, Action: ImportUpdated}
+			if sameContent(before, &after) {
+				outcomes[i].Action = ImportUnchanged
+			}
+			planned[row.Name] = &after
+		default:
+			outcomes[i] = ImportOutcome{ID: before.ID, Action: ImportConflict}
+		}
+	}
+	return outcomes, nil
+}
+
+// importRow does one row inside the batch's transaction
+func (m *ClassifierModel) importRow(ctx context.Context, tx *sql.Tx, row ImportRow, opts ImportOptions) (ImportOutcome, error) {
+	descriptionSQL, isActiveSQL := nullableFields(row.Description, row.IsActive)
+
+	before, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.getByNameForUpdate, row.Name))
+	if errors.Is(err, sql.ErrNoRows) {
+		id, err := m.queries.insertReturningID(ctx, tx, m.queries.insert, row.Name, descriptionSQL, isActiveSQL)
+		if err != nil {
+			return ImportOutcome{}, err
+		}
+		after, err := scanClassifier(tx.QueryRowContext(ctx, m.queries.get, id))
+		if err != nil {
+			return ImportOutcome{}, err
+		}
+		return ImportOutcome{ID: id, Action: ImportCreated}, m.writeAudit(ctx, tx, id, AuditCreated, nil, after)
+	}
+	if err != nil {
+		return ImportOutcome{}, err
+	}
+
+	switch opts.OnConflict {
+	case ConflictSkip:
+		return ImportOutcome{ID: before.ID, Action: ImportSkipped}, nil
+	case ConflictOverwrite:
+		after := *before
+		after.Description, after.IsActive = descriptionSQL, isActiveSQL
+		if sameContent(before, &after) {
+			return ImportOutcome{ID: before.ID, Action: ImportUnchanged}, nil
+		}
+		if _, err := tx.ExecContext(ctx, m.queries.update, after.Name, after.Description, after.IsActive, after.ID); err != nil {
+			return ImportOutcome{}, err
+		}
+		return ImportOutcome{ID: before.ID, Action: ImportUpdated}, m.writeAudit(ctx, tx, before.ID, AuditUpdated, before, &after)
+	default:
+		return ImportOutcome{}, &ImportConflictError{Name: row.Name}
+	}
+}
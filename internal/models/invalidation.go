@@ -0,0 +1,302 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"classifier.buhtigexa.net/internal/cache"
+)
+
+// InvalidationBus tells the other replicas "che, these cache keys are stale"
+// Publish is best effort like the cache itself, implementations report their own errors
+type InvalidationBus interface {
+	Publish(invs ...Invalidation)
+	Subscribe(fn func(invs []Invalidation)) (unsubscribe func())
+	Close() error
+}
+
+// Invalidation is one thing to evict: a key, or with Prefix every key starting with it.
+// It's said explicitly and not guessed from the key, a key can end in "*" too
+type Invalidation struct {
+	Key    string
+	Prefix bool
+}
+
+// KeyInvalidation evicts just that key
+func KeyInvalidation(key string) Invalidation {
+	return Invalidation{Key: key}
+}
+
+// PrefixInvalidation evicts every key starting with prefix, "" is the whole cache
+func PrefixInvalidation(prefix string) Invalidation {
+	return Invalidation{Key: prefix, Prefix: true}
+}
+
+// keyInvalidations is KeyInvalidation for each key
+func keyInvalidations(keys []string) []Invalidation {
+	invs := make([]Invalidation, len(keys))
+	for i, key := range keys {
+		invs[i] = KeyInvalidation(key)
+	}
+	return invs
+}
+
+// ApplyInvalidations evicts keys and prefixes from a cache store
+func ApplyInvalidations(store cache.Store, invs []Invalidation) {
+	for _, inv := range invs {
+		if inv.Prefix {
+			store.Flush(inv.Key)
+		} else {
+			store.Delete(inv.Key)
+		}
+	}
+}
+
+var (
+	_ InvalidationBus = (*MemoryInvalidationBus)(nil)
+	_ InvalidationBus = (*MySQLInvalidationBus)(nil)
+)
+
+// subscribers is the fan-out bookkeeping both buses share
+type subscribers struct {
+	mu   sync.RWMutex
+	next int
+	fns  map[int]func(invs []Invalidation)
+}
+
+func (s *subscribers) add(fn func(invs []Invalidation)) func() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fns == nil {
+		s.fns = make(map[int]func(invs []Invalidation))
+	}
+	id := s.next
+	s.next++
+	s.fns[id] = fn
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.fns, id)
+	}
+}
+
+func (s *subscribers) notify(invs []Invalidation) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, fn := range s.fns {
+		fn(invs)
+	}
+}
+
+// MemoryInvalidationBus delivers in-process and synchronously
+// Share one between several models in a test and you've got "replicas", re facil
+type MemoryInvalidationBus struct {
+	subs subscribers
+}
+
+func NewMemoryInvalidationBus() *MemoryInvalidationBus {
+	return &MemoryInvalidationBus{}
+}
+
+func (b *MemoryInvalidationBus) Publish(invs ...Invalidation) {
+	if len(invs) == 0 {
+		return
+	}
+	b.subs.notify(invs)
+}
+
+func (b *MemoryInvalidationBus) Subscribe(fn func(invs []Invalidation)) func() {
+	return b.subs.add(fn)
+}
+
+func (b *MemoryInvalidationBus) Close() error {
+	return nil
+}
+
+// MySQLInvalidationOptions tunes the polling bus
+type MySQLInvalidationOptions struct {
+	PollInterval time.Duration // how often we tail the table
+	Grace        time.Duration // re-read window for rows committed out of seq order
+	Retention    time.Duration // rows older than this get pruned
+	OnError      func(error)
+}
+
+// MySQLInvalidationBus writes keys into the invalidations table and every
+// instance tails it by sequence number, no extra infra needed viste
+type MySQLInvalidationBus struct {
+	db       *sql.DB
+	opts     MySQLInvalidationOptions
+	instance string // so we skip the rows we wrote ourselves
+	subs     subscribers
+
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMySQLInvalidationBus starts tailing from the current end of the table
+// Anything older is already covered by the cache TTLs
+func NewMySQLInvalidationBus(db *sql.DB, opts MySQLInvalidationOptions) (*MySQLInvalidationBus, error) {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = 5 * time.Second
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = time.Hour
+	}
+
+	instance, err := newInstanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	var cursor int64
+	if err := db.QueryRow(`SELECT COALESCE(MAX(seq), 0) FROM invalidations`).Scan(&cursor); err != nil {
+		return nil, fmt.Errorf("models: reading invalidations cursor: %w", err)
+	}
+
+	b := &MySQLInvalidationBus{
+		db:       db,
+		opts:     opts,
+		instance: instance,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go b.poll(cursor)
+	return b, nil
+}
+
+func (b *MySQLInvalidationBus) Publish(invs ...Invalidation) {
+	if len(invs) == 0 {
+		return
+	}
+
+	// One multi-row insert instead of a round trip per key
+	var sb strings.Builder
+	sb.WriteString(`INSERT INTO invalidations (cache_key, is_prefix, source) VALUES `)
+	args := make([]interface{}, 0, len(invs)*3)
+	for i, inv := range invs {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString("(?, ?, ?)")
+		args = append(args, inv.Key, inv.Prefix, b.instance)
+	}
+
+	if _, err := b.db.Exec(sb.String(), args...); err != nil {
+		b.reportError(fmt.Errorf("models: publishing invalidations: %w", err))
+	}
+}
+
+func (b *MySQLInvalidationBus) Subscribe(fn func(invs []Invalidation)) func() {
+	return b.subs.add(fn)
+}
+
+// Close stops the poller and waits for it, call it before closing the DB
+func (b *MySQLInvalidationBus) Close() error {
+	b.stopOnce.Do(func() {
+		close(b.done)
+	})
+	<-b.stopped
+	return nil
+}
+
+func (b *MySQLInvalidationBus) poll(cursor int64) {
+	defer close(b.stopped)
+
+	ticker := time.NewTicker(b.opts.PollInterval)
+	defer ticker.Stop()
+
+	lastPrune := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+			next, err := b.fetch(cursor)
+			if err != nil {
+				b.reportError(err)
+				continue
+			}
+			cursor = next
+
+			if time.Since(lastPrune) > b.opts.Retention/4 {
+				b.prune()
+				lastPrune = time.Now()
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
+
+// fetch reads everything past the cursor plus a short grace window
+// Auto increment values can commit out of order, so a row with a lower seq may
+// show up after we already moved on. Evicting twice is harmless, missing one isn't
+func (b *MySQLInvalidationBus) fetch(cursor int64) (int64, error) {
+	rows, err := b.db.Query(`
+		SELECT seq, cache_key, is_prefix
+		FROM invalidations
+		WHERE (seq > ? OR created_at >= NOW() - INTERVAL ? SECOND) AND source <> ?
+		ORDER BY seq`,
+		cursor, seconds(b.opts.Grace), b.instance)
+	if err != nil {
+		return cursor, fmt.Errorf("models: polling invalidations: %w", err)
+	}
+	defer rows.Close()
+
+	var invs []Invalidation
+	for rows.Next() {
+		var seq int64
+		var inv Invalidation
+		if err := rows.Scan(&seq, &inv.Key, &inv.Prefix); err != nil {
+			return cursor, err
+		}
+		invs = append(invs, inv)
+		if seq > cursor {
+			cursor = seq
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return cursor, err
+	}
+
+	if len(invs) > 0 {
+		b.subs.notify(invs)
+	}
+	return cursor, nil
+}
+
+func (b *MySQLInvalidationBus) prune() {
+	_, err := b.db.Exec(`DELETE FROM invalidations WHERE created_at < NOW() - INTERVAL ? SECOND`, seconds(b.opts.Retention))
+	if err != nil {
+		b.reportError(fmt.Errorf("models: pruning invalidations: %w", err))
+	}
+}
+
+func (b *MySQLInvalidationBus) reportError(err error) {
+	if b.opts.OnError != nil {
+		b.opts.OnError(err)
+	}
+}
+
+// seconds rounds up so a sub-second window doesn't turn into zero
+func seconds(d time.Duration) int64 {
+	return int64((d + time.Second - 1) / time.Second)
+}
+
+func newInstanceID() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
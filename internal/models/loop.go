@@ -0,0 +1,104 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// pollLoop is the start and stop plumbing of the background dispatchers: run tick
+// every interval until stop. ctx is cancelled when stop runs out of patience, so
+// whatever tick is doing gets cut short
+type pollLoop struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	done      chan struct{}
+	stopped   chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	running   atomic.Bool
+}
+
+func newPollLoop() *pollLoop {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &pollLoop{
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+}
+
+// start runs tick right away and then every interval, in its own goroutine
+func (l *pollLoop) start(interval time.Duration, tick func()) {
+	l.startOnce.Do(func() {
+		l.running.Store(true)
+		go func() {
+			defer close(l.stopped)
+			defer l.running.Store(false)
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				tick()
+				select {
+				case <-l.done:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	})
+}
+
+// stop waits for the tick in flight, cancelling ctx if the caller's ctx expires first
+func (l *pollLoop) stop(ctx context.Context) error {
+	l.stopOnce.Do(func() { close(l.done) })
+	l.startOnce.Do(func() { close(l.stopped) }) // never started, nothing to wait for
+	defer l.cancel()
+
+	select {
+	case <-l.stopped:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		<-l.stopped
+		return ctx.Err()
+	}
+}
+
+// stopping tells a long tick to wrap up
+func (l *pollLoop) stopping() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return l.ctx.Err() != nil
+	}
+}
+
+// tryLock takes a session advisory lock on conn without waiting, so only one pod runs
+// a dispatcher at a time. release gives it back, it's a no-op where there's no lock
+func (q dialectQueries) tryLock(ctx context.Context, conn *sql.Conn, name string) (release func(), got bool, err error) {
+	if q.tryLockQuery == "" {
+		return func() {}, true, nil
+	}
+	if err := conn.QueryRowContext(ctx, q.tryLockQuery, name).Scan(&got); err != nil || !got {
+		return nil, false, err
+	}
+	return func() {
+		// Background context: even when stopping we want the lock back
+		conn.ExecContext(context.Background(), q.unlockQuery, name)
+	}, true, nil
+}
+
+// doublingBackoff is base doubled per attempt already made, up to limit
+func doublingBackoff(base, limit time.Duration, attempts int) time.Duration {
+	if attempts >= 20 { // past that the shift overflows and we're at the cap anyway
+		return limit
+	}
+	return min(base<<attempts, limit)
+}
@@ -0,0 +1,474 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryClassifierStore is a ClassifierStore that lives in a map
+// Great for handler tests and running without MySQL, but it forgets everything on restart, ojo
+type MemoryClassifierStore struct {
+	mu          sync.RWMutex
+	nextID      int64
+	rows        map[int64]Classifier
+	byName      map[string]int64       // the unique index on name
+	order       []int64                // ids sorted like MySQL's ORDER BY created_at DESC, id DESC
+	audit       map[int64][]AuditEntry // per classifier, oldest version first
+	nextAuditID int64
+	now         func() time.Time
+}
+
+func NewMemoryClassifierStore() *MemoryClassifierStore {
+	return &MemoryClassifierStore{
+		rows:   make(map[int64]Classifier),
+		byName: make(map[string]int64),
+		audit:  make(map[int64][]AuditEntry),
+		now:    time.Now,
+	}
+}
+
+// Insert mimics the MySQL columns: empty description and nil is_active are stored
+// as NULL and created_at gets DATETIME's one second resolution
+func (s *MemoryClassifierStore) Insert(ctx context.Context, name string, description string, isActive *bool) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, taken := s.byName[name]; taken {
+		return 0, ErrDuplicateName
+	}
+	id := s.insertLocked(name, description, isActive)
+	s.recordLocked(ctx, id, AuditCreated, nil, s.rows[id])
+	return id, nil
+}
+
+// Upsert overwrites description and is_active when the name exists, like ON DUPLICATE KEY
+func (s *MemoryClassifierStore) Upsert(ctx context.Context, name string, description string, isActive *bool) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, taken := s.byName[name]
+	if !taken {
+		id = s.insertLocked(name, description, isActive)
+		s.recordLocked(ctx, id, AuditCreated, nil, s.rows[id])
+		return id, nil
+	}
+
+	before := s.rows[id]
+	c := before
+	c.Description, c.IsActive = nullableFields(description, isActive)
+	s.rows[id] = c
+	if !sameContent(&before, &c) {
+		s.recordLocked(ctx, id, AuditUpdated, &before, c)
+	}
+	return id, nil
+}
+
+// Update applies the patch, the unique name included
+func (s *MemoryClassifierStore) Update(ctx context.Context, id int64, patch ClassifierPatch) (*Classifier, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, ok := s.rows[id]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+	c := patch.apply(before)
+	if sameContent(&before, &c) {
+		return &c, nil
+	}
+	if other, taken := s.byName[c.Name]; taken && other != id {
+		return nil, ErrDuplicateName
+	}
+
+	delete(s.byName, before.Name)
+	s.byName[c.Name] = id
+	s.rows[id] = c
+	s.recordLocked(ctx, id, AuditUpdated, &before, c)
+	return &c, nil
+}
+
+// Delete frees the name too, like dropping the row frees it in the unique index
+func (s *MemoryClassifierStore) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, ok := s.rows[id]
+	if !ok {
+		return ErrNoRecord
+	}
+	delete(s.rows, id)
+	delete(s.byName, before.Name)
+	s.order = slices.DeleteFunc(s.order, func(other int64) bool { return other == id })
+
+	entry := newAuditEntry(ctx, id, len(s.audit[id])+1, AuditDeleted, &before, nil)
+	s.appendAuditLocked(entry)
+	return nil
+}
+
+// History pages through the versions newest first, like the SQL one
+func (s *MemoryClassifierStore) History(ctx context.Context, id int64, opts HistoryOptions) ([]*AuditEntry, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	opts = opts.normalize()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := s.audit[id]
+	total := len(versions)
+	offset := (opts.Page - 1) * opts.PageSize
+	entries := make([]*AuditEntry, 0, min(opts.PageSize, max(total-offset, 0)))
+	for i := total - 1 - offset; i >= 0 && len(entries) < opts.PageSize; i-- {
+		entry := versions[i]
+		entries = append(entries, &entry)
+	}
+	return entries, total, nil
+}
+
+func (s *MemoryClassifierStore) GetVersion(ctx context.Context, id int64, version int) (*AuditEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	versions := s.audit[id]
+	if version < 1 || version > len(versions) {
+		return nil, ErrNoRecord
+	}
+	entry := versions[version-1]
+	return &entry, nil
+}
+
+// recordLocked adds the next version of a classifier that still exists
+func (s *MemoryClassifierStore) recordLocked(ctx context.Context, id int64, action AuditAction, before *Classifier, after Classifier) {
+	s.appendAuditLocked(newAuditEntry(ctx, id, len(s.audit[id])+1, action, before, &after))
+}
+
+func (s *MemoryClassifierStore) appendAuditLocked(entry AuditEntry) {
+	s.nextAuditID++
+	entry.ID = s.nextAuditID
+	entry.CreatedAt = s.now().UTC().Truncate(time.Second)
+	s.audit[entry.ClassifierID] = append(s.audit[entry.ClassifierID], entry)
+}
+
+func (s *MemoryClassifierStore) insertLocked(name string, description string, isActive *bool) int64 {
+	c := Classifier{
+		Name:      name,
+		CreatedAt: s.now().UTC().Truncate(time.Second),
+	}
+	c.Description, c.IsActive = nullableFields(description, isActive)
+
+	s.nextID++
+	c.ID = s.nextID
+	s.rows[c.ID] = c
+	s.byName[name] = c.ID
+	s.insertOrdered(c)
+	return c.ID
+}
+
+// Nothing here blocks, but a cancelled context still fails like it would against a database
+func (s *MemoryClassifierStore) Get(ctx context.Context, id int64) (*Classifier, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.rows[id]
+	if !ok {
+		return nil, ErrNoRecord
+	}
+	return &c, nil // A copy, so nobody touches our rows from outside
+}
+
+func (s *MemoryClassifierStore) List(ctx context.Context, opts ListClassifiersOptions) ([]*Classifier, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	opts = opts.normalize()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := s.order
+	if opts.Search != "" {
+		ids = make([]int64, 0, len(s.order))
+		for _, id := range s.order {
+			if matchesSearch(s.rows[id], opts.Search) {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	total := len(ids)
+	offset := (opts.Page - 1) * opts.PageSize
+	if offset >= total {
+		return []*Classifier{}, total, nil
+	}
+	end := min(offset+opts.PageSize, total)
+
+	classifiers := make([]*Classifier, 0, end-offset)
+	for _, id := range ids[offset:end] {
+		c := s.rows[id]
+		classifiers = append(classifiers, &c)
+	}
+	return classifiers, total, nil
+}
+
+// matchesSearch is a case-insensitive substring match, what LIKE '%term%' does with
+// MySQL's default collation
+func matchesSearch(c Classifier, term string) bool {
+	term = strings.ToLower(term)
+	return strings.Contains(strings.ToLower(c.Name), term) ||
+		(c.Description.Valid && strings.Contains(strings.ToLower(c.Description.String), term))
+}
+
+// insertOrdered keeps s.order sorted newest first, ties broken by the higher id
+func (s *MemoryClassifierStore) insertOrdered(c Classifier) {
+	i := sort.Search(len(s.order), func(i int) bool {
+		other := s.rows[s.order[i]]
+		if !other.CreatedAt.Equal(c.CreatedAt) {
+			return other.CreatedAt.Before(c.CreatedAt)
+		}
+		return other.ID < c.ID
+	})
+	s.order = append(s.order, 0)
+	copy(s.order[i+1:], s.order[i:])
+	s.order[i] = c.ID
+}
+
+// GetAsOf reads the audit trail the same way the SQL store does
+func (s *MemoryClassifierStore) GetAsOf(ctx context.Context, id int64, asOf time.Time) (*Classifier, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry := s.versionAtLocked(id, asOf)
+	if entry == nil || entry.After == nil {
+		return nil, ErrNoRecord
+	}
+	return entry.After.classifier(), nil
+}
+
+func (s *MemoryClassifierStore) ListAsOf(ctx context.Context, opts AsOfOptions) ([]*Classifier, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	opts = opts.normalize()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := s.catalogAtLocked(opts.At)
+	total := len(all)
+	offset := (opts.Page - 1) * opts.PageSize
+	classifiers := make([]*Classifier, 0, min(opts.PageSize, max(total-offset, 0)))
+	for i := offset; i < total && len(classifiers) < opts.PageSize; i++ {
+		classifiers = append(classifiers, all[i].classifier())
+	}
+	return classifiers, total, nil
+}
+
+// catalogAtLocked is every classifier alive at asOf, sorted like the list
+func (s *MemoryClassifierStore) catalogAtLocked(asOf time.Time) []*ClassifierSnapshot {
+	type versioned struct {
+		created  time.Time // when version 1 was written, the list sorts on it
+		snapshot *ClassifierSnapshot
+	}
+	var all []versioned
+	for id, versions := range s.audit {
+		if entry := s.versionAtLocked(id, asOf); entry != nil && entry.After != nil {
+			all = append(all, versioned{created: versions[0].CreatedAt, snapshot: entry.After})
+		}
+	}
+	slices.SortFunc(all, func(a, b versioned) int {
+		if c := b.created.Compare(a.created); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.snapshot.ID, a.snapshot.ID)
+	})
+
+	snapshots := make([]*ClassifierSnapshot, len(all))
+	for i, v := range all {
+		snapshots[i] = v.snapshot
+	}
+	return snapshots
+}
+
+// Revert restores a version like the SQL store: a deleted classifier gets its id back
+func (s *MemoryClassifierStore) Revert(ctx context.Context, id int64, version int) (*Classifier, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.audit[id]
+	if version < 1 || version > len(versions) {
+		return nil, ErrNoRecord
+	}
+	target := versions[version-1].After
+	if target == nil {
+		return nil, ErrDeletedVersion
+	}
+	c := *target.classifier()
+
+	current, exists := s.rows[id]
+	if exists && sameContent(&current, &c) {
+		return &current, nil
+	}
+	if other, taken := s.byName[c.Name]; taken && other != id {
+		return nil, ErrDuplicateName
+	}
+
+	var before *Classifier
+	if exists {
+		before = &current
+		delete(s.byName, current.Name)
+	}
+	s.rows[id] = c
+	s.byName[c.Name] = id
+	if !exists {
+		s.insertOrdered(c)
+	}
+	s.recordLocked(ctx, id, AuditReverted, before, c)
+	return &c, nil
+}
+
+// versionAtLocked is the last version written by asOf, nil if there's none
+func (s *MemoryClassifierStore) versionAtLocked(id int64, asOf time.Time) *AuditEntry {
+	versions := s.audit[id]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].CreatedAt.After(asOf) {
+			return &versions[i]
+		}
+	}
+	return nil
+}
+
+// Export copies the matching rows under the lock and hands them out after it, so fn
+// can take its time (or call the store) without blocking the writers
+func (s *MemoryClassifierStore) Export(ctx context.Context, opts ExportOptions, fn func(*Classifier) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var classifiers []*Classifier
+	s.mu.RLock()
+	if !opts.AsOf.IsZero() {
+		for _, snapshot := range s.catalogAtLocked(opts.AsOf) {
+			classifiers = append(classifiers, snapshot.classifier())
+		}
+	} else {
+		for _, id := range s.order {
+			if c := s.rows[id]; opts.Search == "" || matchesSearch(c, opts.Search) {
+				classifiers = append(classifiers, &c)
+			}
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, c := range classifiers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import checks the whole batch before touching anything, so a conflict under
+// ConflictFail leaves the store as it was, like the rolled back transaction
+func (s *MemoryClassifierStore) Import(ctx context.Context, rows []ImportRow, opts ImportOptions) ([]ImportOutcome, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// First the plan: what each row would do, counting the rows before it in the batch
+	outcomes := make([]ImportOutcome, len(rows))
+	added := make(map[string]bool)
+	for i, row := range rows {
+		id, taken := s.byName[row.Name]
+		switch {
+		case !taken && !added[row.Name]:
+			outcomes[i].Action = ImportCreated
+			added[row.Name] = true
+		case opts.OnConflict == ConflictSkip:
+			outcomes[i] = ImportOutcome{ID: id, Action: ImportSkipped}
+		case opts.OnConflict == ConflictOverwrite:
+			outcomes[i] = ImportOutcome{ID: id, Action: ImportUpdated}
+			if before, ok := s.rows[id]; taken && ok {
+				c := before
+				c.Description, c.IsActive = nullableFields(row.Description, row.IsActive)
+				if sameContent(&before, &c) {
+					outcomes[i].Action = ImportUnchanged
+				}
+			}
+		case opts.DryRun:
+			outcomes[i] = ImportOutcome{ID: id, Action: ImportConflict}
+		default:
+			return nil, &ImportConflictError{Row: i, Name: row.Name}
+		}
+	}
+	if opts.DryRun {
+		return outcomes, nil
+	}
+
+	for i, row := range rows {
+		switch outcomes[i].Action {
+		case ImportCreated:
+			id := s.insertLocked(row.Name, row.Description, row.IsActive)
+			s.recordLocked(ctx, id, AuditCreated, nil, s.rows[id])
+			outcomes[i].ID = id
+		case ImportUpdated:
+			id := s.byName[row.Name]
+			before := s.rows[id]
+			c := before
+			c.Description, c.IsActive = nullableFields(row.Description, row.IsActive)
+			outcomes[i].ID = id
+			if sameContent(&before, &c) {
+				outcomes[i].Action = ImportUnchanged
+				continue
+			}
+			s.rows[id] = c
+			s.recordLocked(ctx, id, AuditUpdated, &before, c)
+		case ImportSkipped:
+			outcomes[i].ID = s.byName[row.Name] // it may have come earlier in this batch
+		}
+	}
+	return outcomes, nil
+}
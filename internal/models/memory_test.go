@@ -0,0 +1,17 @@
+package models_test
+
+import (
+	"testing"
+
+	"classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/internal/models/storetest"
+)
+
+func TestMemoryClassifierStore(t *testing.T) {
+	err := storetest.Run(func() (models.ClassifierStore, error) {
+		return models.NewMemoryClassifierStore(), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,548 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Every dialect gets its own folder of NNNN_name.up.sql / NNNN_name.down.sql pairs
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// ErrMigrationLocked means another process held the migration lock for the whole LockTimeout
+var ErrMigrationLocked = errors.New("models: another process is running migrations")
+
+// Migration is one embedded schema change
+type Migration struct {
+	Version  int
+	Name     string
+	Checksum string // sha256 of the up script, so editing an applied migration gets noticed
+	up       string
+	down     string
+}
+
+// MigrationStatus is a migration as the database sees it
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	Modified  bool // applied with a different checksum than the embedded script
+	Missing   bool // applied, but this binary doesn't know it (a newer build ran it)
+}
+
+// MigrationStep is one script run by Up, Down or Goto
+type MigrationStep struct {
+	Version  int
+	Name     string
+	Up       bool // false means it was rolled back
+	Duration time.Duration
+}
+
+type MigratorOptions struct {
+	// LockTimeout is how long we wait for another pod's migrations, 1 minute by default
+	LockTimeout time.Duration
+}
+
+// Migrator applies the embedded migrations and keeps track of them in schema_migrations
+// Every run takes an advisory lock (GET_LOCK on MySQL, pg_advisory_lock on Postgres)
+// so when ten pods start at once only one of them migrates and the rest wait
+type Migrator struct {
+	db          *sql.DB
+	dialect     Dialect
+	queries     migrationQueries
+	migrations  []Migration
+	lockTimeout time.Duration
+}
+
+func NewMigrator(db *sql.DB, d Dialect, opts MigratorOptions) (*Migrator, error) {
+	if d == "" {
+		d = MySQL
+	}
+	queries, err := d.migrationQueries()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(migrationFiles, path.Join("migrations", string(d)))
+	if err != nil {
+		return nil, err
+	}
+	if opts.LockTimeout <= 0 {
+		opts.LockTimeout = time.Minute
+	}
+	return &Migrator{
+		db:          db,
+		dialect:     d,
+		queries:     queries,
+		migrations:  migrations,
+		lockTimeout: opts.LockTimeout,
+	}, nil
+}
+
+// Migrations lists the embedded migrations, oldest first
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// Latest is the version Up leaves the database at
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status lists every migration, embedded or applied, oldest first
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var status []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		var err error
+		status, err = m.status(ctx, conn)
+		return err
+	})
+	return status, err
+}
+
+// Up applies everything that's pending
+func (m *Migrator) Up(ctx context.Context) ([]MigrationStep, error) {
+	return m.Goto(ctx, m.Latest())
+}
+
+// Down rolls back the last applied migration, just one, on purpose
+func (m *Migrator) Down(ctx context.Context) ([]MigrationStep, error) {
+	var steps []MigrationStep
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.checkedStatus(ctx, conn)
+		if err != nil {
+			return err
+		}
+		current := currentVersion(status)
+		if current == 0 {
+			return nil // nothing to roll back
+		}
+		target := 0
+		for _, s := range status {
+			if s.Applied && s.Version < current {
+				target = s.Version
+			}
+		}
+		steps, err = m.migrateTo(ctx, conn, status, target)
+		return err
+	})
+	return steps, err
+}
+
+// Goto moves the database to exactly version, applying or rolling back whatever is in between
+// Version 0 rolls back everything
+func (m *Migrator) Goto(ctx context.Context, version int) ([]MigrationStep, error) {
+	if version != 0 && m.find(version) == nil {
+		return nil, fmt.Errorf("models: unknown migration version %d", version)
+	}
+
+	var steps []MigrationStep
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		status, err := m.checkedStatus(ctx, conn)
+		if err != nil {
+			return err
+		}
+		steps, err = m.migrateTo(ctx, conn, status, version)
+		return err
+	})
+	return steps, err
+}
+
+// migrateTo rolls back every applied migration above target, newest first,
+// then applies every pending one up to target, oldest first
+func (m *Migrator) migrateTo(ctx context.Context, conn *sql.Conn, status []MigrationStatus, target int) ([]MigrationStep, error) {
+	var steps []MigrationStep
+
+	for i := len(status) - 1; i >= 0; i-- {
+		s := status[i]
+		if !s.Applied || s.Version <= target {
+			continue
+		}
+		step, err := m.run(ctx, conn, s.Migration, false)
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, step)
+	}
+
+	for _, s := range status {
+		if s.Applied || s.Version > target {
+			continue
+		}
+		step, err := m.run(ctx, conn, s.Migration, true)
+		if err != nil {
+			return steps, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// run executes one script and records it in schema_migrations
+// MySQL commits DDL implicitly, so there a failure halfway leaves the schema half done
+// and the version unrecorded. The next run starts the script over, and the statements
+// whose change is already in place are skipped (see alreadyApplied)
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mig Migration, up bool) (MigrationStep, error) {
+	step := MigrationStep{Version: mig.Version, Name: mig.Name, Up: up}
+
+	script, record, args := mig.up, m.queries.insert, []interface{}{mig.Version, mig.Name, mig.Checksum}
+	if !up {
+		if mig.down == "" {
+			return step, fmt.Errorf("models: migration %04d_%s has no down script", mig.Version, mig.Name)
+		}
+		script, record, args = mig.down, m.queries.delete, []interface{}{mig.Version}
+	}
+
+	start := time.Now()
+	err := m.inTx(ctx, conn, func(exec execer) error {
+		for _, stmt := range splitStatements(script) {
+			if _, err := exec.ExecContext(ctx, stmt); err != nil {
+				if m.dialect == MySQL && alreadyApplied(err) {
+					continue
+				}
+				return err
+			}
+		}
+		_, err := exec.ExecContext(ctx, record, args...)
+		return err
+	})
+	if err != nil {
+		direction := "up"
+		if !up {
+			direction = "down"
+		}
+		var myErr *mysql.MySQLError
+		if errors.As(err, &myErr) && myErr.Number == 1062 {
+			return step, fmt.Errorf("models: migration %04d_%s %s: the data has duplicates for a unique key it adds, clean them up and run it again: %w",
+				mig.Version, mig.Name, direction, err)
+		}
+		return step, fmt.Errorf("models: migration %04d_%s %s: %w", mig.Version, mig.Name, direction, err)
+	}
+	step.Duration = time.Since(start)
+	return step, nil
+}
+
+// alreadyApplied says whether a MySQL error means the statement's change is already there,
+// left by an earlier run of the same script that died before it was recorded
+func alreadyApplied(err error) bool {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return false
+	}
+	switch myErr.Number {
+	case 1050, // table already exists
+		1051, // unknown table, on the way down
+		1060, // duplicate column name
+		1061, // duplicate key name
+		1091: // can't drop a column or key that isn't there
+		return true
+	}
+	return false
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// inTx wraps fn in a transaction on the dialects with transactional DDL
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, fn func(execer) error) error {
+	if m.dialect == MySQL {
+		return fn(conn)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkedStatus refuses to move when an applied script was edited or is unknown,
+// because then the down scripts can't be trusted to undo what actually ran
+func (m *Migrator) checkedStatus(ctx context.Context, conn *sql.Conn) ([]MigrationStatus, error) {
+	status, err := m.status(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range status {
+		switch {
+		case s.Modified:
+			return nil, fmt.Errorf("models: migration %04d_%s changed after it was applied (checksum mismatch)", s.Version, s.Name)
+		case s.Missing:
+			return nil, fmt.Errorf("models: migration %04d_%s is applied but not embedded in this binary", s.Version, s.Name)
+		}
+	}
+	return status, nil
+}
+
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]MigrationStatus, error) {
+	if _, err := conn.ExecContext(ctx, m.queries.createTable); err != nil {
+		return nil, fmt.Errorf("models: creating schema_migrations: %w", err)
+	}
+
+	rows, err := conn.QueryContext(ctx, m.queries.applied)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]MigrationStatus)
+	for rows.Next() {
+		var s MigrationStatus
+		if err := rows.Scan(&s.Version, &s.Name, &s.Checksum, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		s.Applied = true
+		applied[s.Version] = s
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := MigrationStatus{Migration: mig}
+		if row, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = row.AppliedAt
+			s.Modified = row.Checksum != mig.Checksum
+			delete(applied, mig.Version)
+		}
+		status = append(status, s)
+	}
+	for _, row := range applied {
+		row.Missing = true
+		status = append(status, row)
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.migrations {
+		if m.migrations[i].Version == version {
+			return &m.migrations[i]
+		}
+	}
+	return nil
+}
+
+// currentVersion is the highest applied version, 0 on a fresh database
+func currentVersion(status []MigrationStatus) int {
+	current := 0
+	for _, s := range status {
+		if s.Applied && s.Version > current {
+			current = s.Version
+		}
+	}
+	return current
+}
+
+// withLock runs fn on a single connection holding the advisory lock
+// The lock belongs to the session, so everything has to go through that same conn
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.queries.lock != "" {
+		if err := m.lock(ctx, conn); err != nil {
+			return err
+		}
+		// Background context: if ctx got cancelled we still want the lock back
+		defer conn.ExecContext(context.Background(), m.queries.unlock, migrationLockName)
+	}
+	return fn(conn)
+}
+
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) error {
+	switch m.dialect {
+	case MySQL:
+		// GET_LOCK waits server side: 1 got it, 0 timed out, NULL something broke
+		var got sql.NullInt64
+		seconds := int(m.lockTimeout / time.Second)
+		if err := conn.QueryRowContext(ctx, m.queries.lock, migrationLockName, seconds).Scan(&got); err != nil {
+			return fmt.Errorf("models: taking migration lock: %w", err)
+		}
+		if !got.Valid || got.Int64 != 1 {
+			return ErrMigrationLocked
+		}
+		return nil
+	default:
+		// pg_try_advisory_lock doesn't wait, so we poll until the timeout
+		deadline := time.Now().Add(m.lockTimeout)
+		for {
+			var got bool
+			if err := conn.QueryRowContext(ctx, m.queries.lock, migrationLockName).Scan(&got); err != nil {
+				return fmt.Errorf("models: taking migration lock: %w", err)
+			}
+			if got {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return ErrMigrationLocked
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}
+}
+
+const migrationLockName = "classifiers.schema_migrations"
+
+type migrationQueries struct {
+	createTable string
+	applied     string
+	insert      string // version, name, checksum
+	delete      string // version
+	lock        string // empty means the database locks on its own (SQLite's single writer)
+	unlock      string
+}
+
+func (d Dialect) migrationQueries() (migrationQueries, error) {
+	const applied = `SELECT version, name, checksum, applied_at FROM schema_migrations ORDER BY version`
+
+	switch d {
+	case "", MySQL:
+		return migrationQueries{
+			createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+				version BIGINT NOT NULL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				checksum CHAR(64) NOT NULL,
+				applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+			applied: applied,
+			insert:  `INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)`,
+			delete:  `DELETE FROM schema_migrations WHERE version = ?`,
+			lock:    `SELECT GET_LOCK(?, ?)`,
+			unlock:  `SELECT RELEASE_LOCK(?)`,
+		}, nil
+	case SQLite:
+		return migrationQueries{
+			createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+				version INTEGER NOT NULL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				checksum CHAR(64) NOT NULL,
+				applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP)`,
+			applied: applied,
+			insert:  `INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)`,
+			delete:  `DELETE FROM schema_migrations WHERE version = ?`,
+		}, nil
+	case Postgres:
+		return migrationQueries{
+			createTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+				version BIGINT NOT NULL PRIMARY KEY,
+				name VARCHAR(255) NOT NULL,
+				checksum CHAR(64) NOT NULL,
+				applied_at TIMESTAMPTZ NOT NULL DEFAULT now())`,
+			applied: applied,
+			insert:  `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+			delete:  `DELETE FROM schema_migrations WHERE version = $1`,
+			// Advisory locks take a number, hashtext turns our name into one
+			lock:   `SELECT pg_try_advisory_lock(hashtext($1))`,
+			unlock: `SELECT pg_advisory_unlock(hashtext($1))`,
+		}, nil
+	default:
+		return migrationQueries{}, fmt.Errorf("models: unknown dialect %q", d)
+	}
+}
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// loadMigrations reads the up/down pairs in dir, sorted by version
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("models: reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		match := migrationName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("models: migration file %s/%s isn't NNNN_name.up.sql or NNNN_name.down.sql", dir, e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		script, err := fs.ReadFile(fsys, path.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != match[2] {
+			return nil, fmt.Errorf("models: migration version %d is used by %s and %s", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.up = string(script)
+			sum := sha256.Sum256(script)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.down = string(script)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.up == "" {
+			return nil, fmt.Errorf("models: migration %04d_%s has no up script", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// splitStatements cuts a script at the semicolons ending a line, dropping -- comments
+// The MySQL driver only runs one statement per Exec unless you turn on multiStatements,
+// and we'd rather not. Keep semicolons inside strings out of the migrations, eh
+func splitStatements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			if stmt := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(current.String()), ";")); stmt != "" {
+				stmts = append(stmts, stmt)
+			}
+			current.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
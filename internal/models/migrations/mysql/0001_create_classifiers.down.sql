@@ -0,0 +1,4 @@
+-- This takes every classifier with it, and on a database from the old init.sql the
+-- table was there before 0001 and gets dropped all the same. classifier migrate won't
+-- go below version 1 without -drop-data
+DROP TABLE IF EXISTS classifiers;
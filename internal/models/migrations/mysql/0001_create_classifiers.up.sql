@@ -0,0 +1,12 @@
+-- Classifiers with everything the loose scripts used to add by hand:
+-- unique names (upserts match on them) and the index the list query sorts with
+-- IF NOT EXISTS so databases created with the old init.sql adopt it sin drama
+CREATE TABLE IF NOT EXISTS classifiers (
+	id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	name VARCHAR(100) NOT NULL,
+	description TEXT NULL,
+	is_active BOOLEAN NULL DEFAULT TRUE,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE KEY uq_classifiers_name (name),
+	INDEX idx_classifiers_created_at (created_at DESC, id DESC)
+);
@@ -0,0 +1,9 @@
+-- Cross-instance cache invalidations (CACHE_INVALIDATION=mysql), every replica tails this by seq
+-- The pollers re-read a short window by time and the pruner deletes by time, hence the index
+CREATE TABLE IF NOT EXISTS invalidations (
+	seq BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	cache_key VARCHAR(255) NOT NULL,
+	source VARCHAR(32) NOT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	INDEX idx_invalidations_created_at (created_at)
+);
@@ -0,0 +1,15 @@
+-- One row per change to a classifier, written in the same transaction as the change
+-- version counts per classifier (1 is the create), before/after are JSON snapshots
+-- No foreign key on purpose: the history of a deleted classifier has to survive it
+CREATE TABLE IF NOT EXISTS classifier_audit (
+	id BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	classifier_id INTEGER NOT NULL,
+	version INTEGER NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	request_id VARCHAR(64) NOT NULL DEFAULT '',
+	before_json JSON NULL,
+	after_json JSON NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE KEY uq_classifier_audit_version (classifier_id, version)
+);
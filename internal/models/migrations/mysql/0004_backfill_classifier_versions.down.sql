@@ -0,0 +1,3 @@
+DROP INDEX idx_classifier_audit_created_at ON classifier_audit;
+
+DELETE FROM classifier_audit WHERE actor = 'migration';
@@ -0,0 +1,17 @@
+-- The audit trail doubles as the version history for as_of reads, so classifiers
+-- created before it existed get their version 1 here, dated when they were created
+INSERT INTO classifier_audit (classifier_id, version, action, actor, request_id, before_json, after_json, created_at)
+SELECT c.id, 1, 'created', 'migration', '', NULL,
+	JSON_OBJECT(
+		'id', c.id,
+		'name', c.name,
+		'description', c.description,
+		'is_active', CASE WHEN c.is_active IS NULL THEN NULL WHEN c.is_active THEN CAST('true' AS JSON) ELSE CAST('false' AS JSON) END,
+		'created_at', DATE_FORMAT(c.created_at, '%Y-%m-%dT%H:%i:%sZ')
+	),
+	c.created_at
+FROM classifiers c
+WHERE NOT EXISTS (SELECT 1 FROM classifier_audit a WHERE a.classifier_id = c.id);
+
+-- Point-in-time reads look for the last version before a date
+CREATE INDEX idx_classifier_audit_created_at ON classifier_audit (created_at, classifier_id, version);
@@ -0,0 +1,15 @@
+-- Change events waiting to go downstream, written in the same transaction as the change
+-- The dispatcher delivers them in id order and stamps dispatched_at; delivered rows are
+-- pruned after a while, so this stays small
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	classifier_id INTEGER NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	payload JSON NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME NULL,
+	last_error TEXT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	dispatched_at DATETIME NULL,
+	INDEX idx_outbox_pending (dispatched_at, id)
+);
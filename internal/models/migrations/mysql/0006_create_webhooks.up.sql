@@ -0,0 +1,27 @@
+-- Webhook subscriptions and one delivery per event and subscription
+-- event_types is a comma separated filter, empty means every event
+CREATE TABLE IF NOT EXISTS webhooks (
+	id BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(128) NOT NULL,
+	event_types VARCHAR(64) NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- status goes pending -> delivered, or dead once the attempts run out
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGINT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+	webhook_id BIGINT NOT NULL,
+	event_id BIGINT NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	payload JSON NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	last_status INTEGER NULL,
+	last_error TEXT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	delivered_at DATETIME NULL,
+	UNIQUE KEY uq_webhook_deliveries_event (webhook_id, event_id),
+	INDEX idx_webhook_deliveries_due (status, next_attempt_at)
+);
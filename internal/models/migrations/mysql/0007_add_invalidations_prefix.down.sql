@@ -0,0 +1 @@
+ALTER TABLE invalidations DROP COLUMN is_prefix;
@@ -0,0 +1,3 @@
+-- Whether cache_key is a prefix to flush or a single key, it used to be guessed from a trailing "*"
+-- Rows from pods that haven't rolled yet come in as plain keys, the list pages wait for their TTL
+ALTER TABLE invalidations ADD COLUMN is_prefix BOOLEAN NOT NULL DEFAULT FALSE AFTER cache_key;
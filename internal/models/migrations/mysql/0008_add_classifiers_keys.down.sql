@@ -0,0 +1,4 @@
+-- Nothing to undo: on a database 0001 created these were there from the start, and
+-- on an adopted one they're what 0001 should have made. Dropping the unique key
+-- would only bring the duplicates back
+DO 0;
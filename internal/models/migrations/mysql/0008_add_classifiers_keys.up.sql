@@ -0,0 +1,23 @@
+-- Databases from the old init.sql already had classifiers when 0001 ran, so its
+-- CREATE TABLE IF NOT EXISTS skipped them and they never got the unique name (the
+-- upsert's ON DUPLICATE KEY and the 409 on a taken name depend on it) nor the list
+-- index. Each one goes in only if there's no equivalent already, whatever its name.
+-- The unique key goes first: with repeated names it fails with 1062 before changing
+-- anything, see "Migraciones" in the README for finding them
+SET @has_unique_name = (SELECT COUNT(*) FROM (
+	SELECT index_name FROM information_schema.statistics
+	WHERE table_schema = DATABASE() AND table_name = 'classifiers' AND non_unique = 0
+	GROUP BY index_name HAVING COUNT(*) = 1 AND MAX(column_name) = 'name'
+) k);
+SET @ddl = IF(@has_unique_name = 0, 'ALTER TABLE classifiers ADD UNIQUE KEY uq_classifiers_name (name)', 'DO 0');
+PREPARE add_key FROM @ddl;
+EXECUTE add_key;
+DEALLOCATE PREPARE add_key;
+
+SET @has_created_at = (SELECT COUNT(*) FROM information_schema.statistics
+	WHERE table_schema = DATABASE() AND table_name = 'classifiers'
+		AND seq_in_index = 1 AND column_name = 'created_at');
+SET @ddl = IF(@has_created_at = 0, 'CREATE INDEX idx_classifiers_created_at ON classifiers (created_at DESC, id DESC)', 'DO 0');
+PREPARE add_index FROM @ddl;
+EXECUTE add_index;
+DEALLOCATE PREPARE add_index;
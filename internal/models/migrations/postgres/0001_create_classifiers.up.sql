@@ -0,0 +1,10 @@
+-- Postgres version of the MySQL classifiers table
+-- TIMESTAMPTZ(0) keeps the one second resolution of MySQL's DATETIME
+CREATE TABLE IF NOT EXISTS classifiers (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	name VARCHAR(100) NOT NULL,
+	description TEXT NULL,
+	is_active BOOLEAN NULL DEFAULT TRUE,
+	created_at TIMESTAMPTZ(0) NOT NULL DEFAULT now(),
+	CONSTRAINT uq_classifiers_name UNIQUE (name)
+);
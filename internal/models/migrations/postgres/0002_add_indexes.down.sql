@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_classifiers_search;
+DROP INDEX IF EXISTS idx_classifiers_created_at;
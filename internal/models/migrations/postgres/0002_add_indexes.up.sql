@@ -0,0 +1,8 @@
+-- The unique constraint on name already gives us the name index
+
+-- Add index on created_at for faster sorting in list operation, id breaks ties
+CREATE INDEX IF NOT EXISTS idx_classifiers_created_at ON classifiers(created_at DESC, id DESC);
+
+-- Full text search over name and description, same expression the search query uses
+CREATE INDEX IF NOT EXISTS idx_classifiers_search ON classifiers
+	USING GIN (to_tsvector('english', name || ' ' || COALESCE(description, '')));
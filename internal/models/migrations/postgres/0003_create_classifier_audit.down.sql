@@ -0,0 +1 @@
+DROP TABLE IF EXISTS classifier_audit;
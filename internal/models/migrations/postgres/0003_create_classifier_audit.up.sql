@@ -0,0 +1,13 @@
+-- Audit trail, same shape as the MySQL one with JSONB snapshots
+CREATE TABLE IF NOT EXISTS classifier_audit (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	classifier_id BIGINT NOT NULL,
+	version INTEGER NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	request_id VARCHAR(64) NOT NULL DEFAULT '',
+	before_json JSONB NULL,
+	after_json JSONB NULL,
+	created_at TIMESTAMPTZ(0) NOT NULL DEFAULT now(),
+	CONSTRAINT uq_classifier_audit_version UNIQUE (classifier_id, version)
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_classifier_audit_created_at;
+
+DELETE FROM classifier_audit WHERE actor = 'migration';
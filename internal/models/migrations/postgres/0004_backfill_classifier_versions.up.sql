@@ -0,0 +1,15 @@
+-- Version 1 for the classifiers created before the audit trail, see the MySQL one
+INSERT INTO classifier_audit (classifier_id, version, action, actor, request_id, before_json, after_json, created_at)
+SELECT c.id, 1, 'created', 'migration', '', NULL,
+	jsonb_build_object(
+		'id', c.id,
+		'name', c.name,
+		'description', c.description,
+		'is_active', c.is_active,
+		'created_at', to_char(c.created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+	),
+	c.created_at
+FROM classifiers c
+WHERE NOT EXISTS (SELECT 1 FROM classifier_audit a WHERE a.classifier_id = c.id);
+
+CREATE INDEX IF NOT EXISTS idx_classifier_audit_created_at ON classifier_audit (created_at, classifier_id, version);
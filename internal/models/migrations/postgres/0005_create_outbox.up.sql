@@ -0,0 +1,14 @@
+-- Change events waiting to go downstream, see the MySQL one
+CREATE TABLE IF NOT EXISTS outbox (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	classifier_id BIGINT NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	payload JSONB NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NULL,
+	last_error TEXT NULL,
+	created_at TIMESTAMPTZ(0) NOT NULL DEFAULT now(),
+	dispatched_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox (dispatched_at, id);
@@ -0,0 +1,26 @@
+-- Webhook subscriptions and their deliveries, see the MySQL one
+CREATE TABLE IF NOT EXISTS webhooks (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	url VARCHAR(2048) NOT NULL,
+	secret VARCHAR(128) NOT NULL,
+	event_types VARCHAR(64) NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ(0) NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	webhook_id BIGINT NOT NULL,
+	event_id BIGINT NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	payload JSONB NOT NULL,
+	status VARCHAR(16) NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_status INTEGER NULL,
+	last_error TEXT NULL,
+	created_at TIMESTAMPTZ(0) NOT NULL DEFAULT now(),
+	delivered_at TIMESTAMPTZ NULL,
+	CONSTRAINT uq_webhook_deliveries_event UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
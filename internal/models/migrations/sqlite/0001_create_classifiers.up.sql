@@ -0,0 +1,14 @@
+-- SQLite schema for local development, mirrors the MySQL migrations
+CREATE TABLE IF NOT EXISTS classifiers (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	name VARCHAR(100) NOT NULL,
+	description TEXT NULL,
+	is_active BOOLEAN NULL DEFAULT 1,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Names are unique so upserts can match on them
+CREATE UNIQUE INDEX IF NOT EXISTS uq_classifiers_name ON classifiers(name);
+
+-- Same sort as the list query: newest first, id breaks ties
+CREATE INDEX IF NOT EXISTS idx_classifiers_created_at ON classifiers(created_at DESC, id DESC);
@@ -0,0 +1,14 @@
+-- Audit trail, same shape as the MySQL one with the JSON kept as TEXT
+CREATE TABLE IF NOT EXISTS classifier_audit (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	classifier_id INTEGER NOT NULL,
+	version INTEGER NOT NULL,
+	action VARCHAR(16) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	request_id VARCHAR(64) NOT NULL DEFAULT '',
+	before_json TEXT NULL,
+	after_json TEXT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_classifier_audit_version ON classifier_audit(classifier_id, version);
@@ -0,0 +1,15 @@
+-- Version 1 for the classifiers created before the audit trail, see the MySQL one
+INSERT INTO classifier_audit (classifier_id, version, action, actor, request_id, before_json, after_json, created_at)
+SELECT c.id, 1, 'created', 'migration', '', NULL,
+	json_object(
+		'id', c.id,
+		'name', c.name,
+		'description', c.description,
+		'is_active', CASE WHEN c.is_active IS NULL THEN NULL WHEN c.is_active THEN json('true') ELSE json('false') END,
+		'created_at', strftime('%Y-%m-%dT%H:%M:%SZ', c.created_at)
+	),
+	c.created_at
+FROM classifiers c
+WHERE NOT EXISTS (SELECT 1 FROM classifier_audit a WHERE a.classifier_id = c.id);
+
+CREATE INDEX IF NOT EXISTS idx_classifier_audit_created_at ON classifier_audit(created_at, classifier_id, version);
@@ -0,0 +1,14 @@
+-- Change events waiting to go downstream, see the MySQL one
+CREATE TABLE IF NOT EXISTS outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	classifier_id INTEGER NOT NULL,
+	event_type VARCHAR(16) NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at DATETIME NULL,
+	last_error TEXT NULL,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	dispatched_at DATETIME NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON outbox(dispatched_at, id);
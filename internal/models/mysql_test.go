@@ -0,0 +1,26 @@
+package models_test
+
+import (
+	"os"
+	"testing"
+
+	_ "github.com/go-sql-driver/mysql"
+
+	"classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/internal/models/storetest"
+)
+
+// TEST_MYSQL_DSN points at a database the test can empty, with parseTime=true:
+// root:secret@tcp(localhost:3306)/classifiers_test?parseTime=true
+func TestMySQLClassifierStore(t *testing.T) {
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TEST_MYSQL_DSN isn't set")
+	}
+	err := storetest.Run(func() (models.ClassifierStore, error) {
+		return openSQLStore(t, models.MySQL, dsn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,379 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the kind of change a ChangeEvent carries
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// ChangeEvent is what downstream services get for every change to a classifier
+// ID grows with every event and is the same on every redelivery: dedupe on it
+type ChangeEvent struct {
+	ID           int64               `json:"id"`
+	Type         EventType           `json:"type"`
+	ClassifierID int64               `json:"classifier_id"`
+	Version      int                 `json:"version"`
+	Actor        string              `json:"actor"`
+	RequestID    string              `json:"request_id,omitempty"`
+	Before       *ClassifierSnapshot `json:"before"`
+	After        *ClassifierSnapshot `json:"after"`
+	Changes      []FieldChange       `json:"changes"`
+	OccurredAt   time.Time           `json:"occurred_at"`
+}
+
+// EventPublisher takes events out of the outbox, in order for each classifier
+// An error means "not now": the event stays in the outbox and comes back later
+type EventPublisher interface {
+	Publish(ctx context.Context, event ChangeEvent) error
+}
+
+// EventPublisherFunc lets a plain function be an EventPublisher
+type EventPublisherFunc func(ctx context.Context, event ChangeEvent) error
+
+func (f EventPublisherFunc) Publish(ctx context.Context, event ChangeEvent) error {
+	return f(ctx, event)
+}
+
+// changeEvent is the event for an audit entry. A revert is a create when it brought
+// the classifier back and an update otherwise, consumers don't need a fourth kind
+func changeEvent(entry AuditEntry) ChangeEvent {
+	eventType := EventUpdated
+	switch {
+	case entry.Action == AuditDeleted:
+		eventType = EventDeleted
+	case entry.Before == nil:
+		eventType = EventCreated
+	}
+	return ChangeEvent{
+		Type:         eventType,
+		ClassifierID: entry.ClassifierID,
+		Version:      entry.Version,
+		Actor:        entry.Actor,
+		RequestID:    entry.RequestID,
+		Before:       entry.Before,
+		After:        entry.After,
+		Changes:      entry.Changes,
+		OccurredAt:   time.Now().UTC(),
+	}
+}
+
+// writeOutbox queues the event for an audit entry, inside the mutation's transaction
+func (m *ClassifierModel) writeOutbox(ctx context.Context, tx *sql.Tx, entry AuditEntry) error {
+	event := changeEvent(entry)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, m.queries.insertOutbox, event.ClassifierID, string(event.Type), string(payload))
+	return err
+}
+
+// OutboxOptions tunes the dispatcher, zero values get the defaults
+type OutboxOptions struct {
+	PollInterval time.Duration // how often we look for new events, 1s by default
+	BatchSize    int           // events per query, 100 by default
+	BaseDelay    time.Duration // first retry after a failed publish, 1s by default, doubles each time
+	MaxDelay     time.Duration // retry cap, 5 minutes by default
+	OnError      func(event ChangeEvent, err error)
+}
+
+// OutboxStats is the dispatcher's state for the metrics endpoint
+type OutboxStats struct {
+	Running   bool
+	Delivered int64 // events published since start
+	Failed    int64 // failed publish attempts since start
+	LastError string
+}
+
+// OutboxDispatcher publishes the outbox: at least once, in order per classifier
+// A publish that fails blocks that classifier's later events until it goes through,
+// with backoff in between; other classifiers keep flowing. Only one pod dispatches at
+// a time (advisory lock, like the migrations), so the order holds across replicas
+// Delivered events stay in the table until the OutboxPruner gets to them
+type OutboxDispatcher struct {
+	db        *sql.DB
+	queries   dialectQueries
+	publisher EventPublisher
+	opts      OutboxOptions
+	loop      *pollLoop
+
+	delivered atomic.Int64
+	failed    atomic.Int64
+	lastErr   atomic.Value // string
+}
+
+const outboxLockName = "classifiers.outbox"
+
+func NewOutboxDispatcher(db *sql.DB, d Dialect, publisher EventPublisher, opts OutboxOptions) (*OutboxDispatcher, error) {
+	queries, err := d.queries()
+	if err != nil {
+		return nil, err
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = time.Second
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 5 * time.Minute
+	}
+
+	return &OutboxDispatcher{
+		db:        db,
+		queries:   queries,
+		publisher: publisher,
+		opts:      opts,
+		loop:      newPollLoop(),
+	}, nil
+}
+
+// Start runs the dispatcher in the background until Stop
+func (d *OutboxDispatcher) Start() {
+	d.loop.start(d.opts.PollInterval, func() {
+		if err := d.dispatch(); err != nil && d.loop.ctx.Err() == nil {
+			d.lastErr.Store(err.Error())
+		}
+	})
+}
+
+// Stop lets the event being published finish and doesn't start another
+// If ctx runs out first, the publish in flight is cancelled: it stays in the
+// outbox and goes out again next time, at least once
+func (d *OutboxDispatcher) Stop(ctx context.Context) error {
+	return d.loop.stop(ctx)
+}
+
+// Stats is a snapshot of the counters
+func (d *OutboxDispatcher) Stats() OutboxStats {
+	lastErr, _ := d.lastErr.Load().(string)
+	return OutboxStats{
+		Running:   d.loop.running.Load(),
+		Delivered: d.delivered.Load(),
+		Failed:    d.failed.Load(),
+		LastError: lastErr,
+	}
+}
+
+// dispatch drains what's ready, holding the lock so no other pod does the same
+func (d *OutboxDispatcher) dispatch() error {
+	conn, err := d.db.Conn(d.loop.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	release, got, err := d.queries.tryLock(d.loop.ctx, conn, outboxLockName)
+	if err != nil {
+		return fmt.Errorf("models: taking outbox lock: %w", err)
+	}
+	if !got {
+		return nil // another pod is on it, we'll try next tick
+	}
+	defer release()
+
+	for !d.loop.stopping() {
+		more, err := d.dispatchBatch(conn)
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+type outboxRow struct {
+	id           int64
+	classifierID int64
+	payload      string
+	attempts     int
+	nextAttempt  sql.NullTime
+}
+
+// dispatchBatch publishes one batch of pending events in id order. The query leaves out
+// the classifiers waiting on a retry, so a few of them failing can't fill every batch
+// more says whether it's worth going again right away
+func (d *OutboxDispatcher) dispatchBatch(conn *sql.Conn) (more bool, err error) {
+	pending, err := d.pending(conn)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	blocked := make(map[int64]bool) // classifiers with an earlier event still waiting
+	delivered := 0
+	for _, row := range pending {
+		if d.loop.stopping() {
+			return false, nil
+		}
+		if blocked[row.classifierID] {
+			continue
+		}
+		if row.nextAttempt.Valid && row.nextAttempt.Time.After(now) {
+			blocked[row.classifierID] = true
+			continue
+		}
+
+		var event ChangeEvent
+		if err := json.Unmarshal([]byte(row.payload), &event); err != nil {
+			return false, fmt.Errorf("models: outbox event %d: %w", row.id, err)
+		}
+		event.ID = row.id
+
+		if err := d.publisher.Publish(d.loop.ctx, event); err != nil {
+			blocked[row.classifierID] = true
+			if d.loop.ctx.Err() != nil {
+				return false, nil // cut short by Stop, not the publisher's fault
+			}
+			d.failed.Add(1)
+			d.lastErr.Store(err.Error())
+			if d.opts.OnError != nil {
+				d.opts.OnError(event, err)
+			}
+			retryAt := time.Now().Add(doublingBackoff(d.opts.BaseDelay, d.opts.MaxDelay, row.attempts)).UTC()
+			if _, err := conn.ExecContext(d.loop.ctx, d.queries.markFailed, retryAt, err.Error(), row.id); err != nil {
+				return false, err
+			}
+			continue
+		}
+
+		// If this fails the event goes out again later, which at least once allows
+		if _, err := conn.ExecContext(d.loop.ctx, d.queries.markDispatched, time.Now().UTC(), row.id); err != nil {
+			return false, err
+		}
+		d.delivered.Add(1)
+		delivered++
+	}
+	return delivered > 0 && len(pending) == d.opts.BatchSize, nil
+}
+
+func (d *OutboxDispatcher) pending(conn *sql.Conn) ([]outboxRow, error) {
+	rows, err := conn.QueryContext(d.loop.ctx, d.queries.pendingOutbox, time.Now().UTC(), d.opts.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Read them all first, the connection is busy until the rows are closed
+	var pending []outboxRow
+	for rows.Next() {
+		var row outboxRow
+		if err := rows.Scan(&row.id, &row.classifierID, &row.payload, &row.attempts, &row.nextAttempt); err != nil {
+			return nil, err
+		}
+		pending = append(pending, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// OutboxPrunerOptions tunes the pruner, zero values get the defaults
+type OutboxPrunerOptions struct {
+	Interval  time.Duration // how often it looks, 1 minute by default
+	Retention time.Duration // how long an event stays, 24h by default
+	// Undispatched prunes by age whether the event went out or not, for when nobody
+	// dispatches (OUTBOX_ENABLED=false) and the rows are only there for the streams
+	Undispatched bool
+	OnError      func(error)
+}
+
+// OutboxPruner deletes the old events from the outbox. It runs with or without the
+// dispatcher: every mutation writes to the outbox either way, the event streams tail it
+type OutboxPruner struct {
+	db      *sql.DB
+	queries dialectQueries
+	opts    OutboxPrunerOptions
+	loop    *pollLoop
+}
+
+const outboxPruneLockName = "classifiers.outbox.prune"
+
+func NewOutboxPruner(db *sql.DB, d Dialect, opts OutboxPrunerOptions) (*OutboxPruner, error) {
+	queries, err := d.queries()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Minute
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 24 * time.Hour
+	}
+	return &OutboxPruner{db: db, queries: queries, opts: opts, loop: newPollLoop()}, nil
+}
+
+// Start prunes right away and then every Interval, until Stop
+func (p *OutboxPruner) Start() {
+	p.loop.start(p.opts.Interval, func() {
+		if err := p.prune(); err != nil && p.loop.ctx.Err() == nil && p.opts.OnError != nil {
+			p.opts.OnError(err)
+		}
+	})
+}
+
+// Stop waits for the prune in flight, cancelling it if ctx runs out first
+func (p *OutboxPruner) Stop(ctx context.Context) error {
+	return p.loop.stop(ctx)
+}
+
+// prune deletes what's past the retention, one pod at a time
+func (p *OutboxPruner) prune() error {
+	conn, err := p.db.Conn(p.loop.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	release, got, err := p.queries.tryLock(p.loop.ctx, conn, outboxPruneLockName)
+	if err != nil {
+		return fmt.Errorf("models: taking outbox prune lock: %w", err)
+	}
+	if !got {
+		return nil // another pod is pruning
+	}
+	defer release()
+
+	query := p.queries.pruneOutbox
+	if p.opts.Undispatched {
+		query = p.queries.pruneOldOutbox
+	}
+	cutoff := time.Now().Add(-p.opts.Retention).UTC()
+	if _, err := conn.ExecContext(p.loop.ctx, query, cutoff); err != nil {
+		return fmt.Errorf("models: pruning outbox: %w", err)
+	}
+	return nil
+}
+
+// Publishers sends each event to all of them, even when one fails. A failure brings
+// the event back for every publisher, so each one has to shrug off a repeat
+type Publishers []EventPublisher
+
+func (ps Publishers) Publish(ctx context.Context, event ChangeEvent) error {
+	var errs []error
+	for _, p := range ps {
+		if err := p.Publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
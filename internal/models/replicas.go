@@ -0,0 +1,252 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Replica is a read-only copy of the primary
+type Replica struct {
+	Name string // for logs and metrics, never the DSN (it has the password)
+	DB   *sql.DB
+}
+
+// ReplicaOptions tunes the health checks and the read-your-writes window
+type ReplicaOptions struct {
+	HealthInterval time.Duration // how often we ping every replica, 5s by default
+	PingTimeout    time.Duration // 1s by default
+	StickyWindow   time.Duration // reads stay on the primary this long after a write, 5s by default
+	OnError        func(name string, err error)
+}
+
+// ReplicaStats is one replica's health for the metrics endpoint
+type ReplicaStats struct {
+	Name      string
+	Healthy   bool
+	Failures  int64 // failed pings and queries since start
+	LastError string
+}
+
+// ReplicaSet routes reads to healthy replicas and everything else to the primary
+// A replica that fails a ping or a query is out until it answers a ping again.
+// Replication is async, so after a client writes its reads stick to the primary for
+// StickyWindow: that client sees its own writes, everyone else is eventually consistent
+type ReplicaSet struct {
+	primary  *sql.DB
+	replicas []*replica
+	opts     ReplicaOptions
+	next     atomic.Uint64 // round robin cursor
+
+	mu        sync.Mutex
+	writes    map[string]time.Time // last write per client id
+	lastWrite time.Time            // last write by anyone, here or on another pod
+
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+type replica struct {
+	Replica
+	healthy  atomic.Bool
+	failures atomic.Int64
+	lastErr  atomic.Value // string
+}
+
+// NewReplicaSet takes ownership of the replica pools (Close closes them), not of the primary
+// Replicas start out unhealthy and join after their first good ping
+func NewReplicaSet(primary *sql.DB, replicas []Replica, opts ReplicaOptions) *ReplicaSet {
+	if opts.HealthInterval <= 0 {
+		opts.HealthInterval = 5 * time.Second
+	}
+	if opts.PingTimeout <= 0 {
+		opts.PingTimeout = time.Second
+	}
+	if opts.StickyWindow <= 0 {
+		opts.StickyWindow = 5 * time.Second
+	}
+
+	s := &ReplicaSet{
+		primary: primary,
+		opts:    opts,
+		writes:  make(map[string]time.Time),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, r := range replicas {
+		s.replicas = append(s.replicas, &replica{Replica: r})
+	}
+
+	// Without replicas every read is on the primary already, nothing to watch
+	if len(s.replicas) == 0 {
+		close(s.stopped)
+		return s
+	}
+
+	s.checkHealth()
+	go s.healthLoop()
+	return s
+}
+
+// Primary is the pool every write (and every fallback read) goes to
+func (s *ReplicaSet) Primary() *sql.DB {
+	return s.primary
+}
+
+// Reader picks the pool for a read: the primary when the client wrote recently or
+// no replica is healthy, otherwise the next healthy replica in round robin
+func (s *ReplicaSet) Reader(ctx context.Context) (db *sql.DB, fromReplica bool) {
+	if len(s.replicas) == 0 || s.sticky(ctx) {
+		return s.primary, false
+	}
+
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		r := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if r.healthy.Load() {
+			return r.DB, true
+		}
+	}
+	return s.primary, false
+}
+
+// ReportFailure takes a replica out of rotation after a failed query
+// Call it and then retry on the primary. Context errors don't count, that's the caller
+func (s *ReplicaSet) ReportFailure(db *sql.DB, err error) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	for _, r := range s.replicas {
+		if r.DB == db {
+			s.markDown(r, err)
+			return
+		}
+	}
+}
+
+// MarkWrite starts the stickiness window for the client in ctx, and the global one
+// Call it with a context without client id for writes made by other pods
+func (s *ReplicaSet) MarkWrite(ctx context.Context) {
+	if len(s.replicas) == 0 {
+		return
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastWrite = now
+	if id := ClientID(ctx); id != "" {
+		s.writes[id] = now
+	}
+}
+
+// RecentWrite says whether anybody wrote within the sticky window
+// A replica might not have that write yet, so what we read from it shouldn't be cached
+func (s *ReplicaSet) RecentWrite() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Since(s.lastWrite) < s.opts.StickyWindow
+}
+
+func (s *ReplicaSet) sticky(ctx context.Context) bool {
+	id := ClientID(ctx)
+	if id == "" {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wrote, ok := s.writes[id]
+	return ok && time.Since(wrote) < s.opts.StickyWindow
+}
+
+// Stats is the health of every replica, in config order
+func (s *ReplicaSet) Stats() []ReplicaStats {
+	stats := make([]ReplicaStats, 0, len(s.replicas))
+	for _, r := range s.replicas {
+		lastErr, _ := r.lastErr.Load().(string)
+		stats = append(stats, ReplicaStats{
+			Name:      r.Name,
+			Healthy:   r.healthy.Load(),
+			Failures:  r.failures.Load(),
+			LastError: lastErr,
+		})
+	}
+	return stats
+}
+
+// Close stops the health checks and closes the replica pools
+func (s *ReplicaSet) Close() error {
+	s.stopOnce.Do(func() { close(s.done) })
+	<-s.stopped
+
+	var errs []error
+	for _, r := range s.replicas {
+		errs = append(errs, r.DB.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ReplicaSet) healthLoop() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.opts.HealthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.checkHealth()
+			s.pruneWrites()
+		}
+	}
+}
+
+func (s *ReplicaSet) checkHealth() {
+	var wg sync.WaitGroup
+	for _, r := range s.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), s.opts.PingTimeout)
+			defer cancel()
+
+			if err := r.DB.PingContext(ctx); err != nil {
+				s.markDown(r, err)
+				return
+			}
+			r.healthy.Store(true)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *ReplicaSet) markDown(r *replica, err error) {
+	failures := r.failures.Add(1)
+	r.lastErr.Store(err.Error())
+	// Only report the transition (or the very first failure), a dead replica would
+	// flood the logs every interval
+	if (r.healthy.Swap(false) || failures == 1) && s.opts.OnError != nil {
+		s.opts.OnError(r.Name, err)
+	}
+}
+
+// pruneWrites forgets the clients whose window already closed
+func (s *ReplicaSet) pruneWrites() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, wrote := range s.writes {
+		if time.Since(wrote) >= s.opts.StickyWindow {
+			delete(s.writes, id)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CircuitState is where the breaker is at
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota // all good, everything goes through
+	CircuitOpen                         // the database is down, we fail fast
+	CircuitHalfOpen                     // cooldown over, one probe decides
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitOpenError is what callers get while the breaker is open
+// It matches ErrUnavailable, and RetryAfter is how long until the next probe
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("models: circuit breaker open, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// ResilienceOptions tunes the retries and the breaker, zero values get the defaults
+type ResilienceOptions struct {
+	Dialect       Dialect          // picks which errors are transient
+	Retryable     func(error) bool // overrides the dialect's classification
+	MaxRetries    int              // extra attempts for idempotent operations, 3 by default, -1 for none
+	BaseDelay     time.Duration    // first backoff, 50ms by default, doubles each time
+	MaxDelay      time.Duration    // backoff cap, 1s by default
+	Threshold     int              // consecutive failures that open the circuit, 5 by default
+	Cooldown      time.Duration    // how long it stays open before probing, 10s by default
+	OnStateChange func(from, to CircuitState)
+}
+
+// ResilienceStats is the breaker and retry state for the metrics endpoint
+type ResilienceStats struct {
+	State               CircuitState
+	ConsecutiveFailures int
+	OpenUntil           time.Time // zero unless open
+	Opens               int64     // times the circuit opened
+	Rejected            int64     // calls failed fast while open
+	Retries             int64     // extra attempts made
+}
+
+// ResilientStore wraps a ClassifierStore so a MySQL failover doesn't become a wall of 500s
+// Get, List, History and Upsert are idempotent and get retried with jittered backoff on
+// transient errors. Insert, Update and Delete aren't (after a dropped connection we can't
+// know if the change made it), so they go through once. Everything counts for the circuit breaker: after Threshold
+// failures in a row we stop hitting the database for Cooldown and answer ErrUnavailable
+type ResilientStore struct {
+	store ClassifierStore
+	opts  ResilienceOptions
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	openUntil time.Time
+	probing   bool // a half-open probe is in flight
+
+	opens    atomic.Int64
+	rejected atomic.Int64
+	retries  atomic.Int64
+}
+
+var _ ClassifierStore = (*ResilientStore)(nil)
+
+func NewResilientStore(store ClassifierStore, opts ResilienceOptions) *ResilientStore {
+	if opts.Retryable == nil {
+		opts.Retryable = opts.Dialect.IsRetryable
+	}
+	if opts.MaxRetries < 0 {
+		opts.MaxRetries = 0
+	} else if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 50 * time.Millisecond
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = time.Second
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 5
+	}
+	if opts.Cooldown <= 0 {
+		opts.Cooldown = 10 * time.Second
+	}
+	return &ResilientStore{store: store, opts: opts}
+}
+
+func (s *ResilientStore) Insert(ctx context.Context, name string, description string, isActive *bool) (int64, error) {
+	var id int64
+	err := s.do(ctx, false, func() error {
+		var err error
+		id, err = s.store.Insert(ctx, name, description, isActive)
+		return err
+	})
+	return id, err
+}
+
+// Upsert is safe to repeat: running it twice leaves the same row
+func (s *ResilientStore) Upsert(ctx context.Context, name string, description string, isActive *bool) (int64, error) {
+	var id int64
+	err := s.do(ctx, true, func() error {
+		var err error
+		id, err = s.store.Upsert(ctx, name, description, isActive)
+		return err
+	})
+	return id, err
+}
+
+// Update and Delete go through once, like Insert: if the connection drops on the
+// commit we can't tell whether it happened, and a second go would audit it twice
+func (s *ResilientStore) Update(ctx context.Context, id int64, patch ClassifierPatch) (*Classifier, error) {
+	var c *Classifier
+	err := s.do(ctx, false, func() error {
+		var err error
+		c, err = s.store.Update(ctx, id, patch)
+		return err
+	})
+	return c, err
+}
+
+func (s *ResilientStore) Delete(ctx context.Context, id int64) error {
+	return s.do(ctx, false, func() error {
+		return s.store.Delete(ctx, id)
+	})
+}
+
+func (s *ResilientStore) Get(ctx context.Context, id int64) (*Classifier, error) {
+	var c *Classifier
+	err := s.do(ctx, true, func() error {
+		var err error
+		c, err = s.store.Get(ctx, id)
+		return err
+	})
+	return c, err
+}
+
+func (s *ResilientStore) List(ctx context.Context, opts ListClassifiersOptions) ([]*Classifier, int, error) {
+	var classifiers []*Classifier
+	var total int
+	err := s.do(ctx, true, func() error {
+		var err error
+		classifiers, total, err = s.store.List(ctx, opts)
+		return err
+	})
+	return classifiers, total, err
+}
+
+func (s *ResilientStore) History(ctx context.Context, id int64, opts HistoryOptions) ([]*AuditEntry, int, error) {
+	var entries []*AuditEntry
+	var total int
+	err := s.do(ctx, true, func() error {
+		var err error
+		entries, total, err = s.store.History(ctx, id, opts)
+		return err
+	})
+	return entries, total, err
+}
+
+func (s *ResilientStore) GetVersion(ctx context.Context, id int64, version int) (*AuditEntry, error) {
+	var entry *AuditEntry
+	err := s.do(ctx, true, func() error {
+		var err error
+		entry, err = s.store.GetVersion(ctx, id, version)
+		return err
+	})
+	return entry, err
+}
+
+func (s *ResilientStore) GetAsOf(ctx context.Context, id int64, asOf time.Time) (*Classifier, error) {
+	var c *Classifier
+	err := s.do(ctx, true, func() error {
+		var err error
+		c, err = s.store.GetAsOf(ctx, id, asOf)
+		return err
+	})
+	return c, err
+}
+
+func (s *ResilientStore) ListAsOf(ctx context.Context, opts AsOfOptions) ([]*Classifier, int, error) {
+	var classifiers []*Classifier
+	var total int
+	err := s.do(ctx, true, func() error {
+		var err error
+		classifiers, total, err = s.store.ListAsOf(ctx, opts)
+		return err
+	})
+	return classifiers, total, err
+}
+
+// Revert is safe to repeat, like Upsert: the second go finds the version already there
+func (s *ResilientStore) Revert(ctx context.Context, id int64, version int) (*Classifier, error) {
+	var c *Classifier
+	err := s.do(ctx, true, func() error {
+		var err error
+		c, err = s.store.Revert(ctx, id, version)
+		return err
+	})
+	return c, err
+}
+
+// Export isn't retried: by the time the query fails some rows may already be out
+// What fn returns goes back as is, a client that hung up says nothing about the database
+func (s *ResilientStore) Export(ctx context.Context, opts ExportOptions, fn func(*Classifier) error) error {
+	var fnErr error
+	err := s.do(ctx, false, func() error {
+		return s.store.Export(ctx, opts, func(c *Classifier) error {
+			if fnErr = fn(c); fnErr != nil {
+				return errExportStopped
+			}
+			return nil
+		})
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	return err
+}
+
+// Import isn't retried either: a commit that failed on the way back may have gone through
+func (s *ResilientStore) Import(ctx context.Context, rows []ImportRow, opts ImportOptions) ([]ImportOutcome, error) {
+	var outcomes []ImportOutcome
+	err := s.do(ctx, false, func() error {
+		var err error
+		outcomes, err = s.store.Import(ctx, rows, opts)
+		return err
+	})
+	return outcomes, err
+}
+
+// errExportStopped tells the inner store to stop, it never leaves ResilientStore
+var errExportStopped = errors.New("models: export stopped by the caller")
+
+// Stats is a snapshot of the breaker and the retry counters
+func (s *ResilientStore) Stats() ResilienceStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := ResilienceStats{
+		State:               s.state,
+		ConsecutiveFailures: s.failures,
+		Opens:               s.opens.Load(),
+		Rejected:            s.rejected.Load(),
+		Retries:             s.retries.Load(),
+	}
+	if s.state == CircuitOpen {
+		stats.OpenUntil = s.openUntil
+	}
+	return stats
+}
+
+// do runs fn through the breaker, retrying transient errors when the op is idempotent
+// A transient error that survives the retries comes back wrapped in ErrUnavailable
+func (s *ResilientStore) do(ctx context.Context, idempotent bool, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := s.allow(); err != nil {
+			return err
+		}
+
+		err := fn()
+		if errors.Is(err, context.Canceled) {
+			// The caller gave up, that says nothing about the database either way
+			s.abandon()
+			return err
+		}
+		transient := err != nil && s.opts.Retryable(err)
+		s.record(transient || isTimeout(err))
+
+		if !transient {
+			return err
+		}
+		if !idempotent || attempt >= s.opts.MaxRetries {
+			return fmt.Errorf("%w: %w", ErrUnavailable, err)
+		}
+
+		s.retries.Add(1)
+		timer := time.NewTimer(s.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// backoff is "full jitter": random between zero and the exponential delay,
+// so a bunch of pods retrying together don't hammer the database in sync
+func (s *ResilientStore) backoff(attempt int) time.Duration {
+	delay := s.opts.MaxDelay
+	if attempt < 20 { // past that the shift overflows and we're at the cap anyway
+		delay = min(s.opts.BaseDelay<<attempt, s.opts.MaxDelay)
+	}
+	return rand.N(delay) + 1
+}
+
+// allow lets the call through or fails fast when the circuit is open
+func (s *ResilientStore) allow() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case CircuitOpen:
+		if wait := time.Until(s.openUntil); wait > 0 {
+			s.rejected.Add(1)
+			return &CircuitOpenError{RetryAfter: wait}
+		}
+		s.setState(CircuitHalfOpen)
+		s.probing = true
+		return nil
+	case CircuitHalfOpen:
+		if s.probing {
+			// Only one probe at a time, the rest wait for its verdict
+			s.rejected.Add(1)
+			return &CircuitOpenError{RetryAfter: s.opts.BaseDelay}
+		}
+		s.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record feeds an outcome to the breaker. Not found and duplicates are answers
+// from a healthy database, so they count as successes
+func (s *ResilientStore) record(failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.probing = false
+	if !failed {
+		s.failures = 0
+		if s.state != CircuitClosed {
+			s.setState(CircuitClosed)
+		}
+		return
+	}
+
+	s.failures++
+	if s.state == CircuitHalfOpen || s.failures >= s.opts.Threshold {
+		s.openUntil = time.Now().Add(s.opts.Cooldown)
+		if s.state != CircuitOpen {
+			s.opens.Add(1)
+			s.setState(CircuitOpen)
+		}
+	}
+}
+
+// abandon frees the half-open probe slot without a verdict
+func (s *ResilientStore) abandon() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probing = false
+}
+
+// setState must be called with mu held, so OnStateChange can't call back into the store
+func (s *ResilientStore) setState(to CircuitState) {
+	from := s.state
+	s.state = to
+	if s.opts.OnStateChange != nil && from != to {
+		s.opts.OnStateChange(from, to)
+	}
+}
+
+// isTimeout is a query that ran out its deadline: the database is too slow to answer,
+// which for the breaker is as bad as not answering
+func isTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
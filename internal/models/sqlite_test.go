@@ -0,0 +1,72 @@
+package models_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"classifier.buhtigexa.net/internal/models"
+	"classifier.buhtigexa.net/internal/models/storetest"
+)
+
+func TestSQLiteClassifierStore(t *testing.T) {
+	dir := t.TempDir()
+	n := 0
+	err := storetest.Run(func() (models.ClassifierStore, error) {
+		// A file per store is the cheapest way to start empty
+		n++
+		path := filepath.Join(dir, fmt.Sprintf("store-%d.db", n))
+		dsn := "file:" + path + "?_busy_timeout=5000&_journal_mode=WAL&_foreign_keys=on&_txlock=immediate"
+		return openSQLStore(t, models.SQLite, dsn)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The tables a store writes to, children first so the foreign keys don't complain
+var storeTables = []string{"webhook_deliveries", "webhooks", "outbox", "classifier_audit", "classifiers"}
+
+// openSQLStore hands storetest a ClassifierModel on a clean openSQLDB
+func openSQLStore(t *testing.T, d models.Dialect, dsn string) (models.ClassifierStore, error) {
+	db, err := openSQLDB(t, d, dsn)
+	if err != nil {
+		return nil, err
+	}
+	model, err := models.NewClassifierModel(db, models.ClassifierModelOptions{Dialect: d})
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { model.Close() })
+	return model, nil
+}
+
+// openSQLDB opens dsn, brings it to the latest migration and empties the tables
+// Everything closes with the test
+func openSQLDB(t *testing.T, d models.Dialect, dsn string) (*sql.DB, error) {
+	ctx := context.Background()
+	db, err := sql.Open(d.DriverName(), dsn)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := models.NewMigrator(db, d, models.MigratorOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		return nil, fmt.Errorf("migrating: %w", err)
+	}
+	for _, table := range storeTables {
+		if _, err := db.ExecContext(ctx, "DELETE FROM "+table); err != nil {
+			return nil, fmt.Errorf("emptying %s: %w", table, err)
+		}
+	}
+
+	return db, nil
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// ClassifierStore is what the handlers need from the storage layer
+// ClassifierModel talks to MySQL, MemoryClassifierStore keeps everything in a map,
+// and both have to behave the same (storetest checks that), so handlers don't care
+// Every method takes the request's context: if the client leaves, the query stops
+// Every mutation leaves an AuditEntry, with the actor and request id found in ctx
+type ClassifierStore interface {
+	Insert(ctx context.Context, name string, description string, isActive *bool) (int64, error)
+	Upsert(ctx context.Context, name string, description string, isActive *bool) (int64, error)
+	Update(ctx context.Context, id int64, patch ClassifierPatch) (*Classifier, error)
+	Delete(ctx context.Context, id int64) error
+	Get(ctx context.Context, id int64) (*Classifier, error)
+	List(ctx context.Context, opts ListClassifiersOptions) ([]*Classifier, int, error)
+	History(ctx context.Context, id int64, opts HistoryOptions) ([]*AuditEntry, int, error)
+	GetVersion(ctx context.Context, id int64, version int) (*AuditEntry, error)
+	GetAsOf(ctx context.Context, id int64, asOf time.Time) (*Classifier, error)
+	ListAsOf(ctx context.Context, opts AsOfOptions) ([]*Classifier, int, error)
+	Revert(ctx context.Context, id int64, version int) (*Classifier, error)
+	Export(ctx context.Context, opts ExportOptions, fn func(*Classifier) error) error
+	Import(ctx context.Context, rows []ImportRow, opts ImportOptions) ([]ImportOutcome, error)
+}
+
+var (
+	_ ClassifierStore = (*ClassifierModel)(nil)
+	_ ClassifierStore = (*MemoryClassifierStore)(nil)
+)
+
+// normalize applies the same defaults every backend uses, so page 0 or
+// page_size 500 mean the same thing everywhere
+func (opts ListClassifiersOptions) normalize() ListClassifiersOptions {
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.PageSize < 1 || opts.PageSize > 100 {
+		opts.PageSize = 20
+	}
+	return opts
+}
@@ -0,0 +1,813 @@
+// Package storetest is the conformance suite every models.ClassifierStore has to pass
+// Same idea as testing/fstest: Run returns an error listing everything that broke,
+// so it works from a test, a benchmark or a one-off check against a real database
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+// NewStore must hand back an empty store each time it's called
+type NewStore func() (models.ClassifierStore, error)
+
+type check struct {
+	name string
+	fn   func(models.ClassifierStore) error
+}
+
+var checks = []check{
+	{"GetMissing", testGetMissing},
+	{"InsertAndGet", testInsertAndGet},
+	{"NullableFields", testNullableFields},
+	{"ListOrder", testListOrder},
+	{"ListPagination", testListPagination},
+	{"ListDefaults", testListDefaults},
+	{"ListEmpty", testListEmpty},
+	{"ConcurrentInserts", testConcurrentInserts},
+	{"DuplicateName", testDuplicateName},
+	{"Upsert", testUpsert},
+	{"Search", testSearch},
+	{"CancelledContext", testCancelledContext},
+	{"Update", testUpdate},
+	{"Delete", testDelete},
+	{"History", testHistory},
+	{"AsOf", testAsOf},
+	{"Revert", testRevert},
+	{"Export", testExport},
+	{"Import", testImport},
+}
+
+// The checks don't care about deadlines, the store just has to honour the context
+var ctx = context.Background()
+
+// Run executes every check on a fresh store and joins the failures
+func Run(newStore NewStore) error {
+	var errs []error
+	for _, c := range checks {
+		store, err := newStore()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: creating store: %w", c.name, err))
+			continue
+		}
+		if err := c.fn(store); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", c.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func testGetMissing(s models.ClassifierStore) error {
+	_, err := s.Get(ctx, 424242)
+	if !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("Get of a missing id: got %v, want ErrNoRecord", err)
+	}
+	return nil
+}
+
+func testInsertAndGet(s models.ClassifierStore) error {
+	active := true
+	id, err := s.Insert(ctx, "Colors", "All the colors", &active)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	if id < 1 {
+		return fmt.Errorf("Insert returned id %d, want a positive id", id)
+	}
+
+	c, err := s.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Get(%d): %w", id, err)
+	}
+	if c.ID != id || c.Name != "Colors" {
+		return fmt.Errorf("Get(%d) = {ID:%d Name:%q}, want {ID:%d Name:%q}", id, c.ID, c.Name, id, "Colors")
+	}
+	if !c.Description.Valid || c.Description.String != "All the colors" {
+		return fmt.Errorf("description = %+v, want %q", c.Description, "All the colors")
+	}
+	if !c.IsActive.Valid || !c.IsActive.Bool {
+		return fmt.Errorf("is_active = %+v, want true", c.IsActive)
+	}
+	if c.CreatedAt.IsZero() {
+		return errors.New("created_at is zero")
+	}
+
+	second, err := s.Insert(ctx, "Sizes", "", nil)
+	if err != nil {
+		return fmt.Errorf("second Insert: %w", err)
+	}
+	if second <= id {
+		return fmt.Errorf("ids must grow: got %d after %d", second, id)
+	}
+	return nil
+}
+
+func testNullableFields(s models.ClassifierStore) error {
+	id, err := s.Insert(ctx, "Bare", "", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	c, err := s.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Get(%d): %w", id, err)
+	}
+	if c.Description.Valid {
+		return fmt.Errorf("empty description stored as %+v, want NULL", c.Description)
+	}
+	if c.IsActive.Valid {
+		return fmt.Errorf("nil is_active stored as %+v, want NULL", c.IsActive)
+	}
+
+	inactive := false
+	id, err = s.Insert(ctx, "Off", "", &inactive)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	c, err = s.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Get(%d): %w", id, err)
+	}
+	if !c.IsActive.Valid || c.IsActive.Bool {
+		return fmt.Errorf("is_active = %+v, want false", c.IsActive)
+	}
+	return nil
+}
+
+// testListOrder checks newest first with the id as tiebreaker: rows inserted in the
+// same second (DATETIME resolution) must still come out in a stable order
+func testListOrder(s models.ClassifierStore) error {
+	ids, err := insertN(s, 5)
+	if err != nil {
+		return err
+	}
+
+	got, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 1, PageSize: 10})
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if total != 5 || len(got) != 5 {
+		return fmt.Errorf("List returned %d rows, total %d, want 5 and 5", len(got), total)
+	}
+	for i, c := range got {
+		want := ids[len(ids)-1-i]
+		if c.ID != want {
+			return fmt.Errorf("row %d has id %d, want %d (newest first)", i, c.ID, want)
+		}
+		if c.Name == "" || c.CreatedAt.IsZero() {
+			return fmt.Errorf("row %d is missing fields: %+v", i, c)
+		}
+	}
+	return nil
+}
+
+func testListPagination(s models.ClassifierStore) error {
+	if _, err := insertN(s, 7); err != nil {
+		return err
+	}
+
+	seen := make(map[int64]bool)
+	for page, want := range []int{3, 3, 1} {
+		got, total, err := s.List(ctx, models.ListClassifiersOptions{Page: page + 1, PageSize: 3})
+		if err != nil {
+			return fmt.Errorf("List page %d: %w", page+1, err)
+		}
+		if total != 7 {
+			return fmt.Errorf("page %d total = %d, want 7", page+1, total)
+		}
+		if len(got) != want {
+			return fmt.Errorf("page %d has %d rows, want %d", page+1, len(got), want)
+		}
+		for _, c := range got {
+			if seen[c.ID] {
+				return fmt.Errorf("id %d shows up on two pages", c.ID)
+			}
+			seen[c.ID] = true
+		}
+	}
+
+	got, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 4, PageSize: 3})
+	if err != nil {
+		return fmt.Errorf("List past the end: %w", err)
+	}
+	if len(got) != 0 || total != 7 {
+		return fmt.Errorf("past the end: %d rows, total %d, want 0 and 7", len(got), total)
+	}
+	return nil
+}
+
+func testListDefaults(s models.ClassifierStore) error {
+	if _, err := insertN(s, 25); err != nil {
+		return err
+	}
+
+	for _, opts := range []models.ListClassifiersOptions{
+		{},
+		{Page: -1, PageSize: 0},
+		{Page: 1, PageSize: 101},
+	} {
+		got, total, err := s.List(ctx, opts)
+		if err != nil {
+			return fmt.Errorf("List(%+v): %w", opts, err)
+		}
+		if len(got) != 20 || total != 25 {
+			return fmt.Errorf("List(%+v) = %d rows, total %d, want the first 20 of 25", opts, len(got), total)
+		}
+	}
+	return nil
+}
+
+func testListEmpty(s models.ClassifierStore) error {
+	got, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 1, PageSize: 20})
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if got == nil {
+		return errors.New("empty List returned a nil slice, want an empty one (it's encoded as [])")
+	}
+	if len(got) != 0 || total != 0 {
+		return fmt.Errorf("empty store listed %d rows, total %d", len(got), total)
+	}
+	return nil
+}
+
+func testConcurrentInserts(s models.ClassifierStore) error {
+	const n = 50
+
+	var wg sync.WaitGroup
+	ids := make([]int64, n)
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], errs[i] = s.Insert(ctx, fmt.Sprintf("concurrent-%d", i), "", nil)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	seen := make(map[int64]bool, n)
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("id %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+
+	_, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 1, PageSize: 1})
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if total != n {
+		return fmt.Errorf("total = %d after %d concurrent inserts", total, n)
+	}
+	return nil
+}
+
+func testDuplicateName(s models.ClassifierStore) error {
+	if _, err := s.Insert(ctx, "Unique", "", nil); err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	_, err := s.Insert(ctx, "Unique", "again", nil)
+	if !errors.Is(err, models.ErrDuplicateName) {
+		return fmt.Errorf("second Insert with the same name: got %v, want ErrDuplicateName", err)
+	}
+	return nil
+}
+
+func testUpsert(s models.ClassifierStore) error {
+	active := true
+	id, err := s.Upsert(ctx, "Regions", "v1", &active)
+	if err != nil {
+		return fmt.Errorf("first Upsert: %w", err)
+	}
+
+	inactive := false
+	again, err := s.Upsert(ctx, "Regions", "v2", &inactive)
+	if err != nil {
+		return fmt.Errorf("second Upsert: %w", err)
+	}
+	if again != id {
+		return fmt.Errorf("Upsert of an existing name returned id %d, want %d", again, id)
+	}
+
+	c, err := s.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Get(%d): %w", id, err)
+	}
+	if c.Description.String != "v2" || !c.IsActive.Valid || c.IsActive.Bool {
+		return fmt.Errorf("after Upsert got %+v, want description v2 and is_active false", c)
+	}
+
+	_, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 1, PageSize: 10})
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if total != 1 {
+		return fmt.Errorf("total = %d after upserting the same name twice, want 1", total)
+	}
+	return nil
+}
+
+func testSearch(s models.ClassifierStore) error {
+	for _, c := range []struct{ name, description string }{
+		{"Countries", "ISO country codes"},
+		{"Currencies", "ISO 4217"},
+		{"Discounts", "up to 50% off"},
+		{"Sizes", ""},
+	} {
+		if _, err := s.Insert(ctx, c.name, c.description, nil); err != nil {
+			return fmt.Errorf("Insert %s: %w", c.name, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		search string
+		want   int
+	}{
+		{"iso", 2},     // description, case-insensitive
+		{"SIZES", 1},   // name, case-insensitive
+		{"50%", 1},     // the % is literal, not a wildcard
+		{"%", 1},       // same here
+		{"_", 0},       // and the _ too
+		{"nothing", 0}, // no matches is still a valid page
+	} {
+		got, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 1, PageSize: 10, Search: tc.search})
+		if err != nil {
+			return fmt.Errorf("List(Search: %q): %w", tc.search, err)
+		}
+		if total != tc.want || len(got) != tc.want {
+			return fmt.Errorf("Search %q: %d rows, total %d, want %d", tc.search, len(got), total, tc.want)
+		}
+	}
+	return nil
+}
+
+// testCancelledContext checks a cancelled request doesn't reach the database
+func testCancelledContext(s models.ClassifierStore) error {
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if _, err := s.Insert(cancelled, "Late", "", nil); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("Insert with a cancelled context: got %v, want context.Canceled", err)
+	}
+	if _, err := s.Get(cancelled, 1); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("Get with a cancelled context: got %v, want context.Canceled", err)
+	}
+	if _, _, err := s.List(cancelled, models.ListClassifiersOptions{Page: 1, PageSize: 10}); !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("List with a cancelled context: got %v, want context.Canceled", err)
+	}
+
+	_, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 1, PageSize: 10})
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if total != 0 {
+		return fmt.Errorf("the cancelled Insert stored a row anyway, total = %d", total)
+	}
+	return nil
+}
+
+func testUpdate(s models.ClassifierStore) error {
+	active := true
+	id, err := s.Insert(ctx, "Shapes", "round ones", &active)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	if _, err := s.Insert(ctx, "Taken", "", nil); err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+
+	description, inactive := "all of them", false
+	c, err := s.Update(ctx, id, models.ClassifierPatch{Description: &description, IsActive: &inactive})
+	if err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	if c.ID != id || c.Name != "Shapes" || c.Description.String != description || c.IsActive.Bool {
+		return fmt.Errorf("Update returned %+v, want the new description and is_active false, same name", c)
+	}
+	got, err := s.Get(ctx, id)
+	if err != nil {
+		return fmt.Errorf("Get(%d): %w", id, err)
+	}
+	if got.Description.String != description || !got.IsActive.Valid || got.IsActive.Bool {
+		return fmt.Errorf("Get after Update = %+v, want the new values", got)
+	}
+
+	// An empty description clears it, like on insert
+	empty := ""
+	if c, err = s.Update(ctx, id, models.ClassifierPatch{Description: &empty}); err != nil {
+		return fmt.Errorf("Update clearing the description: %w", err)
+	}
+	if c.Description.Valid {
+		return fmt.Errorf("empty description stored as %+v, want NULL", c.Description)
+	}
+
+	taken := "Taken"
+	if _, err := s.Update(ctx, id, models.ClassifierPatch{Name: &taken}); !errors.Is(err, models.ErrDuplicateName) {
+		return fmt.Errorf("Update to a taken name: got %v, want ErrDuplicateName", err)
+	}
+	renamed := "Figures"
+	if c, err = s.Update(ctx, id, models.ClassifierPatch{Name: &renamed}); err != nil || c.Name != renamed {
+		return fmt.Errorf("Update renaming: got %+v, %v", c, err)
+	}
+	if _, err := s.Update(ctx, 424242, models.ClassifierPatch{Name: &renamed}); !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("Update of a missing id: got %v, want ErrNoRecord", err)
+	}
+	return nil
+}
+
+func testDelete(s models.ClassifierStore) error {
+	id, err := s.Insert(ctx, "Doomed", "", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	if err := s.Delete(ctx, id); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	if _, err := s.Get(ctx, id); !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("Get after Delete: got %v, want ErrNoRecord", err)
+	}
+	if err := s.Delete(ctx, id); !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("second Delete: got %v, want ErrNoRecord", err)
+	}
+
+	_, total, err := s.List(ctx, models.ListClassifiersOptions{Page: 1, PageSize: 10})
+	if err != nil {
+		return fmt.Errorf("List: %w", err)
+	}
+	if total != 0 {
+		return fmt.Errorf("total = %d after deleting the only classifier, want 0", total)
+	}
+
+	// The name is free again
+	if _, err := s.Insert(ctx, "Doomed", "", nil); err != nil {
+		return fmt.Errorf("Insert with a deleted classifier's name: %w", err)
+	}
+	return nil
+}
+
+// testHistory walks a classifier through its whole life and checks every version
+func testHistory(s models.ClassifierStore) error {
+	actorCtx := models.WithRequestID(models.WithActor(ctx, "ana"), "req-1")
+
+	id, err := s.Insert(actorCtx, "Tracked", "v1", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	description := "v2"
+	if _, err := s.Update(ctx, id, models.ClassifierPatch{Description: &description}); err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	// Same values, nothing to record
+	if _, err := s.Upsert(ctx, "Tracked", "v2", nil); err != nil {
+		return fmt.Errorf("Upsert: %w", err)
+	}
+	active := true
+	if _, err := s.Upsert(ctx, "Tracked", "v3", &active); err != nil {
+		return fmt.Errorf("Upsert: %w", err)
+	}
+	if err := s.Delete(ctx, id); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+
+	entries, total, err := s.History(ctx, id, models.HistoryOptions{Page: 1, PageSize: 10})
+	if err != nil {
+		return fmt.Errorf("History: %w", err)
+	}
+	wantActions := []models.AuditAction{models.AuditDeleted, models.AuditUpdated, models.AuditUpdated, models.AuditCreated}
+	if total != len(wantActions) || len(entries) != len(wantActions) {
+		return fmt.Errorf("History has %d entries, total %d, want %d", len(entries), total, len(wantActions))
+	}
+	for i, e := range entries {
+		if e.ClassifierID != id || e.Version != len(wantActions)-i || e.Action != wantActions[i] {
+			return fmt.Errorf("entry %d = {id:%d version:%d action:%s}, want {id:%d version:%d action:%s}",
+				i, e.ClassifierID, e.Version, e.Action, id, len(wantActions)-i, wantActions[i])
+		}
+		if e.CreatedAt.IsZero() {
+			return fmt.Errorf("entry %d has no created_at", i)
+		}
+	}
+
+	created, deleted := entries[3], entries[0]
+	if created.Before != nil || created.After == nil || created.After.Name != "Tracked" || *created.After.Description != "v1" {
+		return fmt.Errorf("the create entry has before %+v and after %+v", created.Before, created.After)
+	}
+	if created.Actor != "ana" || created.RequestID != "req-1" {
+		return fmt.Errorf("the create entry says actor %q and request %q, want ana and req-1", created.Actor, created.RequestID)
+	}
+	if deleted.After != nil || deleted.Before == nil || *deleted.Before.Description != "v3" {
+		return fmt.Errorf("the delete entry has before %+v and after %+v", deleted.Before, deleted.After)
+	}
+	if changes := entries[1].Changes; len(changes) != 2 {
+		return fmt.Errorf("the second upsert changed %+v, want description and is_active", changes)
+	}
+
+	page, total, err := s.History(ctx, id, models.HistoryOptions{Page: 2, PageSize: 3})
+	if err != nil {
+		return fmt.Errorf("History page 2: %w", err)
+	}
+	if total != 4 || len(page) != 1 || page[0].Version != 1 {
+		return fmt.Errorf("History page 2 of 3: %d entries, total %d, want just version 1 of 4", len(page), total)
+	}
+
+	v2, err := s.GetVersion(ctx, id, 2)
+	if err != nil {
+		return fmt.Errorf("GetVersion(2): %w", err)
+	}
+	if v2.Action != models.AuditUpdated || *v2.After.Description != "v2" {
+		return fmt.Errorf("GetVersion(2) = %+v, want the update to v2", v2)
+	}
+	if _, err := s.GetVersion(ctx, id, 5); !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("GetVersion of a version that isn't there: got %v, want ErrNoRecord", err)
+	}
+
+	entries, total, err = s.History(ctx, 424242, models.HistoryOptions{})
+	if err != nil {
+		return fmt.Errorf("History of a missing id: %w", err)
+	}
+	if entries == nil || len(entries) != 0 || total != 0 {
+		return fmt.Errorf("History of a missing id: %v, total %d, want an empty page", entries, total)
+	}
+	return nil
+}
+
+// testAsOf reads the past. Versions are dated to the second, so it waits one out
+// between the old state and the new one
+func testAsOf(s models.ClassifierStore) error {
+	first, err := s.Insert(ctx, "Past", "v1", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	second, err := s.Insert(ctx, "Gone", "", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	created, err := s.GetVersion(ctx, second, 1)
+	if err != nil {
+		return fmt.Errorf("GetVersion: %w", err)
+	}
+	then := created.CreatedAt
+
+	time.Sleep(1100 * time.Millisecond)
+	description := "v2"
+	if _, err := s.Update(ctx, first, models.ClassifierPatch{Description: &description}); err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+	if err := s.Delete(ctx, second); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	third, err := s.Insert(ctx, "New", "", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	later := time.Now().Add(time.Hour)
+
+	c, err := s.GetAsOf(ctx, first, then)
+	if err != nil {
+		return fmt.Errorf("GetAsOf(then): %w", err)
+	}
+	if c.ID != first || c.Name != "Past" || c.Description.String != "v1" || c.CreatedAt.IsZero() {
+		return fmt.Errorf("GetAsOf(then) = %+v, want the v1 description", c)
+	}
+	if c, err = s.GetAsOf(ctx, first, later); err != nil || c.Description.String != "v2" {
+		return fmt.Errorf("GetAsOf(later) = %+v, %v, want the v2 description", c, err)
+	}
+	if _, err := s.GetAsOf(ctx, first, then.Add(-time.Hour)); !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("GetAsOf before it existed: got %v, want ErrNoRecord", err)
+	}
+	if _, err := s.GetAsOf(ctx, second, then); err != nil {
+		return fmt.Errorf("GetAsOf(then) of the deleted one: %w", err)
+	}
+	if _, err := s.GetAsOf(ctx, second, later); !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("GetAsOf after the delete: got %v, want ErrNoRecord", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		at   time.Time
+		want []int64
+	}{
+		{"then", then, []int64{second, first}},
+		{"later", later, []int64{third, first}},
+		{"before anything", then.Add(-time.Hour), []int64{}},
+	} {
+		got, total, err := s.ListAsOf(ctx, models.AsOfOptions{At: tc.at, Page: 1, PageSize: 10})
+		if err != nil {
+			return fmt.Errorf("ListAsOf(%s): %w", tc.name, err)
+		}
+		if got == nil || total != len(tc.want) || len(got) != len(tc.want) {
+			return fmt.Errorf("ListAsOf(%s) = %d rows, total %d, want %d", tc.name, len(got), total, len(tc.want))
+		}
+		for i, c := range got {
+			if c.ID != tc.want[i] {
+				return fmt.Errorf("ListAsOf(%s) row %d has id %d, want %d", tc.name, i, c.ID, tc.want[i])
+			}
+		}
+	}
+
+	page, total, err := s.ListAsOf(ctx, models.AsOfOptions{At: later, Page: 2, PageSize: 1})
+	if err != nil {
+		return fmt.Errorf("ListAsOf page 2: %w", err)
+	}
+	if total != 2 || len(page) != 1 || page[0].ID != first {
+		return fmt.Errorf("ListAsOf page 2 of 1: %d rows, total %d, want just id %d", len(page), total, first)
+	}
+	return nil
+}
+
+func testRevert(s models.ClassifierStore) error {
+	id, err := s.Insert(ctx, "Undo", "a", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	description := "b"
+	if _, err := s.Update(ctx, id, models.ClassifierPatch{Description: &description}); err != nil {
+		return fmt.Errorf("Update: %w", err)
+	}
+
+	c, err := s.Revert(ctx, id, 1)
+	if err != nil {
+		return fmt.Errorf("Revert(1): %w", err)
+	}
+	if c.ID != id || c.Description.String != "a" {
+		return fmt.Errorf("Revert(1) = %+v, want description a", c)
+	}
+	if c, err = s.Get(ctx, id); err != nil || c.Description.String != "a" {
+		return fmt.Errorf("Get after Revert = %+v, %v, want description a", c, err)
+	}
+	// Once more changes nothing, and records nothing
+	if _, err := s.Revert(ctx, id, 1); err != nil {
+		return fmt.Errorf("second Revert(1): %w", err)
+	}
+	entries, total, err := s.History(ctx, id, models.HistoryOptions{Page: 1, PageSize: 10})
+	if err != nil {
+		return fmt.Errorf("History: %w", err)
+	}
+	if total != 3 || entries[0].Action != models.AuditReverted || entries[0].Version != 3 {
+		return fmt.Errorf("after two reverts the history has %d versions, the last one %+v, want 3 ending in a revert", total, entries[0])
+	}
+
+	if err := s.Delete(ctx, id); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	if _, err := s.Revert(ctx, id, 4); !errors.Is(err, models.ErrDeletedVersion) {
+		return fmt.Errorf("Revert to the delete: got %v, want ErrDeletedVersion", err)
+	}
+	if _, err := s.Revert(ctx, id, 99); !errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("Revert to a version that isn't there: got %v, want ErrNoRecord", err)
+	}
+
+	// A deleted classifier comes back with its id, unless someone took the name
+	squatter, err := s.Insert(ctx, "Undo", "", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	if _, err := s.Revert(ctx, id, 2); !errors.Is(err, models.ErrDuplicateName) {
+		return fmt.Errorf("Revert with the name taken: got %v, want ErrDuplicateName", err)
+	}
+	if err := s.Delete(ctx, squatter); err != nil {
+		return fmt.Errorf("Delete: %w", err)
+	}
+	if c, err = s.Revert(ctx, id, 2); err != nil || c.ID != id || c.Description.String != "b" {
+		return fmt.Errorf("Revert of the deleted classifier = %+v, %v, want id %d with description b", c, err, id)
+	}
+	if c, err = s.Get(ctx, id); err != nil || c.Name != "Undo" {
+		return fmt.Errorf("Get after bringing it back = %+v, %v", c, err)
+	}
+	return nil
+}
+
+func insertN(s models.ClassifierStore, n int) ([]int64, error) {
+	ids := make([]int64, 0, n)
+	for i := range n {
+		id, err := s.Insert(ctx, fmt.Sprintf("classifier-%d", i), "", nil)
+		if err != nil {
+			return nil, fmt.Errorf("Insert %d: %w", i, err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// testExport checks the export goes through every row in the list's order, with the
+// search applied, and stops as soon as fn says so
+func testExport(s models.ClassifierStore) error {
+	var want []int64
+	for _, name := range []string{"Countries", "Currencies", "Sizes"} {
+		id, err := s.Insert(ctx, name, "", nil)
+		if err != nil {
+			return fmt.Errorf("Insert %s: %w", name, err)
+		}
+		want = append([]int64{id}, want...) // newest first, like the list
+	}
+
+	var got []int64
+	err := s.Export(ctx, models.ExportOptions{}, func(c *models.Classifier) error {
+		got = append(got, c.ID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Export: %w", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		return fmt.Errorf("Export ids %v, want %v", got, want)
+	}
+
+	got = nil
+	err = s.Export(ctx, models.ExportOptions{Search: "CUR"}, func(c *models.Classifier) error {
+		got = append(got, c.ID)
+		return nil
+	})
+	if err != nil || len(got) != 1 || got[0] != want[1] {
+		return fmt.Errorf("Export(Search: CUR) = %v, %v, want [%d]", got, err, want[1])
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = s.Export(ctx, models.ExportOptions{}, func(c *models.Classifier) error {
+		calls++
+		return stop
+	})
+	if !errors.Is(err, stop) || calls != 1 {
+		return fmt.Errorf("Export stopped by fn: got %v after %d calls, want the fn error after 1", err, calls)
+	}
+	return nil
+}
+
+// testImport checks the conflict strategies, that a failed batch leaves nothing behind
+// and that a dry run reports without writing
+func testImport(s models.ClassifierStore) error {
+	existing, err := s.Insert(ctx, "Colors", "old", nil)
+	if err != nil {
+		return fmt.Errorf("Insert: %w", err)
+	}
+	active := true
+	rows := []models.ImportRow{
+		{Name: "Sizes", Description: "S, M, L"},
+		{Name: "Colors", Description: "new", IsActive: &active},
+	}
+	actions := func(outcomes []models.ImportOutcome) []models.ImportAction {
+		var got []models.ImportAction
+		for _, o := range outcomes {
+			got = append(got, o.Action)
+		}
+		return got
+	}
+
+	// fail: the whole batch goes, Sizes included
+	_, err = s.Import(ctx, rows, models.ImportOptions{OnConflict: models.ConflictFail})
+	var conflict *models.ImportConflictError
+	if !errors.As(err, &conflict) || conflict.Row != 1 || !errors.Is(err, models.ErrDuplicateName) {
+		return fmt.Errorf("Import(fail): got %v, want an ImportConflictError on row 1", err)
+	}
+	if _, total, _ := s.List(ctx, models.ListClassifiersOptions{}); total != 1 {
+		return fmt.Errorf("after a failed batch there are %d classifiers, want 1", total)
+	}
+
+	// A dry run says what would happen and writes nothing
+	outcomes, err := s.Import(ctx, rows, models.ImportOptions{OnConflict: models.ConflictFail, DryRun: true})
+	if err != nil || fmt.Sprint(actions(outcomes)) != "[created conflict]" || outcomes[1].ID != existing {
+		return fmt.Errorf("Import(fail, dry run) = %+v, %v, want created and a conflict on %d", outcomes, err, existing)
+	}
+	outcomes, err = s.Import(ctx, rows, models.ImportOptions{OnConflict: models.ConflictOverwrite, DryRun: true})
+	if err != nil || fmt.Sprint(actions(outcomes)) != "[created updated]" {
+		return fmt.Errorf("Import(overwrite, dry run) = %+v, %v, want created and updated", outcomes, err)
+	}
+	if _, total, _ := s.List(ctx, models.ListClassifiersOptions{}); total != 1 {
+		return fmt.Errorf("after a dry run there are %d classifiers, want 1", total)
+	}
+
+	// skip leaves Colors alone
+	outcomes, err = s.Import(ctx, rows, models.ImportOptions{OnConflict: models.ConflictSkip})
+	if err != nil || fmt.Sprint(actions(outcomes)) != "[created skipped]" || outcomes[0].ID < 1 || outcomes[1].ID != existing {
+		return fmt.Errorf("Import(skip) = %+v, %v, want created and skipped", outcomes, err)
+	}
+	if c, err := s.Get(ctx, existing); err != nil || c.Description.String != "old" {
+		return fmt.Errorf("Get after skip = %+v, %v, want the old description", c, err)
+	}
+
+	// overwrite replaces it, and a second go changes nothing
+	outcomes, err = s.Import(ctx, rows, models.ImportOptions{OnConflict: models.ConflictOverwrite})
+	if err != nil || fmt.Sprint(actions(outcomes)) != "[unchanged updated]" {
+		return fmt.Errorf("Import(overwrite) = %+v, %v, want unchanged and updated", outcomes, err)
+	}
+	if c, err := s.Get(ctx, existing); err != nil || c.Description.String != "new" || !c.IsActive.Bool {
+		return fmt.Errorf("Get after overwrite = %+v, %v, want the new description", c, err)
+	}
+	if _, total, _ := s.History(ctx, existing, models.HistoryOptions{}); total != 2 {
+		return fmt.Errorf("Colors has %d versions after the overwrite, want 2", total)
+	}
+	outcomes, err = s.Import(ctx, rows, models.ImportOptions{OnConflict: models.ConflictOverwrite})
+	if err != nil || fmt.Sprint(actions(outcomes)) != "[unchanged unchanged]" {
+		return fmt.Errorf("Import(overwrite) again = %+v, %v, want unchanged twice", outcomes, err)
+	}
+	return nil
+}
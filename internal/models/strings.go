@@ -4,12 +4,10 @@ import (
 	"strings"
 )
 
-// Preallocate buffer for crear cache keys
-var keyBuilder strings.Builder
-
 // makeCacheKey constructs cache keys efficiently
+// The builder is local on purpose: a shared one races as soon as two requests build keys at once
 func makeCacheKey(parts ...string) string {
-	keyBuilder.Reset()
+	var keyBuilder strings.Builder
 	keyBuilder.Grow(64) // Preallocate typical key size
 
 	for i, part := range parts {
@@ -0,0 +1,635 @@
+package models
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Webhook is a subscription to the change events, POSTed to URL as they happen
+type Webhook struct {
+	ID         int64       `json:"id"`
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types"` // empty means every event
+	// Secret signs the deliveries. We only hand it out when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// wants says whether the webhook is subscribed to that kind of event
+func (w *Webhook) wants(t EventType) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, want := range w.EventTypes {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookInput is a new subscription. Without a Secret we generate one
+type WebhookInput struct {
+	URL        string
+	EventTypes []EventType
+	Secret     string
+}
+
+// DeliveryStatus is where a delivery is: pending until the receiver answers 2xx,
+// dead once it ran out of attempts
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is one event for one webhook, with how sending it went
+type WebhookDelivery struct {
+	ID            int64          `json:"id"`
+	WebhookID     int64          `json:"webhook_id"`
+	EventID       int64          `json:"event_id"`
+	EventType     EventType      `json:"event_type"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt *time.Time     `json:"next_attempt_at,omitempty"` // only while pending
+	LastStatus    *int           `json:"last_status"`               // HTTP status of the last attempt, nil if it got none
+	LastError     string         `json:"last_error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+}
+
+// DeliveryOptions pages through a webhook's deliveries, newest first
+type DeliveryOptions struct {
+	Status   DeliveryStatus // empty for all of them
+	Page     int
+	PageSize int
+}
+
+// WebhookModel keeps the subscriptions and their deliveries
+// It's also the EventPublisher that turns each change event into deliveries
+type WebhookModel struct {
+	DB      *sql.DB
+	queries dialectQueries
+}
+
+var _ EventPublisher = (*WebhookModel)(nil)
+
+func NewWebhookModel(db *sql.DB, d Dialect) (*WebhookModel, error) {
+	queries, err := d.queries()
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookModel{DB: db, queries: queries}, nil
+}
+
+// Insert saves the subscription. The Webhook returned is the only one with the secret
+func (m *WebhookModel) Insert(ctx context.Context, in WebhookInput) (*Webhook, error) {
+	secret := in.Secret
+	if secret == "" {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(raw)
+	}
+
+	id, err := m.queries.insertReturningID(ctx, m.DB, m.queries.insertWebhook, in.URL, secret, joinEventTypes(in.EventTypes))
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	webhook, err := m.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	webhook.Secret = secret
+	return webhook, nil
+}
+
+func (m *WebhookModel) Get(ctx context.Context, id int64) (*Webhook, error) {
+	webhook, err := scanWebhook(m.DB.QueryRowContext(ctx, m.queries.getWebhook, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, contextError(ctx, err)
+	}
+	return webhook, nil
+}
+
+// List is every subscription, oldest first. There won't be many
+func (m *WebhookModel) List(ctx context.Context) ([]*Webhook, error) {
+	rows, err := m.DB.QueryContext(ctx, m.queries.listWebhooks)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return webhooks, nil
+}
+
+// Delete drops the subscription and its deliveries, pending ones included
+func (m *WebhookModel) Delete(ctx context.Context, id int64) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, m.queries.deleteWebhook, id)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNoRecord
+	}
+	if _, err := tx.ExecContext(ctx, m.queries.deleteDeliveries, id); err != nil {
+		return contextError(ctx, err)
+	}
+	return contextError(ctx, tx.Commit())
+}
+
+// Deliveries is the delivery log of a webhook, newest first
+func (m *WebhookModel) Deliveries(ctx context.Context, webhookID int64, opts DeliveryOptions) ([]*WebhookDelivery, int, error) {
+	list := ListClassifiersOptions{Page: opts.Page, PageSize: opts.PageSize}.normalize()
+	status := string(opts.Status)
+
+	var total int
+	if err := m.DB.QueryRowContext(ctx, m.queries.countDeliveries, webhookID, status, status).Scan(&total); err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+
+	offset := (list.Page - 1) * list.PageSize
+	rows, err := m.DB.QueryContext(ctx, m.queries.listDeliveries, webhookID, status, status, list.PageSize, offset)
+	if err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]*WebhookDelivery, 0, list.PageSize)
+	for rows.Next() {
+		var d WebhookDelivery
+		var nextAttempt, deliveredAt sql.NullTime
+		var lastStatus sql.NullInt64
+		var lastError sql.NullString
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Status, &d.Attempts, &nextAttempt,
+			&lastStatus, &lastError, &d.CreatedAt, &deliveredAt)
+		if err != nil {
+			return nil, 0, contextError(ctx, err)
+		}
+		if nextAttempt.Valid && d.Status == DeliveryPending {
+			d.NextAttemptAt = &nextAttempt.Time
+		}
+		if lastStatus.Valid {
+			code := int(lastStatus.Int64)
+			d.LastStatus = &code
+		}
+		if deliveredAt.Valid {
+			d.DeliveredAt = &deliveredAt.Time
+		}
+		d.LastError = lastError.String
+		deliveries = append(deliveries, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, contextError(ctx, err)
+	}
+	return deliveries, total, nil
+}
+
+// RetryDelivery gives a dead delivery a fresh set of attempts, starting now
+// ErrNoRecord if there's no dead delivery with that id on the webhook
+func (m *WebhookModel) RetryDelivery(ctx context.Context, webhookID, deliveryID int64) error {
+	result, err := m.DB.ExecContext(ctx, m.queries.retryDelivery, time.Now().UTC(), deliveryID, webhookID)
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Publish queues a delivery of the event for every webhook subscribed to it
+// The outbox can hand us the same event twice; the second time is a no-op
+func (m *WebhookModel) Publish(ctx context.Context, event ChangeEvent) error {
+	webhooks, err := m.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	var payload []byte
+	for _, webhook := range webhooks {
+		if !webhook.wants(event.Type) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+		_, err := m.DB.ExecContext(ctx, m.queries.insertDelivery,
+			webhook.ID, event.ID, string(event.Type), string(payload), time.Now().UTC())
+		if err != nil {
+			return contextError(ctx, err)
+		}
+	}
+	return nil
+}
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var w Webhook
+	var eventTypes string
+	if err := row.Scan(&w.ID, &w.URL, &eventTypes, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	w.EventTypes = splitEventTypes(eventTypes)
+	return &w, nil
+}
+
+// The event types go in one column, comma separated: there are only three
+func joinEventTypes(types []EventType) string {
+	parts := make([]string, len(types))
+	for i, t := range types {
+		parts[i] = string(t)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitEventTypes(s string) []EventType {
+	types := []EventType{}
+	if s == "" {
+		return types
+	}
+	for _, part := range strings.Split(s, ",") {
+		types = append(types, EventType(part))
+	}
+	return types
+}
+
+// Signatures go in the X-Webhook-Signature header as "sha256=<hex>", an HMAC-SHA256
+// of "<timestamp>.<body>" keyed with the webhook's secret. The timestamp (unix seconds)
+// goes in X-Webhook-Timestamp, so receivers can turn down old replays
+const (
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookTimestampHeader = "X-Webhook-Timestamp"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// SignWebhook is the X-Webhook-Signature value for a body sent at timestamp
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks a delivery's signature, in constant time, for the receivers
+func VerifyWebhook(secret, signature string, timestamp int64, body []byte) bool {
+	return hmac.Equal([]byte(signature), []byte(SignWebhook(secret, timestamp, body)))
+}
+
+// WebhookOptions tunes the webhook dispatcher, zero values get the defaults
+type WebhookOptions struct {
+	// Client sends the deliveries. By default one that only connects to public
+	// addresses, or http.DefaultClient with AllowPrivate
+	Client       *http.Client
+	AllowPrivate bool          // deliveries to loopback and private ranges too, for in-house receivers
+	Timeout      time.Duration // per delivery attempt, 10s by default
+	PollInterval time.Duration // how often we look for due deliveries, 1s by default
+	BatchSize    int           // deliveries per query, 100 by default
+	MaxAttempts  int           // after this many failures the delivery is dead, 8 by default
+	BaseDelay    time.Duration // first retry, 10s by default, doubles each time
+	MaxDelay     time.Duration // retry cap, 1h by default
+	Retention    time.Duration // delivered ones are deleted after this, 7 days by default
+	// OnFailure hears about every failed attempt; delivery.Status is dead on the last one
+	OnFailure func(delivery WebhookDelivery, err error)
+}
+
+// WebhookStats is the dispatcher's state for the metrics endpoint
+type WebhookStats struct {
+	Running   bool
+	Delivered int64 // deliveries that got a 2xx since start
+	Failed    int64 // failed attempts since start
+	Dead      int64 // deliveries that ran out of attempts since start
+	// LastError goes out on the public metrics, so it names the webhook by id and
+	// never has its URL: the path or query string can carry a token
+	LastError string
+}
+
+// WebhookDispatcher sends the due deliveries, signed, retrying with exponential
+// backoff until MaxAttempts. Each webhook gets its own goroutine per batch, and its
+// run stops at the first failure or once it has used up Timeout, so a dead or slow
+// receiver holds up the rest one Timeout per pass at most. Like the outbox, one pod
+// at a time
+type WebhookDispatcher struct {
+	db      *sql.DB
+	queries dialectQueries
+	opts    WebhookOptions
+	loop    *pollLoop
+
+	delivered atomic.Int64
+	failed    atomic.Int64
+	dead      atomic.Int64
+	lastErr   atomic.Value // string
+	lastPrune time.Time    // only touched by the loop
+}
+
+const webhookLockName = "classifiers.webhooks"
+
+func NewWebhookDispatcher(db *sql.DB, d Dialect, opts WebhookOptions) (*WebhookDispatcher, error) {
+	queries, err := d.queries()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Client == nil && opts.AllowPrivate {
+		opts.Client = http.DefaultClient
+	} else if opts.Client == nil {
+		opts.Client = publicOnlyClient()
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = time.Second
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 8
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 10 * time.Second
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = time.Hour
+	}
+	if opts.Retention <= 0 {
+		opts.Retention = 7 * 24 * time.Hour
+	}
+
+	return &WebhookDispatcher{
+		db:      db,
+		queries: queries,
+		opts:    opts,
+		loop:    newPollLoop(),
+	}, nil
+}
+
+// Start runs the dispatcher in the background until Stop
+func (d *WebhookDispatcher) Start() {
+	d.loop.start(d.opts.PollInterval, func() {
+		if err := d.dispatch(); err != nil && d.loop.ctx.Err() == nil {
+			d.lastErr.Store(err.Error())
+		}
+	})
+}
+
+// Stop waits for the requests in flight; if ctx runs out first they're cancelled
+// and go out again on the next start
+func (d *WebhookDispatcher) Stop(ctx context.Context) error {
+	return d.loop.stop(ctx)
+}
+
+// Stats is a snapshot of the counters
+func (d *WebhookDispatcher) Stats() WebhookStats {
+	lastErr, _ := d.lastErr.Load().(string)
+	return WebhookStats{
+		Running:   d.loop.running.Load(),
+		Delivered: d.delivered.Load(),
+		Failed:    d.failed.Load(),
+		Dead:      d.dead.Load(),
+		LastError: lastErr,
+	}
+}
+
+type dueDelivery struct {
+	WebhookDelivery
+	payload string
+	url     string
+	secret  string
+}
+
+func (d *WebhookDispatcher) dispatch() error {
+	conn, err := d.db.Conn(d.loop.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	release, got, err := d.queries.tryLock(d.loop.ctx, conn, webhookLockName)
+	if err != nil {
+		return fmt.Errorf("models: taking webhook lock: %w", err)
+	}
+	if !got {
+		return nil
+	}
+	defer release()
+
+	for !d.loop.stopping() {
+		due, err := d.due(conn)
+		if err != nil {
+			return err
+		}
+		// Rows left behind are still due, asking again now would just send them to
+		// the receiver we gave up on
+		if !d.deliverAll(due) || len(due) < d.opts.BatchSize {
+			break
+		}
+	}
+
+	if time.Since(d.lastPrune) > time.Minute {
+		d.lastPrune = time.Now()
+		cutoff := time.Now().Add(-d.opts.Retention).UTC()
+		if _, err := conn.ExecContext(d.loop.ctx, d.queries.pruneDeliveries, cutoff); err != nil {
+			return fmt.Errorf("models: pruning webhook deliveries: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d *WebhookDispatcher) due(conn *sql.Conn) ([]dueDelivery, error) {
+	rows, err := conn.QueryContext(d.loop.ctx, d.queries.dueDeliveries, time.Now().UTC(), d.opts.BatchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []dueDelivery
+	for rows.Next() {
+		var row dueDelivery
+		err := rows.Scan(&row.ID, &row.WebhookID, &row.EventID, &row.EventType, &row.payload, &row.Attempts,
+			&row.url, &row.secret)
+		if err != nil {
+			return nil, err
+		}
+		row.Status = DeliveryPending
+		due = append(due, row)
+	}
+	return due, rows.Err()
+}
+
+// deliverAll sends a batch, one goroutine per webhook and in id order within each.
+// A webhook's run stops at its first failure, or before its next row once Timeout has
+// gone by, and what's left waits for the next poll. It reports whether every row went
+func (d *WebhookDispatcher) deliverAll(due []dueDelivery) bool {
+	byWebhook := make(map[int64][]dueDelivery)
+	for _, row := range due {
+		byWebhook[row.WebhookID] = append(byWebhook[row.WebhookID], row)
+	}
+
+	deadline := time.Now().Add(d.opts.Timeout)
+	var wg sync.WaitGroup
+	var leftBehind atomic.Bool
+	for _, rows := range byWebhook {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, row := range rows {
+				if d.loop.stopping() {
+					return
+				}
+				if i > 0 && time.Now().After(deadline) {
+					leftBehind.Store(true)
+					return
+				}
+				sent, err := d.deliver(row)
+				if err != nil && d.loop.ctx.Err() == nil {
+					d.lastErr.Store(err.Error())
+				}
+				if !sent {
+					if i < len(rows)-1 {
+						leftBehind.Store(true)
+					}
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return !leftBehind.Load()
+}
+
+// deliver makes one attempt and records how it went. sent is whether the receiver
+// took it, err is about recording it
+func (d *WebhookDispatcher) deliver(row dueDelivery) (sent bool, err error) {
+	code, sendErr := d.send(row)
+	if sendErr == nil {
+		d.delivered.Add(1) // before the write, so whoever sees it delivered sees it counted
+		_, err := d.db.ExecContext(d.loop.ctx, d.queries.markDelivered, code, time.Now().UTC(), row.ID)
+		return true, err
+	}
+	if d.loop.ctx.Err() != nil {
+		return false, nil // cut short by Stop, it's still pending and the attempt doesn't count
+	}
+
+	row.Attempts++
+	retryAt := time.Now().Add(doublingBackoff(d.opts.BaseDelay, d.opts.MaxDelay, row.Attempts-1)).UTC()
+	if row.Attempts >= d.opts.MaxAttempts {
+		row.Status = DeliveryDead
+		d.dead.Add(1)
+	}
+	d.failed.Add(1)
+	d.lastErr.Store(statsError(row.WebhookID, sendErr))
+	if row.Status == DeliveryPending {
+		row.NextAttemptAt = &retryAt
+	}
+	if code != 0 {
+		row.LastStatus = &code
+	}
+	row.LastError = sendErr.Error()
+	if d.opts.OnFailure != nil {
+		d.opts.OnFailure(row.WebhookDelivery, sendErr)
+	}
+
+	var lastStatus sql.NullInt64
+	if code != 0 {
+		lastStatus = sql.NullInt64{Int64: int64(code), Valid: true}
+	}
+	_, err = d.db.ExecContext(d.loop.ctx, d.queries.markDeliveryFailed,
+		string(row.Status), retryAt, lastStatus, sendErr.Error(), row.ID)
+	return false, err
+}
+
+// statsError is sendErr for LastError. A *url.Error quotes the whole URL, so only
+// what's inside it goes; the delivery log, which is admin-only, keeps everything
+func statsError(webhookID int64, sendErr error) string {
+	var urlErr *url.Error
+	if errors.As(sendErr, &urlErr) {
+		sendErr = urlErr.Err
+	}
+	return fmt.Sprintf("webhook %d: %v", webhookID, sendErr)
+}
+
+// send POSTs the event, signed. code is 0 when there was no response at all
+func (d *WebhookDispatcher) send(row dueDelivery) (code int, err error) {
+	ctx, cancel := context.WithTimeout(d.loop.ctx, d.opts.Timeout)
+	defer cancel()
+
+	if !d.opts.AllowPrivate {
+		if err := CheckWebhookTarget(ctx, row.url); err != nil {
+			return 0, err
+		}
+	}
+
+	body := []byte(row.payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, row.url, strings.NewReader(row.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "classifier-webhooks/1")
+	req.Header.Set(WebhookEventHeader, string(row.EventType))
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(row.ID, 10))
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(row.secret, timestamp, body))
+
+	resp, err := d.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	// Drain a bit of the body so the connection goes back to the pool
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,363 @@
+package models_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"classifier.buhtigexa.net/internal/models"
+)
+
+const testWebhookSecret = "s3cret"
+
+// receiver is an httptest webhook endpoint that answers statuses in order,
+// repeating the last one, and keeps what it got
+type receiver struct {
+	t        *testing.T
+	statuses []int
+
+	mu   sync.Mutex
+	hits []receivedHook
+}
+
+type receivedHook struct {
+	at        time.Time
+	event     models.ChangeEvent
+	signedOK  bool
+	eventType string
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		rc.t.Errorf("reading the delivery: %v", err)
+	}
+	timestamp, _ := strconv.ParseInt(r.Header.Get(models.WebhookTimestampHeader), 10, 64)
+	hit := receivedHook{
+		at:        time.Now(),
+		signedOK:  models.VerifyWebhook(testWebhookSecret, r.Header.Get(models.WebhookSignatureHeader), timestamp, body),
+		eventType: r.Header.Get(models.WebhookEventHeader),
+	}
+	if err := json.Unmarshal(body, &hit.event); err != nil {
+		rc.t.Errorf("delivery body isn't a change event: %v", err)
+	}
+
+	rc.mu.Lock()
+	rc.hits = append(rc.hits, hit)
+	status := rc.statuses[min(len(rc.hits), len(rc.statuses))-1]
+	rc.mu.Unlock()
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) received() []receivedHook {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]receivedHook(nil), rc.hits...)
+}
+
+// startWebhook registers srv as a webhook on a fresh SQLite database and queues
+// one created event for it
+func startWebhook(t *testing.T, srv *httptest.Server) (*sql.DB, *models.WebhookModel, *models.Webhook) {
+	t.Helper()
+	return startWebhookAt(t, srv.URL)
+}
+
+// startWebhookAt is startWebhook for any URL
+func startWebhookAt(t *testing.T, target string) (*sql.DB, *models.WebhookModel, *models.Webhook) {
+	t.Helper()
+	dsn := "file:" + filepath.Join(t.TempDir(), "webhooks.db") + "?_busy_timeout=5000&_foreign_keys=on&_txlock=immediate"
+	db, err := openSQLDB(t, models.SQLite, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := models.NewWebhookModel(db, models.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	hook, err := webhooks.Insert(ctx, models.WebhookInput{URL: target, Secret: testWebhookSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := models.ChangeEvent{ID: 1, Type: models.EventCreated, ClassifierID: 7, Version: 1, Actor: "test",
+		OccurredAt: time.Now().UTC()}
+	if err := webhooks.Publish(ctx, event); err != nil {
+		t.Fatal(err)
+	}
+	return db, webhooks, hook
+}
+
+// startDispatcher runs a fast dispatcher for the test's lifetime
+func startDispatcher(t *testing.T, db *sql.DB, opts models.WebhookOptions) *models.WebhookDispatcher {
+	t.Helper()
+	opts.PollInterval = 10 * time.Millisecond
+	opts.BaseDelay = 50 * time.Millisecond
+	opts.MaxDelay = 200 * time.Millisecond
+	dispatcher, err := models.NewWebhookDispatcher(db, models.SQLite, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dispatcher.Start()
+	t.Cleanup(func() { dispatcher.Stop(context.Background()) })
+	return dispatcher
+}
+
+// waitDelivery polls until the only delivery leaves pending
+func waitDelivery(t *testing.T, webhooks *models.WebhookModel, hook *models.Webhook) *models.WebhookDelivery {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		deliveries, _, err := webhooks.Deliveries(context.Background(), hook.ID, models.DeliveryOptions{PageSize: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 {
+			t.Fatalf("got %d deliveries, want 1", len(deliveries))
+		}
+		if deliveries[0].Status != models.DeliveryPending {
+			return deliveries[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("delivery still pending after %d attempts", deliveries[0].Attempts)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWebhookDispatcherRetriesUntilDelivered(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{http.StatusServiceUnavailable, http.StatusInternalServerError, http.StatusOK}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	db, webhooks, hook := startWebhook(t, srv)
+	dispatcher := startDispatcher(t, db, models.WebhookOptions{AllowPrivate: true, MaxAttempts: 5})
+
+	delivery := waitDelivery(t, webhooks, hook)
+	if delivery.Status != models.DeliveryDelivered {
+		t.Fatalf("status = %s, want delivered (last error %q)", delivery.Status, delivery.LastError)
+	}
+	if delivery.Attempts != 3 {
+		t.Errorf("attempts = %d, want 3", delivery.Attempts)
+	}
+	if delivery.LastStatus == nil || *delivery.LastStatus != http.StatusOK {
+		t.Errorf("last status = %v, want 200", delivery.LastStatus)
+	}
+
+	hits := rc.received()
+	if len(hits) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(hits))
+	}
+	for i, hit := range hits {
+		if !hit.signedOK {
+			t.Errorf("request %d: signature doesn't verify", i)
+		}
+		if hit.eventType != string(models.EventCreated) || hit.event.ID != 1 || hit.event.ClassifierID != 7 {
+			t.Errorf("request %d: got event %+v (%s)", i, hit.event, hit.eventType)
+		}
+	}
+	// Backoff doubles from BaseDelay: 50ms, then 100ms
+	if gap := hits[1].at.Sub(hits[0].at); gap < 50*time.Millisecond {
+		t.Errorf("first retry after %v, want at least 50ms", gap)
+	}
+	if gap := hits[2].at.Sub(hits[1].at); gap < 100*time.Millisecond {
+		t.Errorf("second retry after %v, want at least 100ms", gap)
+	}
+
+	stats := dispatcher.Stats()
+	if stats.Delivered != 1 || stats.Failed != 2 || stats.Dead != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestWebhookDispatcherDeadLetters(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{http.StatusBadGateway}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var failures []models.WebhookDelivery
+	db, webhooks, hook := startWebhook(t, srv)
+	dispatcher := startDispatcher(t, db, models.WebhookOptions{
+		AllowPrivate: true,
+		MaxAttempts:  3,
+		OnFailure: func(delivery models.WebhookDelivery, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			failures = append(failures, delivery)
+		},
+	})
+
+	delivery := waitDelivery(t, webhooks, hook)
+	if delivery.Status != models.DeliveryDead {
+		t.Fatalf("status = %s, want dead", delivery.Status)
+	}
+	if delivery.Attempts != 3 {
+		t.Errorf("attempts = %d, want 3", delivery.Attempts)
+	}
+	if delivery.LastStatus == nil || *delivery.LastStatus != http.StatusBadGateway {
+		t.Errorf("last status = %v, want 502", delivery.LastStatus)
+	}
+	if delivery.NextAttemptAt != nil {
+		t.Errorf("a dead delivery has next_attempt_at %v", delivery.NextAttemptAt)
+	}
+
+	// Dead means nobody tries again
+	time.Sleep(300 * time.Millisecond)
+	if hits := rc.received(); len(hits) != 3 {
+		t.Errorf("receiver got %d requests, want 3", len(hits))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(failures) != 3 {
+		t.Fatalf("OnFailure heard %d failures, want 3", len(failures))
+	}
+	for i, failure := range failures[:2] {
+		if failure.Status != models.DeliveryPending {
+			t.Errorf("failure %d: status = %s, want pending", i, failure.Status)
+		}
+	}
+	if failures[2].Status != models.DeliveryDead {
+		t.Errorf("last failure: status = %s, want dead", failures[2].Status)
+	}
+	if stats := dispatcher.Stats(); stats.Dead != 1 || stats.Failed != 3 || stats.Delivered != 0 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestWebhookDispatcherRefusesPrivateTargets(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{http.StatusOK}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	// httptest listens on loopback, which is what a DNS rebind would point at
+	db, webhooks, hook := startWebhook(t, srv)
+	startDispatcher(t, db, models.WebhookOptions{MaxAttempts: 1})
+
+	delivery := waitDelivery(t, webhooks, hook)
+	if delivery.Status != models.DeliveryDead {
+		t.Fatalf("status = %s, want dead", delivery.Status)
+	}
+	if delivery.LastStatus != nil {
+		t.Errorf("last status = %d, want none", *delivery.LastStatus)
+	}
+	if hits := rc.received(); len(hits) != 0 {
+		t.Errorf("receiver got %d requests, want none", len(hits))
+	}
+}
+
+func TestWebhookStatsHideTheURL(t *testing.T) {
+	// Nobody listening there, so the client fails with a *url.Error that quotes the URL
+	srv := httptest.NewServer(http.NotFoundHandler())
+	target := srv.URL + "/hooks?token=t0p-s3cret"
+	srv.Close()
+
+	db, webhooks, hook := startWebhookAt(t, target)
+	dispatcher := startDispatcher(t, db, models.WebhookOptions{AllowPrivate: true, MaxAttempts: 1})
+
+	delivery := waitDelivery(t, webhooks, hook)
+	if delivery.Status != models.DeliveryDead {
+		t.Fatalf("status = %s, want dead", delivery.Status)
+	}
+	lastErr := dispatcher.Stats().LastError
+	if strings.Contains(lastErr, "t0p-s3cret") || strings.Contains(lastErr, "/hooks") {
+		t.Errorf("LastError %q has the URL", lastErr)
+	}
+	if want := fmt.Sprintf("webhook %d: ", hook.ID); !strings.HasPrefix(lastErr, want) {
+		t.Errorf("LastError %q doesn't start with %q", lastErr, want)
+	}
+}
+
+func TestCheckWebhookTarget(t *testing.T) {
+	tests := []struct {
+		host   string
+		public bool
+	}{
+		{"8.8.8.8", true},
+		{"198.51.100.7", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"169.254.169.254", false},
+		{"0.1.2.3", false},
+		{"100.100.100.200", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"[::1]", false},
+		{"[ff02::1]", false},
+		{"[::ffff:127.0.0.1]", false},
+		{"[64:ff9b::808:808]", true},
+		{"[64:ff9b::7f00:1]", false},
+		{"[64:ff9b::a9fe:a9fe]", false},
+		{"[2002:808:808::1]", true},
+		{"[2002:a00:1::1]", false},
+		{"[2002:7f00:1::1]", false},
+	}
+	for _, tt := range tests {
+		err := models.CheckWebhookTarget(context.Background(), "https://"+tt.host+"/hook")
+		if tt.public && err != nil {
+			t.Errorf("%s: %v, want it allowed", tt.host, err)
+		}
+		if !tt.public && !errors.Is(err, models.ErrPrivateWebhookTarget) {
+			t.Errorf("%s: %v, want ErrPrivateWebhookTarget", tt.host, err)
+		}
+	}
+}
+
+func TestWebhookDispatcherStopsAtTheFirstFailure(t *testing.T) {
+	rc := &receiver{t: t, statuses: []int{http.StatusInternalServerError}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+
+	db, webhooks, hook := startWebhook(t, srv)
+	for id := int64(2); id <= 3; id++ {
+		event := models.ChangeEvent{ID: id, Type: models.EventUpdated, ClassifierID: 7, Version: int(id), Actor: "test",
+			OccurredAt: time.Now().UTC()}
+		if err := webhooks.Publish(context.Background(), event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	startDispatcher(t, db, models.WebhookOptions{AllowPrivate: true, MaxAttempts: 1})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		dead, _, err := webhooks.Deliveries(context.Background(), hook.ID,
+			models.DeliveryOptions{Status: models.DeliveryDead, PageSize: 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dead) == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d deliveries dead, want 3", len(dead))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hits := rc.received(); len(hits) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(hits))
+	}
+
+	// One failure per pass, so the next event waits for the next poll (10ms)
+	hits := rc.received()
+	for i := 1; i < len(hits); i++ {
+		if hits[i].event.ID != hits[i-1].event.ID+1 {
+			t.Errorf("request %d is event %d, after event %d", i, hits[i].event.ID, hits[i-1].event.ID)
+		}
+		if gap := hits[i].at.Sub(hits[i-1].at); gap < 5*time.Millisecond {
+			t.Errorf("request %d came %v after the failure, in the same pass", i, gap)
+		}
+	}
+}
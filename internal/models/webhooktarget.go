@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// CheckWebhookTarget resolves the host of a webhook URL and fails with
+// ErrPrivateWebhookTarget if any of its addresses isn't public, so nobody gets us to
+// POST signed payloads at our own network. The deliveries check again: what a name
+// resolves to can change after the webhook is registered
+func CheckWebhookTarget(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := target.Hostname()
+
+	var addrs []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		addrs = []netip.Addr{ip}
+	} else if addrs, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		return fmt.Errorf("resolving %s: %w", host, err)
+	}
+	for _, ip := range addrs {
+		if !isPublicAddr(ip) {
+			return fmt.Errorf("%w: %s is %s", ErrPrivateWebhookTarget, host, ip)
+		}
+	}
+	return nil
+}
+
+// deniedPrefixes are the ranges that aren't public but that netip has no Is* for
+var deniedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network", 0.x.x.x reaches the host on Linux
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT, and some clouds' internal ranges
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved, 255.255.255.255 included
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("100::/64"),       // discard-only
+}
+
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// isPublicAddr says whether a delivery may go to ip. NAT64 and 6to4 addresses carry
+// an IPv4 inside, and that one has to be public too
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+	for _, prefix := range deniedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+
+	b := ip.As16()
+	switch {
+	case nat64Prefix.Contains(ip):
+		return isPublicAddr(netip.AddrFrom4([4]byte(b[12:16])))
+	case sixToFour.Contains(ip):
+		return isPublicAddr(netip.AddrFrom4([4]byte(b[2:6])))
+	}
+	return true
+}
+
+// publicOnlyClient is the dispatcher's default client. Its dialer looks at the address
+// it's about to connect to, so a redirect or a DNS answer that changed since the check
+// can't take a delivery inside either. No proxy: it would hide where the request goes
+func publicOnlyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", ErrPrivateWebhookTarget, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Transport: transport}
+}
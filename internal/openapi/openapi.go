@@ -0,0 +1,139 @@
+// Package openapi has the pieces of an OpenAPI 3.1 document the service needs: the
+// document itself, JSON Schemas generated from Go types, and a validator that checks
+// a decoded JSON value against them.
+//
+// It's not a general purpose library. It covers the subset of OpenAPI and JSON
+// Schema the API uses, and nothing more.
+package openapi
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the OpenAPI version the documents say they follow
+const Version = "3.1.0"
+
+// Document is an OpenAPI document. Paths go from the path (/classifiers/{id}) to
+// its operations by lowercase method
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Servers    []Server            `json:"servers,omitempty"`
+	Tags       []Tag               `json:"tags,omitempty"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Server struct {
+	URL         string `json:"url"`
+	Description string `json:"description,omitempty"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem is the operations on one path, by lowercase method
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"` // path, query or header
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string                `json:"description,omitempty"`
+	Required    bool                  `json:"required,omitempty"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Headers     map[string]*Header    `json:"headers,omitempty"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type Header struct {
+	Description string  `json:"description,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Operation finds the operation for a ServeMux pattern like "GET /classifiers/{id}"
+func (d *Document) Operation(pattern string) *Operation {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		return nil
+	}
+	return d.Paths[path][strings.ToLower(method)]
+}
+
+// AddOperation puts op under a ServeMux pattern like "GET /classifiers/{id}"
+func (d *Document) AddOperation(pattern string, op *Operation) {
+	method, path, _ := strings.Cut(pattern, " ")
+	if d.Paths == nil {
+		d.Paths = make(map[string]PathItem)
+	}
+	if d.Paths[path] == nil {
+		d.Paths[path] = make(PathItem)
+	}
+	d.Paths[path][strings.ToLower(method)] = op
+}
+
+// Patterns lists the operations as ServeMux patterns, sorted
+func (d *Document) Patterns() []string {
+	var patterns []string
+	for path, item := range d.Paths {
+		for method := range item {
+			patterns = append(patterns, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// Response is the documented response for status, falling back on "default"
+func (op *Operation) Response(status int) *Response {
+	if res, ok := op.Responses[strconv.Itoa(status)]; ok {
+		return res
+	}
+	return op.Responses["default"]
+}
@@ -0,0 +1,250 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema is a JSON Schema (draft 2020-12, what OpenAPI 3.1 uses), the keywords we use
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 Types              `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+}
+
+// Types is the type keyword: one type, or several when it can also be null
+type Types []string
+
+func (t Types) MarshalJSON() ([]byte, error) {
+	if len(t) == 1 {
+		return json.Marshal(t[0])
+	}
+	return json.Marshal([]string(t))
+}
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = Types{one}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// Has says whether the schema allows that type
+func (t Types) Has(name string) bool {
+	for _, have := range t {
+		if have == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Nullable is s, or null
+func Nullable(s *Schema) *Schema {
+	if s.Ref != "" || len(s.Type) == 0 {
+		return &Schema{AnyOf: []*Schema{s, {Type: Types{"null"}}}}
+	}
+	nullable := *s
+	nullable.Type = append(append(Types{}, s.Type...), "null")
+	return &nullable
+}
+
+var (
+	timeType       = reflect.TypeFor[time.Time]()
+	rawMessageType = reflect.TypeFor[json.RawMessage]()
+)
+
+// Generator turns Go types into schemas the way encoding/json would write them. Named
+// structs go to Schemas once and get referenced from everywhere else
+type Generator struct {
+	Schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func NewGenerator() *Generator {
+	return &Generator{Schemas: make(map[string]*Schema), names: make(map[reflect.Type]string)}
+}
+
+// Schema is the schema of v's type, v is usually a zero value like models.Classifier{}
+func (g *Generator) Schema(v interface{}) *Schema {
+	if v == nil {
+		return &Schema{}
+	}
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	switch t {
+	case timeType:
+		return &Schema{Type: Types{"string"}, Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return Nullable(g.schemaOf(t.Elem()))
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Bool:
+		return &Schema{Type: Types{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s := &Schema{Type: Types{"integer"}}
+		switch t.Kind() {
+		case reflect.Int32:
+			s.Format = "int32"
+		case reflect.Int64:
+			s.Format = "int64"
+		}
+		return s
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: Types{"number"}}
+	case reflect.String:
+		return &Schema{Type: Types{"string"}}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: Types{"string"}, Format: "byte"}
+		}
+		// A nil slice comes out as null
+		return &Schema{Type: Types{"array", "null"}, Items: g.schemaOf(t.Elem())}
+	case reflect.Array:
+		return &Schema{Type: Types{"array"}, Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: Types{"object", "null"}, AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + g.name(t)}
+	}
+	return &Schema{}
+}
+
+// name registers a named struct under a name nobody else has yet
+func (g *Generator) name(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := exported(t.Name())
+	if _, taken := g.Schemas[name]; taken {
+		pkg := t.PkgPath()
+		name = exported(pkg[strings.LastIndex(pkg, "/")+1:]) + name
+	}
+	g.names[t] = name
+	g.Schemas[name] = &Schema{} // so a type that refers to itself finds it
+	*g.Schemas[name] = *g.structSchema(t)
+	return name
+}
+
+func exported(name string) string {
+	if name == "" {
+		return name
+	}
+	r := []rune(name)
+	r[0] = unicode.ToUpper(r[0])
+	return string(r)
+}
+
+// structSchema has the fields encoding/json writes: the ones without omitempty are
+// always there, so they're required
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: Types{"object"}, Properties: make(map[string]*Schema)}
+	g.addFields(s, t)
+	return s
+}
+
+func (g *Generator) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Embedded structs without a name in the tag get their fields promoted
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop := g.schemaOf(f.Type)
+		if constraints := f.Tag.Get("jsonschema"); constraints != "" {
+			prop = constrain(prop, constraints)
+		}
+		s.Properties[name] = prop
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			s.Required = append(s.Required, name)
+		}
+	}
+}
+
+// constrain applies a jsonschema tag, like `jsonschema:"minLength=1,maxLength=255"`,
+// to a copy of s. The checks the handlers make end up in the document that way
+func constrain(s *Schema, tag string) *Schema {
+	if s.Ref != "" || len(s.AnyOf) > 0 {
+		return s // a shared schema, the constraints belong to the type itself
+	}
+	c := *s
+	for _, kv := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(kv, "=")
+		switch key {
+		case "minLength", "maxLength":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				panic("openapi: bad jsonschema tag " + strconv.Quote(tag))
+			}
+			if key == "minLength" {
+				c.MinLength = &n
+			} else {
+				c.MaxLength = &n
+			}
+		case "minimum", "maximum":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				panic("openapi: bad jsonschema tag " + strconv.Quote(tag))
+			}
+			if key == "minimum" {
+				c.Minimum = &n
+			} else {
+				c.Maximum = &n
+			}
+		case "format":
+			c.Format = value
+		default:
+			panic("openapi: unknown jsonschema keyword " + strconv.Quote(key))
+		}
+	}
+	return &c
+}
@@ -0,0 +1,226 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ValidationError says where a value breaks its schema, Path is a JSON pointer
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Validator checks values against schemas, resolving the $refs in Schemas
+// Strict turns properties a schema doesn't list into errors: a response with a
+// field the spec doesn't know about is drift too
+type Validator struct {
+	Schemas map[string]*Schema
+	Strict  bool
+}
+
+// ValidateJSON decodes data and validates it
+func (v *Validator) ValidateJSON(s *Schema, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return &ValidationError{Message: "invalid JSON: " + err.Error()}
+	}
+	if dec.More() {
+		return &ValidationError{Message: "invalid JSON: more than one value"}
+	}
+	return v.Validate(s, value)
+}
+
+// Validate checks a value as encoding/json decodes it, numbers as float64 or json.Number
+func (v *Validator) Validate(s *Schema, value interface{}) error {
+	return v.validate(s, value, "")
+}
+
+func (v *Validator) validate(s *Schema, value interface{}, path string) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		target, ok := v.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+		if !ok {
+			return &ValidationError{Path: path, Message: "unknown schema " + s.Ref}
+		}
+		return v.validate(target, value, path)
+	}
+
+	if len(s.AnyOf) > 0 {
+		var first error
+		for _, option := range s.AnyOf {
+			err := v.validate(option, value, path)
+			if err == nil {
+				return nil
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		// The first option is the interesting one, the other is usually the null
+		return first
+	}
+
+	if len(s.Type) > 0 {
+		kind := jsonType(value)
+		if !s.Type.Has(kind) && !(kind == "integer" && s.Type.Has("number")) {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("is %s, should be %s", kind, strings.Join(s.Type, " or "))}
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		return &ValidationError{Path: path, Message: fmt.Sprintf("is %v, should be one of %v", value, s.Enum)}
+	}
+
+	switch value := value.(type) {
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.MinLength != nil && *s.MinLength == 1 && length == 0 {
+			return &ValidationError{Path: path, Message: "can't be empty"}
+		}
+		if s.MinLength != nil && length < *s.MinLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("should have at least %d characters", *s.MinLength)}
+		}
+		if s.MaxLength != nil && length > *s.MaxLength {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("should have at most %d characters", *s.MaxLength)}
+		}
+		if err := checkFormat(s.Format, value); err != nil {
+			return &ValidationError{Path: path, Message: err.Error()}
+		}
+	case json.Number, float64:
+		n := number(value)
+		if s.Minimum != nil && n < *s.Minimum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("should be at least %v", *s.Minimum)}
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return &ValidationError{Path: path, Message: fmt.Sprintf("should be at most %v", *s.Maximum)}
+		}
+	case []interface{}:
+		for i, item := range value {
+			if err := v.validate(s.Items, item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				return &ValidationError{Path: path, Message: fmt.Sprintf("is missing %q", name)}
+			}
+		}
+		// In order, so the same value always gets the same error
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field := path + "/" + escapePointer(name)
+			if prop, ok := s.Properties[name]; ok {
+				if err := v.validate(prop, value[name], field); err != nil {
+					return err
+				}
+				continue
+			}
+			if s.AdditionalProperties != nil {
+				if err := v.validate(s.AdditionalProperties, value[name], field); err != nil {
+					return err
+				}
+				continue
+			}
+			if v.Strict && s.Properties != nil {
+				return &ValidationError{Path: field, Message: "isn't in the schema"}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType is the JSON Schema type of a decoded value, integer for whole numbers
+func jsonType(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, err := value.Int64(); err == nil {
+			return "integer"
+		}
+		return "number"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func number(value interface{}) float64 {
+	switch value := value.(type) {
+	case json.Number:
+		n, _ := value.Float64()
+		return n
+	case float64:
+		return value
+	}
+	return 0
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, want := range enum {
+		if fmt.Sprint(want) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+var dateRE = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+
+func checkFormat(format, value string) error {
+	switch format {
+	case "date-time":
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("should be an RFC 3339 timestamp")
+		}
+	case "date":
+		if !dateRE.MatchString(value) {
+			return fmt.Errorf("should be a date like 2025-06-30")
+		}
+	case "uri":
+		if u, err := url.Parse(value); err != nil || !u.IsAbs() {
+			return fmt.Errorf("should be an absolute URI")
+		}
+	}
+	return nil
+}
+
+func escapePointer(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
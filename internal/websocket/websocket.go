@@ -0,0 +1,360 @@
+// Package websocket is the server side of RFC 6455, just what our live endpoints use:
+// the upgrade over the standard library's hijacker, text and binary messages
+// (fragmented or not), ping/pong and the closing handshake. No extensions, so no
+// compression, and no client side
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Message types, the opcodes of the protocol
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// Close codes we use, the rest are in RFC 6455 section 7.4
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseTooBig          = 1009
+	CloseTryAgainLater   = 1013
+	closeNoStatus        = 1005
+)
+
+// ErrBadHandshake means the request isn't a WebSocket upgrade we can take
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// ErrBadOrigin means a browser opened the connection from a page we don't trust
+var ErrBadOrigin = errors.New("websocket: origin not allowed")
+
+// UpgradeOptions tunes the handshake
+type UpgradeOptions struct {
+	// AllowedOrigins are the origins, like "https://app.example.com", that may connect
+	// besides our own host. Browsers send cookies and such cross-site, so without the
+	// check any page could talk to us as its visitor
+	AllowedOrigins []string
+}
+
+// CloseError is what ReadMessage returns once the peer closed the connection
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: closed with %d %s", e.Code, e.Text)
+}
+
+// The magic GUID from the RFC, what the accept key gets hashed with
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Conn is an upgraded connection. One goroutine reads, any number can write
+type Conn struct {
+	conn      net.Conn
+	br        *bufio.Reader
+	readLimit int64
+	onPong    func()
+
+	wmu    sync.Mutex // one frame at a time on the wire
+	closed bool       // we sent our close frame, nothing else goes out after it
+}
+
+// Upgrade takes over the connection of a WebSocket handshake request
+// On error nothing has been written, so the caller can still answer with a 400 (or a
+// 403 for ErrBadOrigin)
+func Upgrade(w http.ResponseWriter, r *http.Request, opts UpgradeOptions) (*Conn, error) {
+	if r.Method != http.MethodGet {
+		return nil, fmt.Errorf("%w: method has to be GET", ErrBadHandshake)
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, fmt.Errorf("%w: not a websocket upgrade", ErrBadHandshake)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: only version 13 is supported", ErrBadHandshake)
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 16 {
+		return nil, fmt.Errorf("%w: invalid Sec-WebSocket-Key", ErrBadHandshake)
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !originAllowed(origin, r.Host, opts.AllowedOrigins) {
+		return nil, fmt.Errorf("%w: %s", ErrBadOrigin, origin)
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's read and write timeouts stay on the connection after the hijack
+	conn.SetDeadline(time.Time{})
+
+	// What the middlewares already put on w (X-Request-ID and such) goes out with the 101
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	header := w.Header().Clone()
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	header.Write(&response)
+	response.WriteString("\r\n")
+	if _, err := io.WriteString(conn, response.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &Conn{
+		conn:      conn,
+		br:        brw.Reader, // it may already hold the first frames
+		readLimit: 1 << 20,
+	}, nil
+}
+
+// originAllowed takes the same host as the request or one of the allowed origins
+// Only browsers send Origin, so a request without it isn't checked
+func originAllowed(origin, host string, allowed []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, o := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// headerHasToken looks for a token in a comma separated header, case insensitive
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetReadLimit caps the size of a message, fragments added up. 1MB by default
+func (c *Conn) SetReadLimit(n int64) {
+	c.readLimit = n
+}
+
+// SetPongHandler is called from ReadMessage for every pong that arrives
+func (c *Conn) SetPongHandler(fn func()) {
+	c.onPong = fn
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// RemoteAddr is the peer's address, as the listener saw it
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Close drops the connection without the closing handshake, see WriteClose for that
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ReadMessage returns the next text or binary message
+// Pings are answered and pongs handed to the pong handler along the way. When the peer
+// closes, we answer the close and the error is a *CloseError
+func (c *Conn) ReadMessage() (messageType int, data []byte, err error) {
+	var message []byte
+	messageType = -1
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch opcode {
+		case PingMessage:
+			if err := c.WriteMessage(PongMessage, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case PongMessage:
+			if c.onPong != nil {
+				c.onPong()
+			}
+			continue
+		case CloseMessage:
+			closeErr := &CloseError{Code: closeNoStatus}
+			if len(payload) >= 2 {
+				closeErr.Code = int(binary.BigEndian.Uint16(payload))
+				closeErr.Text = string(payload[2:])
+			}
+			echo := closeErr.Code
+			if echo == closeNoStatus {
+				echo = CloseNormal
+			}
+			c.WriteClose(echo, "")
+			return 0, nil, closeErr
+		case TextMessage, BinaryMessage:
+			if messageType != -1 {
+				return 0, nil, c.fail(CloseProtocolError, "new message in the middle of a fragmented one")
+			}
+			messageType = opcode
+		case continuationFrame:
+			if messageType == -1 {
+				return 0, nil, c.fail(CloseProtocolError, "continuation without a message to continue")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		if int64(len(message)+len(payload)) > c.readLimit {
+			return 0, nil, c.fail(CloseTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if !fin {
+			continue
+		}
+		if messageType == TextMessage && !utf8.Valid(message) {
+			return 0, nil, c.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+		}
+		return messageType, message, nil
+	}
+}
+
+// readFrame reads one frame and unmasks it
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0f)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set, we negotiated no extensions")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "client frames have to be masked")
+	}
+
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if opcode >= CloseMessage && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(CloseProtocolError, "control frames have to be short and unfragmented")
+	}
+	if length < 0 || length > c.readLimit {
+		return false, 0, nil, c.fail(CloseTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// fail closes with code after a protocol violation and returns the error for it
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	return &CloseError{Code: code, Text: reason}
+}
+
+// SetWriteDeadline applies to every write after it, control frames included
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// WriteMessage sends data as a single frame. Server frames aren't masked
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.writeFrameLocked(messageType, data)
+}
+
+// WriteClose starts (or answers) the closing handshake, after it nothing else is sent
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > 125 {
+		payload = payload[:125]
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closed {
+		return nil
+	}
+	err := c.writeFrameLocked(CloseMessage, payload)
+	c.closed = true
+	return err
+}
+
+func (c *Conn) writeFrameLocked(opcode int, data []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	frame := make([]byte, 0, len(data)+10)
+	frame = append(frame, 0x80|byte(opcode))
+	switch n := len(data); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, data...)
+
+	_, err := c.conn.Write(frame)
+	return err
+}
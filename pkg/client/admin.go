@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Alive is the liveness probe, nil if the server answers at all
+func (c *Client) Alive(ctx context.Context) error {
+	return c.call(ctx, request{method: http.MethodGet, path: "/healthz", noRetry: true}, "", nil)
+}
+
+// Ready is the readiness probe: false while the server warms up its cache or shuts down
+// It doesn't retry, the answer is what you asked for
+func (c *Client) Ready(ctx context.Context) (bool, error) {
+	err := c.call(ctx, request{method: http.MethodGet, path: "/readyz", noRetry: true}, "", nil)
+	if errors.Is(err, ErrUnavailable) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Metrics returns GET /debug/metrics as is: connection pool, breaker, replicas, outbox,
+// webhooks and event streams. It's for dashboards, the shape follows the server
+func (c *Client) Metrics(ctx context.Context) (map[string]json.RawMessage, error) {
+	res, err := c.do(ctx, request{method: http.MethodGet, path: "/debug/metrics"})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var metrics map[string]json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// CacheStatsOptions picks which keys come in the sample
+type CacheStatsOptions struct {
+	Prefix string
+	Sample int // 20 if zero, up to 100
+}
+
+// CacheStats shows what's inside the server's cache, it needs Options.AdminToken
+func (c *Client) CacheStats(ctx context.Context, opts CacheStatsOptions) (*CacheStats, error) {
+	query := url.Values{}
+	if opts.Prefix != "" {
+		query.Set("prefix", opts.Prefix)
+	}
+	if opts.Sample > 0 {
+		query.Set("sample", strconv.Itoa(opts.Sample))
+	}
+
+	var stats CacheStats
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/admin/cache", query: query}, "cache", &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// FlushCache empties the server's cache, and the other replicas' through the bus:
+// everything, the keys under a prefix, or one key. It needs Options.AdminToken
+func (c *Client) FlushCache(ctx context.Context, prefix, key string) (*CacheFlush, error) {
+	query := url.Values{}
+	if prefix != "" {
+		query.Set("prefix", prefix)
+	}
+	if key != "" {
+		query.Set("key", key)
+	}
+
+	res, err := c.do(ctx, request{method: http.MethodDelete, path: "/admin/cache", query: query})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var flush CacheFlush
+	if err := json.NewDecoder(res.Body).Decode(&flush); err != nil {
+		return nil, err
+	}
+	return &flush, nil
+}
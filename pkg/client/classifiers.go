@@ -0,0 +1,317 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CreateClassifierInput is a new classifier, nil leaves Description and IsActive null
+type CreateClassifierInput struct {
+	Name        string  `json:"name"`
+	Description *string `json:"description,omitempty"`
+	IsActive    *bool   `json:"is_active,omitempty"`
+}
+
+// ClassifierPatch changes only the fields that aren't nil. An empty Description clears it
+type ClassifierPatch struct {
+	Name        *string `json:"name,omitempty"`
+	Description *string `json:"description,omitempty"`
+	IsActive    *bool   `json:"is_active,omitempty"`
+}
+
+// ListOptions filters and pages GET /classifiers. AsOf lists the catalog as it was
+// then and can't be combined with Search
+type ListOptions struct {
+	Page     int // 1 if zero
+	PageSize int // 20 if zero, up to 100
+	Search   string
+	AsOf     time.Time
+}
+
+// String and Bool are for the optional fields: client.String("x")
+func String(s string) *string { return &s }
+func Bool(b bool) *bool       { return &b }
+
+func classifierPath(id int64) string {
+	return "/v1/classifiers/" + strconv.FormatInt(id, 10)
+}
+
+func asOfParam(query url.Values, asOf time.Time) url.Values {
+	if !asOf.IsZero() {
+		query.Set("as_of", asOf.UTC().Format(time.RFC3339Nano))
+	}
+	return query
+}
+
+// CreateClassifier creates a classifier and returns it as stored, created_at included
+// A name already taken is an ErrConflict
+func (c *Client) CreateClassifier(ctx context.Context, in CreateClassifierInput) (*Classifier, error) {
+	req, err := jsonRequest(http.MethodPost, "/v1/classifiers", in)
+	if err != nil {
+		return nil, err
+	}
+	// The create answers with what was sent plus the id, the rest comes with a GET
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	if err := c.call(ctx, req, "classifier", &created); err != nil {
+		return nil, err
+	}
+	return c.GetClassifier(ctx, created.ID)
+}
+
+// GetClassifier returns a classifier, ErrNotFound if there's none with that id
+func (c *Client) GetClassifier(ctx context.Context, id int64) (*Classifier, error) {
+	return c.GetClassifierAsOf(ctx, id, time.Time{})
+}
+
+// GetClassifierAsOf returns a classifier as it was at asOf, the zero time means now
+func (c *Client) GetClassifierAsOf(ctx context.Context, id int64, asOf time.Time) (*Classifier, error) {
+	var classifier Classifier
+	req := request{method: http.MethodGet, path: classifierPath(id), query: asOfParam(url.Values{}, asOf)}
+	if err := c.call(ctx, req, "classifier", &classifier); err != nil {
+		return nil, err
+	}
+	return &classifier, nil
+}
+
+// ListClassifiers returns one page of classifiers, newest first
+func (c *Client) ListClassifiers(ctx context.Context, opts ListOptions) (*ClassifierPage, error) {
+	query := pageQuery(opts.Page, opts.PageSize)
+	if opts.Search != "" {
+		query.Set("q", opts.Search)
+	}
+
+	var page ClassifierPage
+	req := request{method: http.MethodGet, path: "/v1/classifiers", query: asOfParam(query, opts.AsOf)}
+	if err := c.call(ctx, req, "data", &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllClassifiers goes through every page from opts.Page on, one request per page as
+// the loop gets there. An error ends it; break whenever you like
+// Rows created or deleted while it runs can shift the pages, use ExportClassifiers
+// for a consistent copy of the whole catalog
+func (c *Client) AllClassifiers(ctx context.Context, opts ListOptions) iter.Seq2[*Classifier, error] {
+	return paginate(opts.Page, func(page int) ([]*Classifier, Metadata, error) {
+		opts.Page = page
+		p, err := c.ListClassifiers(ctx, opts)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		return p.Classifiers, p.Metadata, nil
+	})
+}
+
+// paginate walks the pages of any paged endpoint
+func paginate[T any](first int, fetch func(page int) ([]T, Metadata, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		page := max(first, 1)
+		for {
+			items, meta, err := fetch(page)
+			if err != nil {
+				var zero T
+				yield(zero, err)
+				return
+			}
+			for _, item := range items {
+				if !yield(item, nil) {
+					return
+				}
+			}
+			if len(items) == 0 || page >= meta.Pages {
+				return
+			}
+			page++
+		}
+	}
+}
+
+// UpdateClassifier changes the fields set in patch and returns the result
+func (c *Client) UpdateClassifier(ctx context.Context, id int64, patch ClassifierPatch) (*Classifier, error) {
+	req, err := jsonRequest(http.MethodPatch, classifierPath(id), patch)
+	if err != nil {
+		return nil, err
+	}
+	var classifier Classifier
+	if err := c.call(ctx, req, "classifier", &classifier); err != nil {
+		return nil, err
+	}
+	return &classifier, nil
+}
+
+// DeleteClassifier deletes a classifier, its history stays
+func (c *Client) DeleteClassifier(ctx context.Context, id int64) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: classifierPath(id)}, "", nil)
+}
+
+// HistoryOptions pages through a classifier's versions
+type HistoryOptions struct {
+	Page     int
+	PageSize int
+}
+
+// ClassifierHistory returns one page of a classifier's versions, newest first
+// It works for deleted classifiers too
+func (c *Client) ClassifierHistory(ctx context.Context, id int64, opts HistoryOptions) (*HistoryPage, error) {
+	var page HistoryPage
+	req := request{method: http.MethodGet, path: classifierPath(id) + "/history", query: pageQuery(opts.Page, opts.PageSize)}
+	if err := c.call(ctx, req, "data", &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllClassifierHistory goes through every version of a classifier, newest first
+func (c *Client) AllClassifierHistory(ctx context.Context, id int64, opts HistoryOptions) iter.Seq2[*AuditEntry, error] {
+	return paginate(opts.Page, func(page int) ([]*AuditEntry, Metadata, error) {
+		opts.Page = page
+		p, err := c.ClassifierHistory(ctx, id, opts)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		return p.History, p.Metadata, nil
+	})
+}
+
+// DiffClassifier compares two versions field by field, from can be the newer one
+func (c *Client) DiffClassifier(ctx context.Context, id int64, from, to int) (*Diff, error) {
+	query := url.Values{"from": {strconv.Itoa(from)}, "to": {strconv.Itoa(to)}}
+	var diff Diff
+	req := request{method: http.MethodGet, path: classifierPath(id) + "/history/diff", query: query}
+	if err := c.call(ctx, req, "diff", &diff); err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
+
+// RevertClassifier restores a version as a new one, deleted classifiers included
+// Reverting to the deletion, or to a name someone else took since, is an ErrConflict
+func (c *Client) RevertClassifier(ctx context.Context, id int64, version int) (*Classifier, error) {
+	query := url.Values{"version": {strconv.Itoa(version)}}
+	var classifier Classifier
+	req := request{method: http.MethodPost, path: classifierPath(id) + "/revert", query: query}
+	if err := c.call(ctx, req, "classifier", &classifier); err != nil {
+		return nil, err
+	}
+	return &classifier, nil
+}
+
+// ExportFormat is a format for ExportClassifiers and ImportClassifiers
+// (the import takes CSV and NDJSON)
+type ExportFormat string
+
+const (
+	FormatCSV    ExportFormat = "csv"
+	FormatJSON   ExportFormat = "json"
+	FormatNDJSON ExportFormat = "ndjson"
+)
+
+// ExportOptions picks the format and the same filters as the list
+type ExportOptions struct {
+	Format ExportFormat // FormatCSV if empty
+	Search string
+	AsOf   time.Time
+}
+
+// ExportClassifiers streams the whole catalog into w, as the server writes it
+// A server that gives up halfway cuts the connection: that's an error here, and
+// what's in w by then is incomplete
+func (c *Client) ExportClassifiers(ctx context.Context, w io.Writer, opts ExportOptions) error {
+	format := opts.Format
+	if format == "" {
+		format = FormatCSV
+	}
+	query := url.Values{"format": {string(format)}}
+	if opts.Search != "" {
+		query.Set("q", opts.Search)
+	}
+
+	res, err := c.do(ctx, request{
+		method: http.MethodGet,
+		path:   "/v1/classifiers/export",
+		query:  asOfParam(query, opts.AsOf),
+		accept: "*/*",
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if _, err := io.Copy(w, res.Body); err != nil {
+		return fmt.Errorf("client: the export was cut short: %w", err)
+	}
+	return nil
+}
+
+// ConflictStrategy is what an import does with a name already taken
+type ConflictStrategy string
+
+const (
+	ConflictFail      ConflictStrategy = "fail"
+	ConflictSkip      ConflictStrategy = "skip"
+	ConflictOverwrite ConflictStrategy = "overwrite"
+)
+
+// ImportOptions says how to read the file and what to do with it
+type ImportOptions struct {
+	Format     ExportFormat     // FormatCSV or FormatNDJSON, required
+	OnConflict ConflictStrategy // ConflictFail if empty
+	DryRun     bool
+	// Columns maps fields (name, description, is_active) to the file's columns or keys
+	// when they're called something else, like {"name": "Nombre"}
+	Columns map[string]string
+}
+
+// ImportClassifiers loads a CSV or NDJSON file. The report comes back with the error
+// too when the server has one: an *Error with ErrUnprocessable for a file with bad
+// lines (nothing written), or ErrConflict for a taken name under ConflictFail (the
+// batches before it were written). The request is retried only if body is a
+// *bytes.Reader, *bytes.Buffer or *strings.Reader, the others can't be sent twice
+func (c *Client) ImportClassifiers(ctx context.Context, body io.Reader, opts ImportOptions) (*ImportReport, error) {
+	query := url.Values{"format": {string(opts.Format)}}
+	if opts.OnConflict != "" {
+		query.Set("on_conflict", string(opts.OnConflict))
+	}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	if len(opts.Columns) > 0 {
+		mapping := make([]string, 0, len(opts.Columns))
+		for field, column := range opts.Columns {
+			mapping = append(mapping, field+":"+column)
+		}
+		query.Set("map", strings.Join(mapping, ","))
+	}
+
+	contentType := "text/csv"
+	if opts.Format == FormatNDJSON {
+		contentType = "application/x-ndjson"
+	}
+
+	var report ImportReport
+	err := c.call(ctx, request{
+		method:      http.MethodPost,
+		path:        "/v1/classifiers/import",
+		query:       query,
+		body:        body,
+		contentType: contentType,
+	}, "import", &report)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.Import != nil {
+		return apiErr.Import, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
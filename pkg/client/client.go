@@ -0,0 +1,264 @@
+// Package client talks to the classifier API from Go, so nobody has to hand-write
+// the requests and the envelopes again.
+//
+//	c, err := client.New("http://classifier:4000", client.Options{Actor: "billing-sync"})
+//	if err != nil { ... }
+//	for classifier, err := range c.AllClassifiers(ctx, client.ListOptions{Search: "bebida"}) {
+//		if err != nil { ... }
+//		fmt.Println(classifier.ID, classifier.Name)
+//	}
+//
+// Every method takes a context, that's how you put a deadline on it or cancel it.
+// The 503s (database down, breaker open, server starting up) and the 429s are retried
+// on their own, waiting what the server's Retry-After says or backing off otherwise;
+// anything else the server says no to comes back as an *Error, which errors.Is
+// matches against ErrNotFound, ErrConflict and company.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Options tunes a Client, the zero value works
+type Options struct {
+	// HTTPClient makes the requests, http.DefaultClient if nil. Leave its Timeout
+	// alone for exports and imports, the context is the better place for a deadline
+	HTTPClient *http.Client
+
+	// AdminToken goes as the bearer token: the /admin and webhook endpoints need it,
+	// and Actor too
+	AdminToken string
+	// ClientID is sent as X-Client-ID: the server keeps your reads after a write on the
+	// primary, so you read what you just wrote. The remote IP if empty
+	ClientID string
+	// Actor is who the audit trail blames for the changes (X-Actor). The server only
+	// believes it with AdminToken set or through one of its trusted proxies, otherwise
+	// the audit trail gets your IP
+	Actor string
+	// UserAgent, "classifier-go-client" if empty
+	UserAgent string
+
+	// MaxRetries is how many times a 429, or a 503 that's safe to send again, is
+	// retried, 3 if zero; -1 turns it off
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the wait between retries when the server doesn't
+	// send a Retry-After: it doubles from MinBackoff (250ms) up to MaxBackoff (10s),
+	// with jitter. A Retry-After longer than MaxBackoff is still honored
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// Client is safe for concurrent use, one per server is enough
+type Client struct {
+	base       *url.URL
+	http       *http.Client
+	adminToken string
+	clientID   string
+	actor      string
+	userAgent  string
+	maxRetries int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// New makes a client for the server at baseURL, like http://classifier:4000
+func New(baseURL string, opts Options) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(baseURL, "/"))
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("client: invalid base URL %q, it has to be an absolute http or https URL", baseURL)
+	}
+
+	c := &Client{
+		base:       base,
+		http:       opts.HTTPClient,
+		adminToken: opts.AdminToken,
+		clientID:   opts.ClientID,
+		actor:      opts.Actor,
+		userAgent:  opts.UserAgent,
+		maxRetries: opts.MaxRetries,
+		minBackoff: opts.MinBackoff,
+		maxBackoff: opts.MaxBackoff,
+	}
+	if c.http == nil {
+		c.http = http.DefaultClient
+	}
+	if c.userAgent == "" {
+		c.userAgent = "classifier-go-client"
+	}
+	switch {
+	case c.maxRetries == 0:
+		c.maxRetries = 3
+	case c.maxRetries < 0:
+		c.maxRetries = 0
+	}
+	if c.minBackoff <= 0 {
+		c.minBackoff = 250 * time.Millisecond
+	}
+	if c.maxBackoff <= 0 {
+		c.maxBackoff = 10 * time.Second
+	}
+	return c, nil
+}
+
+// request is one call to the API
+type request struct {
+	method      string
+	path        string
+	query       url.Values
+	body        io.Reader // retried only if http.NewRequest can rewind it (bytes and strings readers)
+	contentType string
+	accept      string
+	noRetry     bool // for the probes, a 503 there is the answer
+}
+
+// jsonRequest builds a request with v as its JSON body
+func jsonRequest(method, path string, v interface{}) (request, error) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		return request{}, err
+	}
+	return request{method: method, path: path, body: bytes.NewReader(js), contentType: "application/json"}, nil
+}
+
+// do sends req, retrying the 429s and the 503s that are safe to repeat, and returns the
+// response for any status under 400. The rest become an *Error, with the body already
+// read and closed
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	u := *c.base
+	u.Path += req.path
+	u.RawQuery = req.query.Encode()
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), req.body)
+	if err != nil {
+		return nil, err
+	}
+	if req.contentType != "" {
+		httpReq.Header.Set("Content-Type", req.contentType)
+	}
+	accept := req.accept
+	if accept == "" {
+		accept = "application/json"
+	}
+	httpReq.Header.Set("Accept", accept)
+	httpReq.Header.Set("User-Agent", c.userAgent)
+	if c.adminToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
+	if c.clientID != "" {
+		httpReq.Header.Set("X-Client-ID", c.clientID)
+	}
+	if c.actor != "" {
+		httpReq.Header.Set("X-Actor", c.actor)
+	}
+
+	for attempt := 0; ; attempt++ {
+		res, err := c.http.Do(httpReq)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode < 400 {
+			return res, nil
+		}
+
+		apiErr := readError(res)
+		retryable := res.StatusCode == http.StatusTooManyRequests ||
+			res.StatusCode == http.StatusServiceUnavailable && (safeMethod(req.method) || res.Header.Get(retrySafeHeader) == "true")
+		if !retryable || req.noRetry || attempt >= c.maxRetries || (req.body != nil && httpReq.GetBody == nil) {
+			return nil, apiErr
+		}
+
+		// A 429 and an X-Retry-Safe 503 were turned away before doing anything. Any other
+		// 503 may come after a write went through, so only GET and HEAD try those again
+		if err := sleep(ctx, c.backoff(attempt, apiErr.RetryAfter)); err != nil {
+			return nil, apiErr
+		}
+		if httpReq.GetBody != nil {
+			if httpReq.Body, err = httpReq.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// retrySafeHeader is how the server marks a 503 it answered without doing anything
+const retrySafeHeader = "X-Retry-Safe"
+
+// safeMethod reports whether sending method twice can't change anything
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// backoff is how long to wait before retry number attempt+1
+func (c *Client) backoff(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	wait := c.minBackoff << min(attempt, 16)
+	if wait > c.maxBackoff || wait <= 0 {
+		wait = c.maxBackoff
+	}
+	// Jitter, so a fleet of clients doesn't come back all at the same instant
+	return wait/2 + rand.N(wait/2+1)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// call sends req and decodes the envelope's key into dst, dst nil skips the body
+func (c *Client) call(ctx context.Context, req request, key string, dst interface{}) error {
+	res, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if dst == nil {
+		io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	return decodeEnvelope(res.Body, key, dst)
+}
+
+func decodeEnvelope(body io.Reader, key string, dst interface{}) error {
+	var env map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&env); err != nil {
+		return fmt.Errorf("client: reading the response: %w", err)
+	}
+	raw, ok := env[key]
+	if !ok {
+		return fmt.Errorf("client: the response has no %q", key)
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("client: reading the response's %q: %w", key, err)
+	}
+	return nil
+}
+
+// pageQuery is the page and page_size of a paged endpoint, the server's defaults when zero
+func pageQuery(page, pageSize int) url.Values {
+	query := url.Values{}
+	if page > 0 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if pageSize > 0 {
+		query.Set("page_size", strconv.Itoa(pageSize))
+	}
+	return query
+}
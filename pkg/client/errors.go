@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The kinds of error the server answers with, for errors.Is
+var (
+	ErrBadRequest       = errors.New("client: bad request")            // 400, the message says what's wrong
+	ErrUnauthorized     = errors.New("client: unauthorized")           // 401, missing or wrong admin token
+	ErrForbidden        = errors.New("client: forbidden")              // 403, admin endpoints without ADMIN_TOKEN on the server
+	ErrNotFound         = errors.New("client: not found")              // 404
+	ErrConflict         = errors.New("client: conflict")               // 409, a name already taken and the like
+	ErrTooLarge         = errors.New("client: request too large")      // 413
+	ErrUnsupportedMedia = errors.New("client: unsupported media type") // 415
+	ErrUnprocessable    = errors.New("client: unprocessable")          // 422, an import with bad lines
+	ErrRateLimited      = errors.New("client: rate limited")           // 429, after the retries
+	ErrUnavailable      = errors.New("client: service unavailable")    // 503, after the retries
+	ErrTimeout          = errors.New("client: gateway timeout")        // 504, the database took too long
+	ErrServer           = errors.New("client: server error")           // any other 5xx
+)
+
+var statusErrors = map[int]error{
+	http.StatusBadRequest:            ErrBadRequest,
+	http.StatusUnauthorized:          ErrUnauthorized,
+	http.StatusForbidden:             ErrForbidden,
+	http.StatusNotFound:              ErrNotFound,
+	http.StatusConflict:              ErrConflict,
+	http.StatusRequestEntityTooLarge: ErrTooLarge,
+	http.StatusUnsupportedMediaType:  ErrUnsupportedMedia,
+	http.StatusUnprocessableEntity:   ErrUnprocessable,
+	http.StatusTooManyRequests:       ErrRateLimited,
+	http.StatusServiceUnavailable:    ErrUnavailable,
+	http.StatusGatewayTimeout:        ErrTimeout,
+}
+
+// Error is an answer of 400 or more, the server's {"error": ...} included
+type Error struct {
+	StatusCode int
+	// Message is the server's error, or the status text if it sent none
+	Message string
+	// RequestID is the server's X-Request-ID, what to look for in its logs
+	RequestID string
+	// RetryAfter is the server's Retry-After, zero if it sent none
+	RetryAfter time.Duration
+	// Import is the report of an import that was rejected (422) or stopped at a
+	// taken name (409), nil for everything else
+	Import *ImportReport
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("client: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Is matches the ErrNotFound style sentinels by status
+func (e *Error) Is(target error) bool {
+	if sentinel, ok := statusErrors[e.StatusCode]; ok {
+		return target == sentinel
+	}
+	return target == ErrServer && e.StatusCode >= 500
+}
+
+// readError turns an error response into an *Error and closes its body
+func readError(res *http.Response) *Error {
+	defer res.Body.Close()
+
+	e := &Error{
+		StatusCode: res.StatusCode,
+		Message:    http.StatusText(res.StatusCode),
+		RequestID:  res.Header.Get("X-Request-ID"),
+	}
+	if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	var body struct {
+		Error  json.RawMessage `json:"error"`
+		Import *ImportReport   `json:"import"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body); err != nil {
+		return e
+	}
+	e.Import = body.Import
+	if len(body.Error) > 0 {
+		// Mostly a string, but it can be anything the handler put in there
+		var message string
+		if json.Unmarshal(body.Error, &message) == nil {
+			e.Message = message
+		} else {
+			e.Message = string(body.Error)
+		}
+	} else if body.Import != nil {
+		e.Message = fmt.Sprintf("the import has %d errors", body.Import.ErrorCount)
+	}
+	return e
+}
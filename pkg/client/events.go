@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamOptions says where an event stream starts and what to do when it can't
+type StreamOptions struct {
+	// LastEventID is the last event you already have, the stream starts after it
+	// Zero starts with what happens from now on
+	LastEventID int64
+	// OnReset is called when the server no longer has everything since LastEventID,
+	// the events in between are lost: reload whatever you keep from the list. The
+	// stream goes on after it returns, an error stops it. Nil ignores the resets
+	OnReset func() error
+}
+
+// StreamEvents follows GET /classifiers/events and calls fn with every change, in
+// order. It reconnects on its own when the connection drops, picking up after the
+// last event fn got, so it only returns with ctx's error, fn's or OnReset's, or an
+// *Error the server answered a reconnection with
+func (c *Client) StreamEvents(ctx context.Context, opts StreamOptions, fn func(*ChangeEvent) error) error {
+	lastEventID := opts.LastEventID
+	retry := time.Second
+
+	for attempt := 0; ; attempt++ {
+		// The connect retries the 503s itself, a failure here is for good
+		res, err := c.openStream(ctx, lastEventID)
+		var apiErr *Error
+		if errors.As(err, &apiErr) {
+			return err
+		}
+
+		if err == nil {
+			attempt = 0
+			stop := readEvents(res.Body, func(event sseEvent) error {
+				switch event.name {
+				case "":
+					// retry: only, how long the server wants us to wait before reconnecting
+					if event.retry > 0 {
+						retry = event.retry
+					}
+					return nil
+				case "reset":
+					if opts.OnReset != nil {
+						return opts.OnReset()
+					}
+					return nil
+				}
+
+				var change ChangeEvent
+				if err := json.Unmarshal([]byte(event.data), &change); err != nil {
+					return fmt.Errorf("client: reading event %s: %w", event.id, err)
+				}
+				if err := fn(&change); err != nil {
+					return err
+				}
+				lastEventID = change.ID
+				return nil
+			})
+			res.Body.Close()
+			if stop != nil {
+				return stop
+			}
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// Dropped, or the server is unreachable: back off a bit more every time it fails
+		wait := retry
+		if attempt > 0 {
+			wait = c.backoff(attempt, 0)
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func (c *Client) openStream(ctx context.Context, lastEventID int64) (*http.Response, error) {
+	req := request{method: http.MethodGet, path: "/v1/classifiers/events", accept: "text/event-stream"}
+	if lastEventID > 0 {
+		req.query = url.Values{"last_event_id": {strconv.FormatInt(lastEventID, 10)}}
+	}
+	return c.do(ctx, req)
+}
+
+// sseEvent is one Server-Sent Event, name is empty for a block with only a retry
+type sseEvent struct {
+	id    string
+	name  string
+	data  string
+	retry time.Duration
+}
+
+// readEvents parses an event stream until it ends or handle returns an error, which
+// is what it returns; the end of the stream, however it came, is a nil
+func readEvents(body io.Reader, handle func(sseEvent) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 4<<20)
+
+	var event sseEvent
+	var data []string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			event.data = strings.Join(data, "\n")
+			if event.name != "" || event.retry > 0 {
+				if err := handle(event); err != nil {
+					return err
+				}
+			}
+			event, data = sseEvent{}, data[:0]
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // comment, the heartbeat
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "id":
+			event.id = value
+		case "event":
+			event.name = value
+		case "data":
+			data = append(data, value)
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms > 0 {
+				event.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Classifier is a classifier as the API returns it. Description and IsActive are nil
+// when they're null
+type Classifier struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	IsActive    *bool     `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UnmarshalJSON reads the nullable fields both as plain values and the way the
+// server writes a database row, {"String": "...", "Valid": true}
+func (c *Classifier) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		ID          int64           `json:"id"`
+		Name        string          `json:"name"`
+		Description json.RawMessage `json:"description"`
+		IsActive    json.RawMessage `json:"is_active"`
+		CreatedAt   time.Time       `json:"created_at"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*c = Classifier{ID: raw.ID, Name: raw.Name, CreatedAt: raw.CreatedAt}
+	var err error
+	if c.Description, err = decodeNullable[string](raw.Description, "String"); err != nil {
+		return err
+	}
+	c.IsActive, err = decodeNullable[bool](raw.IsActive, "Bool")
+	return err
+}
+
+// decodeNullable reads a value, null, or a sql.Null* as encoding/json writes it
+func decodeNullable[T any](raw json.RawMessage, field string) (*T, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] != '{' {
+		var v T
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		return &v, nil
+	}
+
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &wrapped); err != nil {
+		return nil, err
+	}
+	var valid bool
+	if err := json.Unmarshal(wrapped["Valid"], &valid); err != nil || !valid {
+		return nil, nil
+	}
+	var v T
+	if err := json.Unmarshal(wrapped[field], &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// Metadata is where a page sits in the whole list
+type Metadata struct {
+	Total    int `json:"total"`
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+	Pages    int `json:"pages"`
+}
+
+// ClassifierPage is one page of GET /classifiers
+type ClassifierPage struct {
+	Classifiers []*Classifier `json:"classifiers"`
+	Metadata    Metadata      `json:"metadata"`
+}
+
+// AuditAction is what a version of a classifier did to it
+type AuditAction string
+
+const (
+	AuditCreated  AuditAction = "created"
+	AuditUpdated  AuditAction = "updated"
+	AuditDeleted  AuditAction = "deleted"
+	AuditReverted AuditAction = "reverted"
+)
+
+// Snapshot is a classifier as the audit trail and the events carry it
+type Snapshot struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description"`
+	IsActive    *bool     `json:"is_active"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// FieldChange is one field that differs between two versions, nil means null
+type FieldChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// AuditEntry is one version of a classifier. Before is nil for the create, After for the delete
+type AuditEntry struct {
+	ID           int64         `json:"id"`
+	ClassifierID int64         `json:"classifier_id"`
+	Version      int           `json:"version"`
+	Action       AuditAction   `json:"action"`
+	Actor        string        `json:"actor"`
+	RequestID    string        `json:"request_id,omitempty"`
+	Before       *Snapshot     `json:"before"`
+	After        *Snapshot     `json:"after"`
+	Changes      []FieldChange `json:"changes"`
+	CreatedAt    time.Time     `json:"created_at"`
+}
+
+// HistoryPage is one page of a classifier's versions, newest first
+type HistoryPage struct {
+	History  []*AuditEntry `json:"history"`
+	Metadata Metadata      `json:"metadata"`
+}
+
+// Diff compares two versions of a classifier
+type Diff struct {
+	ClassifierID int64         `json:"classifier_id"`
+	From         DiffVersion   `json:"from"`
+	To           DiffVersion   `json:"to"`
+	Changes      []FieldChange `json:"changes"`
+}
+
+// DiffVersion is one side of a Diff, Classifier is nil for a deletion
+type DiffVersion struct {
+	Version    int         `json:"version"`
+	Action     AuditAction `json:"action"`
+	Classifier *Snapshot   `json:"classifier"`
+}
+
+// EventType is the kind of change an event reports
+type EventType string
+
+const (
+	EventCreated EventType = "created"
+	EventUpdated EventType = "updated"
+	EventDeleted EventType = "deleted"
+)
+
+// ChangeEvent is one change to a classifier, as the webhooks and the event stream send it
+// ID grows with every event, dedupe on it
+type ChangeEvent struct {
+	ID           int64         `json:"id"`
+	Type         EventType     `json:"type"`
+	ClassifierID int64         `json:"classifier_id"`
+	Version      int           `json:"version"`
+	Actor        string        `json:"actor"`
+	RequestID    string        `json:"request_id,omitempty"`
+	Before       *Snapshot     `json:"before"`
+	After        *Snapshot     `json:"after"`
+	Changes      []FieldChange `json:"changes"`
+	OccurredAt   time.Time     `json:"occurred_at"`
+}
+
+// Webhook is a URL subscribed to the change events
+type Webhook struct {
+	ID         int64       `json:"id"`
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types"` // empty means every event
+	// Secret signs the deliveries, only CreateWebhook returns it
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DeliveryStatus is where a webhook delivery stands
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	DeliveryDead      DeliveryStatus = "dead"
+)
+
+// WebhookDelivery is one event sent (or being sent) to one webhook
+type WebhookDelivery struct {
+	ID            int64          `json:"id"`
+	WebhookID     int64          `json:"webhook_id"`
+	EventID       int64          `json:"event_id"`
+	EventType     EventType      `json:"event_type"`
+	Status        DeliveryStatus `json:"status"`
+	Attempts      int            `json:"attempts"`
+	NextAttemptAt *time.Time     `json:"next_attempt_at,omitempty"`
+	LastStatus    *int           `json:"last_status"`
+	LastError     string         `json:"last_error,omitempty"`
+	CreatedAt     time.Time      `json:"created_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+}
+
+// DeliveryPage is one page of a webhook's deliveries, newest first
+type DeliveryPage struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+	Metadata   Metadata           `json:"metadata"`
+}
+
+// ImportReport is how an import went, or would go with DryRun
+type ImportReport struct {
+	DryRun     bool              `json:"dry_run"`
+	Rows       int               `json:"rows"`
+	Created    int               `json:"created"`
+	Updated    int               `json:"updated"`
+	Unchanged  int               `json:"unchanged"`
+	Skipped    int               `json:"skipped"`
+	ErrorCount int               `json:"error_count"`
+	Errors     []ImportLineError `json:"errors"` // the first 100, ErrorCount has them all
+}
+
+// ImportLineError is a line of the file that can't go in, and why
+type ImportLineError struct {
+	Line  int    `json:"line"`
+	Name  string `json:"name,omitempty"`
+	Error string `json:"error"`
+}
+
+// CacheStats is what GET /admin/cache shows
+type CacheStats struct {
+	Entries      int        `json:"entries"`
+	Hits         uint64     `json:"hits"`
+	Misses       uint64     `json:"misses"`
+	HitRatio     float64    `json:"hit_ratio"`
+	OldestExpiry *time.Time `json:"oldest_expiry"`
+	NewestExpiry *time.Time `json:"newest_expiry"`
+	SampleKeys   []string   `json:"sample_keys"`
+}
+
+// CacheFlush is what DELETE /admin/cache did: Scope is all, prefix or key
+type CacheFlush struct {
+	Scope   string `json:"scope"`
+	Flushed int    `json:"flushed"`
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"strconv"
+)
+
+// CreateWebhookInput subscribes URL to the events in EventTypes, every event if empty
+// Secret signs the deliveries; leave it empty and the server makes one
+type CreateWebhookInput struct {
+	URL        string      `json:"url"`
+	EventTypes []EventType `json:"event_types"`
+	Secret     string      `json:"secret,omitempty"`
+}
+
+// DeliveryOptions filters and pages a webhook's deliveries: DeliveryDead finds the
+// dead letters, empty means all of them
+type DeliveryOptions struct {
+	Status   DeliveryStatus
+	Page     int
+	PageSize int
+}
+
+func webhookPath(id int64) string {
+	return "/v1/webhooks/" + strconv.FormatInt(id, 10)
+}
+
+// CreateWebhook subscribes a URL to the change events. The Webhook it returns is the
+// only one with the Secret, keep it. Like every webhook call it needs Options.AdminToken
+func (c *Client) CreateWebhook(ctx context.Context, in CreateWebhookInput) (*Webhook, error) {
+	req, err := jsonRequest(http.MethodPost, "/v1/webhooks", in)
+	if err != nil {
+		return nil, err
+	}
+	var webhook Webhook
+	if err := c.call(ctx, req, "webhook", &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks returns every webhook, there's no paging
+func (c *Client) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	var webhooks []*Webhook
+	if err := c.call(ctx, request{method: http.MethodGet, path: "/v1/webhooks"}, "webhooks", &webhooks); err != nil {
+		return nil, err
+	}
+	return webhooks, nil
+}
+
+func (c *Client) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	var webhook Webhook
+	if err := c.call(ctx, request{method: http.MethodGet, path: webhookPath(id)}, "webhook", &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// DeleteWebhook unsubscribes, what was still pending for it doesn't go out
+func (c *Client) DeleteWebhook(ctx context.Context, id int64) error {
+	return c.call(ctx, request{method: http.MethodDelete, path: webhookPath(id)}, "", nil)
+}
+
+// WebhookDeliveries returns one page of a webhook's delivery log, newest first
+func (c *Client) WebhookDeliveries(ctx context.Context, id int64, opts DeliveryOptions) (*DeliveryPage, error) {
+	query := pageQuery(opts.Page, opts.PageSize)
+	if opts.Status != "" {
+		query.Set("status", string(opts.Status))
+	}
+
+	var page DeliveryPage
+	req := request{method: http.MethodGet, path: webhookPath(id) + "/deliveries", query: query}
+	if err := c.call(ctx, req, "data", &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// AllWebhookDeliveries goes through a webhook's whole delivery log, newest first
+func (c *Client) AllWebhookDeliveries(ctx context.Context, id int64, opts DeliveryOptions) iter.Seq2[*WebhookDelivery, error] {
+	return paginate(opts.Page, func(page int) ([]*WebhookDelivery, Metadata, error) {
+		opts.Page = page
+		p, err := c.WebhookDeliveries(ctx, id, opts)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		return p.Deliveries, p.Metadata, nil
+	})
+}
+
+// RetryWebhookDelivery brings a dead delivery back with a fresh set of attempts
+func (c *Client) RetryWebhookDelivery(ctx context.Context, id, deliveryID int64) error {
+	path := webhookPath(id) + "/deliveries/" + strconv.FormatInt(deliveryID, 10) + "/retry"
+	return c.call(ctx, request{method: http.MethodPost, path: path}, "", nil)
+}
@@ -1,15 +0,0 @@
-#!/bin/sh
-
-set -e
-
-host="$1"
-shift
-cmd="$@"
-
-until mysql -h "$host" -u "appuser" -p"appusersecret" -e 'SELECT 1'; do
-  >&2 echo "MySQL is unavailable - sleeping"
-  sleep 1
-done
-
->&2 echo "MySQL is up - executing command"
-exec $cmd
\ No newline at end of file